@@ -1,14 +1,24 @@
 package main
 
 import (
+	"encoding/json"
+	"flag"
+	"fmt"
 	"log"
+	"os"
 
+	"github.com/back2nix/go-arg-propagation/pkg/analyzer"
 	"github.com/back2nix/go-arg-propagation/pkg/coordinator"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		runCommand(os.Args[1], os.Args[2:])
+		return
+	}
+
 	coordinator := coordinator.NewMainCoordinator()
-	err := coordinator.AddArgumentToFunction(
+	changes, err := coordinator.AddArgumentToFunction(
 		"./example/change_me.go",
 		"untouchedFunction",
 		"my_new_arg",
@@ -17,4 +27,59 @@ func main() {
 	if err != nil {
 		log.Fatalf("Error adding argument to %v", err)
 	}
+	for _, change := range changes {
+		log.Printf("%s:%d:%d: %s: %s", change.File, change.Line, change.Column, change.Kind, change.Text)
+	}
+}
+
+// runCommand runs a single analysis and prints its result, as JSON unless
+// the command has its own output format
+func runCommand(name string, args []string) {
+	mc := coordinator.NewMainCoordinator()
+
+	var result interface{}
+	switch name {
+	case "callgraph":
+		// callgraph [flags] file
+		flags := flag.NewFlagSet(name, flag.ExitOnError)
+		scope := flags.String("scope", "package", "file, package or module")
+		format := flags.String("format", "dot", "dot, json or mermaid")
+		root := flags.String("root", "", "only show functions reachable from this one")
+		depth := flags.Int("depth", 0, "maximum call depth from root, 0 for no limit")
+		boundary := flags.String("boundary", "module", "none, module or package")
+		flags.Parse(args)
+		if flags.NArg() != 1 {
+			log.Fatalf("Usage: callgraph [flags] <file>")
+		}
+
+		output, err := mc.ExportCallGraph(flags.Arg(0), coordinator.GraphScope(*scope), analyzer.GraphFilter{
+			Root:     *root,
+			Depth:    *depth,
+			Boundary: analyzer.Boundary(*boundary),
+		}, analyzer.GraphFormat(*format))
+		if err != nil {
+			log.Fatalf("Error exporting call graph: %v", err)
+		}
+		fmt.Print(output)
+		return
+	case "unused-params":
+		// unused-params [file]: any file of the module to analyze
+		path := "./main.go"
+		if len(args) > 0 {
+			path = args[0]
+		}
+		chains, err := mc.FindUnusedParams(path)
+		if err != nil {
+			log.Fatalf("Error finding unused parameters: %v", err)
+		}
+		result = chains
+	default:
+		log.Fatalf("Unknown command %q", name)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(result); err != nil {
+		log.Fatalf("Error encoding result: %v", err)
+	}
 }
@@ -5,17 +5,23 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/neovim/go-client/nvim"
 
+	"github.com/back2nix/go-arg-propagation/pkg/analyzer"
 	"github.com/back2nix/go-arg-propagation/pkg/coordinator"
+	"github.com/back2nix/go-arg-propagation/pkg/report"
 )
 
 type Result struct {
-	Success bool   `json:"success"`
-	Message string `json:"message"`
-	Error   string `json:"error,omitempty"`
+	Success bool            `json:"success"`
+	Message string          `json:"message"`
+	Error   string          `json:"error,omitempty"`
+	Changes []report.Change `json:"changes,omitempty"`
+	// Chains is filled by unusedParams only
+	Chains []analyzer.UnusedChain `json:"chains,omitempty"`
 }
 
 func addArgument(v *nvim.Nvim, args []string) (string, error) {
@@ -25,60 +31,331 @@ func addArgument(v *nvim.Nvim, args []string) (string, error) {
 	argName := args[0]
 	argType := args[1]
 
-	buffer, err := v.CurrentBuffer()
+	bufferName, funcName, err := functionUnderCursor(v)
 	if err != nil {
-		return encodeResult(false, "", fmt.Sprintf("Failed to get current buffer: %v", err))
+		return encodeResult(false, "", err.Error())
 	}
 
-	window, err := v.CurrentWindow()
+	coordinator := newCoordinator()
+	changes, err := coordinator.AddArgumentToFunction(bufferName, funcName, argName, argType)
 	if err != nil {
-		return encodeResult(false, "", fmt.Sprintf("Failed to get current window: %v", err))
+		return encodeResult(false, "", fmt.Sprintf("Error adding argument: %v", err))
 	}
 
-	cursor, err := v.WindowCursor(window)
+	// Обновляем буфер
+	if err := v.Command("edit!"); err != nil {
+		return encodeResult(false, "", fmt.Sprintf("Failed to refresh buffer: %v", err))
+	}
+
+	return encodeResult(
+		true,
+		fmt.Sprintf("Successfully added argument '%s' of type '%s' to function '%s'", argName, argType, funcName),
+		"",
+		changes...,
+	)
+}
+
+func addContext(v *nvim.Nvim, args []string) (string, error) {
+	bufferName, funcName, err := functionUnderCursor(v)
 	if err != nil {
-		return encodeResult(false, "", fmt.Sprintf("Failed to get cursor position: %v", err))
+		return encodeResult(false, "", err.Error())
 	}
 
-	lines, err := v.BufferLines(buffer, cursor[0]-1, cursor[0], true)
-	if err != nil || len(lines) == 0 {
-		return encodeResult(false, "", fmt.Sprintf("Failed to get current line: %v", err))
+	coordinator := newCoordinator()
+	changes, err := coordinator.AddContextToFunction(bufferName, funcName)
+	if err != nil {
+		return encodeResult(false, "", fmt.Sprintf("Error adding context: %v", err))
 	}
-	line := string(lines[0])
 
-	funcName := extractFunctionName(line, cursor[1])
-	if funcName == "" {
-		return encodeResult(false, "", "Couldn't find word under cursor")
+	if err := v.Command("edit!"); err != nil {
+		return encodeResult(false, "", fmt.Sprintf("Failed to refresh buffer: %v", err))
 	}
 
-	bufferName, err := v.BufferName(buffer)
+	return encodeResult(
+		true,
+		fmt.Sprintf("Successfully threaded context.Context through '%s'", funcName),
+		"",
+		changes...,
+	)
+}
+
+func injectField(v *nvim.Nvim, args []string) (string, error) {
+	if len(args) != 2 {
+		return encodeResult(false, "", "Usage: InjectField <field_name> <field_type>")
+	}
+	fieldName := args[0]
+	fieldType := args[1]
+
+	bufferName, funcName, err := functionUnderCursor(v)
 	if err != nil {
-		return encodeResult(false, "", fmt.Sprintf("Failed to get buffer name: %v", err))
+		return encodeResult(false, "", err.Error())
 	}
 
-	coordinator := coordinator.NewMainCoordinator()
-	err = coordinator.AddArgumentToFunction(bufferName, funcName, argName, argType)
+	coordinator := newCoordinator()
+	changes, err := coordinator.InjectFieldIntoReceiver(bufferName, funcName, fieldName, fieldType)
 	if err != nil {
-		return encodeResult(false, "", fmt.Sprintf("Error adding argument: %v", err))
+		return encodeResult(false, "", fmt.Sprintf("Error injecting field: %v", err))
 	}
 
-	// Обновляем буфер
 	if err := v.Command("edit!"); err != nil {
 		return encodeResult(false, "", fmt.Sprintf("Failed to refresh buffer: %v", err))
 	}
 
 	return encodeResult(
 		true,
-		fmt.Sprintf("Successfully added argument '%s' of type '%s' to function '%s'", argName, argType, funcName),
+		fmt.Sprintf("Successfully injected field '%s' of type '%s' for method '%s'", fieldName, fieldType, funcName),
+		"",
+		changes...,
+	)
+}
+
+// addErrorReturn takes an optional maximum depth followed by the names of the
+// functions where propagation has to stop
+func addErrorReturn(v *nvim.Nvim, args []string) (string, error) {
+	maxDepth := 0
+	if len(args) > 0 {
+		if depth, err := strconv.Atoi(args[0]); err == nil {
+			maxDepth = depth
+			args = args[1:]
+		}
+	}
+	stopAt := args
+
+	bufferName, funcName, err := functionUnderCursor(v)
+	if err != nil {
+		return encodeResult(false, "", err.Error())
+	}
+
+	coordinator := newCoordinator()
+	changes, err := coordinator.AddErrorReturn(bufferName, funcName, maxDepth, stopAt)
+	if err != nil {
+		return encodeResult(false, "", fmt.Sprintf("Error adding error return: %v", err))
+	}
+
+	if err := v.Command("edit!"); err != nil {
+		return encodeResult(false, "", fmt.Sprintf("Failed to refresh buffer: %v", err))
+	}
+
+	return encodeResult(
+		true,
+		fmt.Sprintf("Successfully added error return to function '%s'", funcName),
+		"",
+		changes...,
+	)
+}
+
+func unusedParams(v *nvim.Nvim, args []string) (string, error) {
+	bufferName, err := currentBufferName(v)
+	if err != nil {
+		return encodeResult(false, "", err.Error())
+	}
+
+	coordinator := newCoordinator()
+	chains, err := coordinator.FindUnusedParams(bufferName)
+	if err != nil {
+		return encodeResult(false, "", fmt.Sprintf("Error finding unused parameters: %v", err))
+	}
+
+	jsonResult, err := json.Marshal(Result{
+		Success: true,
+		Message: fmt.Sprintf("Found %d unused parameter chains", len(chains)),
+		Chains:  chains,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode result: %v", err)
+	}
+	return string(jsonResult), nil
+}
+
+// removeUnusedParams takes a chain as returned by unusedParams, encoded as JSON
+func removeUnusedParams(v *nvim.Nvim, args []string) (string, error) {
+	if len(args) != 1 {
+		return encodeResult(false, "", "Usage: removeUnusedParams <chain_json>")
+	}
+	var chain analyzer.UnusedChain
+	if err := json.Unmarshal([]byte(args[0]), &chain); err != nil || len(chain.Params) == 0 {
+		return encodeResult(false, "", fmt.Sprintf("Invalid chain: %v", err))
+	}
+
+	unsaved, err := unsavedBuffers(v)
+	if err != nil {
+		return encodeResult(false, "", err.Error())
+	}
+
+	coordinator := newCoordinator()
+	coordinator.SetUnsavedFiles(unsaved)
+	changes, err := coordinator.RemoveUnusedParams(chain.Params[0].File, chain)
+	if err != nil {
+		return encodeResult(false, "", fmt.Sprintf("Error removing parameters: %v", err))
+	}
+
+	if err := v.Command("checktime"); err != nil {
+		return encodeResult(false, "", fmt.Sprintf("Failed to refresh buffers: %v", err))
+	}
+
+	return encodeResult(
+		true,
+		fmt.Sprintf("Successfully removed %d unused parameters", len(chain.Params)),
 		"",
+		changes...,
 	)
 }
 
-func encodeResult(success bool, message, errMsg string) (string, error) {
+// callGraph takes key=value options: scope (file, package, module), format
+// (dot, json, mermaid), root, depth and boundary (none, module, package).
+// The graph is opened in a new scratch buffer.
+func callGraph(v *nvim.Nvim, args []string) (string, error) {
+	options := map[string]string{"scope": "package", "format": "dot", "boundary": "module"}
+	for _, arg := range args {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			return encodeResult(false, "", fmt.Sprintf("Invalid option %q, expected key=value", arg))
+		}
+		options[key] = value
+	}
+	depth := 0
+	if value, ok := options["depth"]; ok {
+		var err error
+		if depth, err = strconv.Atoi(value); err != nil {
+			return encodeResult(false, "", fmt.Sprintf("Invalid depth %q", value))
+		}
+	}
+
+	bufferName, err := currentBufferName(v)
+	if err != nil {
+		return encodeResult(false, "", err.Error())
+	}
+
+	mc := newCoordinator()
+	output, err := mc.ExportCallGraph(bufferName, coordinator.GraphScope(options["scope"]), analyzer.GraphFilter{
+		Root:     options["root"],
+		Depth:    depth,
+		Boundary: analyzer.Boundary(options["boundary"]),
+	}, analyzer.GraphFormat(options["format"]))
+	if err != nil {
+		return encodeResult(false, "", fmt.Sprintf("Error exporting call graph: %v", err))
+	}
+
+	if err := openScratchBuffer(v, output, options["format"]); err != nil {
+		return encodeResult(false, "", fmt.Sprintf("Failed to open call graph: %v", err))
+	}
+	return encodeResult(true, fmt.Sprintf("Call graph of %s opened", options["scope"]), "")
+}
+
+// openScratchBuffer shows text in a new split that is not backed by a file
+func openScratchBuffer(v *nvim.Nvim, text, filetype string) error {
+	if err := v.Command("new"); err != nil {
+		return err
+	}
+	buffer, err := v.CurrentBuffer()
+	if err != nil {
+		return err
+	}
+	for name, value := range map[string]interface{}{
+		"buftype":   "nofile",
+		"bufhidden": "wipe",
+		"swapfile":  false,
+		"filetype":  filetype,
+	} {
+		if err := v.SetBufferOption(buffer, name, value); err != nil {
+			return err
+		}
+	}
+	var lines [][]byte
+	for _, line := range strings.Split(strings.TrimSuffix(text, "\n"), "\n") {
+		lines = append(lines, []byte(line))
+	}
+	return v.SetBufferLines(buffer, 0, -1, true, lines)
+}
+
+// newCoordinator creates a coordinator that works on files being edited,
+// syntax errors outside of the changed code are tolerated
+func newCoordinator() *coordinator.MainCoordinator {
+	mc := coordinator.NewMainCoordinator()
+	mc.SetTolerant(true)
+	return mc
+}
+
+func currentBufferName(v *nvim.Nvim) (string, error) {
+	buffer, err := v.CurrentBuffer()
+	if err != nil {
+		return "", fmt.Errorf("Failed to get current buffer: %v", err)
+	}
+	bufferName, err := v.BufferName(buffer)
+	if err != nil {
+		return "", fmt.Errorf("Failed to get buffer name: %v", err)
+	}
+	return bufferName, nil
+}
+
+// unsavedBuffers returns the names of the buffers with unsaved changes
+func unsavedBuffers(v *nvim.Nvim) ([]string, error) {
+	buffers, err := v.Buffers()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list buffers: %v", err)
+	}
+	var names []string
+	for _, buffer := range buffers {
+		var modified bool
+		if err := v.BufferOption(buffer, "modified", &modified); err != nil {
+			return nil, fmt.Errorf("Failed to get buffer option: %v", err)
+		}
+		if !modified {
+			continue
+		}
+		name, err := v.BufferName(buffer)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to get buffer name: %v", err)
+		}
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// functionUnderCursor returns the current buffer name and the function name under the cursor
+func functionUnderCursor(v *nvim.Nvim) (string, string, error) {
+	buffer, err := v.CurrentBuffer()
+	if err != nil {
+		return "", "", fmt.Errorf("Failed to get current buffer: %v", err)
+	}
+
+	window, err := v.CurrentWindow()
+	if err != nil {
+		return "", "", fmt.Errorf("Failed to get current window: %v", err)
+	}
+
+	cursor, err := v.WindowCursor(window)
+	if err != nil {
+		return "", "", fmt.Errorf("Failed to get cursor position: %v", err)
+	}
+
+	lines, err := v.BufferLines(buffer, cursor[0]-1, cursor[0], true)
+	if err != nil || len(lines) == 0 {
+		return "", "", fmt.Errorf("Failed to get current line: %v", err)
+	}
+	line := string(lines[0])
+
+	funcName := extractFunctionName(line, cursor[1])
+	if funcName == "" {
+		return "", "", fmt.Errorf("Couldn't find word under cursor")
+	}
+
+	bufferName, err := v.BufferName(buffer)
+	if err != nil {
+		return "", "", fmt.Errorf("Failed to get buffer name: %v", err)
+	}
+
+	return bufferName, funcName, nil
+}
+
+func encodeResult(success bool, message, errMsg string, changes ...report.Change) (string, error) {
 	result := Result{
 		Success: success,
 		Message: message,
 		Error:   errMsg,
+		Changes: changes,
 	}
 	jsonResult, err := json.Marshal(result)
 	if err != nil {
@@ -133,6 +410,12 @@ func main() {
 	}
 
 	v.RegisterHandler("addArgument", addArgument)
+	v.RegisterHandler("addContext", addContext)
+	v.RegisterHandler("injectField", injectField)
+	v.RegisterHandler("addErrorReturn", addErrorReturn)
+	v.RegisterHandler("unusedParams", unusedParams)
+	v.RegisterHandler("removeUnusedParams", removeUnusedParams)
+	v.RegisterHandler("callGraph", callGraph)
 
 	if err := v.Serve(); err != nil {
 		log.Fatal(err)
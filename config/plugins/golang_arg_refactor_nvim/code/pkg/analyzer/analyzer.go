@@ -1,10 +1,14 @@
 package analyzer
 
 import (
+	"errors"
 	"fmt"
 	"go/ast"
 	"go/parser"
+	"go/scanner"
 	"go/token"
+	"sort"
+	"strings"
 
 	"github.com/back2nix/go-arg-propagation/pkg/logger"
 )
@@ -14,6 +18,8 @@ type CallChainAnalyzer struct {
 	anonFuncs    map[string]string
 	reverseCalls map[string][]string
 	fset         *token.FileSet
+	// tolerant keeps analyzing the partial AST of files with syntax errors
+	tolerant bool
 }
 
 func NewCallChainAnalyzer(fset *token.FileSet) *CallChainAnalyzer {
@@ -25,6 +31,12 @@ func NewCallChainAnalyzer(fset *token.FileSet) *CallChainAnalyzer {
 	}
 }
 
+// SetTolerant makes AnalyzeCallChain accept files with syntax errors and work
+// on the partial AST the parser recovers
+func (a *CallChainAnalyzer) SetTolerant(tolerant bool) {
+	a.tolerant = tolerant
+}
+
 func removeMain(chain []string) []string {
 	for i, v := range chain {
 		if v == "main" {
@@ -39,7 +51,11 @@ func (a *CallChainAnalyzer) AnalyzeCallChain(src []byte, targetFunc string) ([]s
 
 	file, err := parser.ParseFile(a.fset, "", src, parser.AllErrors)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse file: %w", err)
+		var syntaxErrors scanner.ErrorList
+		if !a.tolerant || file == nil || !errors.As(err, &syntaxErrors) {
+			return nil, fmt.Errorf("failed to parse file: %w", err)
+		}
+		logger.Log.DebugPrintf("[CallChainAnalyzer] Tolerating %d syntax errors", len(syntaxErrors))
 	}
 
 	a.buildCallGraph(file)
@@ -125,6 +141,26 @@ func (a *CallChainAnalyzer) getCalleeName(expr ast.Expr) string {
 	return ""
 }
 
+// Callers returns the functions that call funcName directly. Method calls
+// (x.Method) are matched by the method name.
+func (a *CallChainAnalyzer) Callers(funcName string) []string {
+	var result []string
+	seen := make(map[string]bool)
+	for callee, callers := range a.reverseCalls {
+		if callee != funcName && !strings.HasSuffix(callee, "."+funcName) {
+			continue
+		}
+		for _, caller := range callers {
+			if !seen[caller] {
+				seen[caller] = true
+				result = append(result, caller)
+			}
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
 func (a *CallChainAnalyzer) findCompleteCallChain(target string) []string {
 	var result []string
 	visited := make(map[string]bool)
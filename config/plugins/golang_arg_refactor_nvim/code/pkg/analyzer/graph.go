@@ -0,0 +1,315 @@
+package analyzer
+
+import (
+	"fmt"
+	"go/ast"
+	"go/types"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/back2nix/go-arg-propagation/pkg/logger"
+)
+
+// Node is a function of the call graph
+type Node struct {
+	ID      string `json:"id"`
+	Package string `json:"package"`
+	// Name is the function name, or Type.Method for methods
+	Name string `json:"name"`
+	File string `json:"file,omitempty"`
+	Line int    `json:"line,omitempty"`
+	// External nodes are called but not declared in the analyzed files
+	External bool `json:"external,omitempty"`
+}
+
+// Label is the name shown in diagrams: the package name and the function
+func (n Node) Label() string {
+	return path.Base(n.Package) + "." + n.Name
+}
+
+// Edge is a call from one node to another
+type Edge struct {
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Calls int    `json:"calls"`
+}
+
+// Graph is a call graph with nodes and edges in a stable order
+type Graph struct {
+	Nodes []Node `json:"nodes"`
+	Edges []Edge `json:"edges"`
+	// callees maps each resolved call to the function it calls
+	callees map[*ast.CallExpr]Node
+}
+
+// Boundary limits which callees are kept in a filtered graph
+type Boundary string
+
+const (
+	// BoundaryNone keeps every callee, including external packages
+	BoundaryNone Boundary = "none"
+	// BoundaryModule keeps functions declared in the analyzed files
+	BoundaryModule Boundary = "module"
+	// BoundaryPackage keeps functions of GraphFilter.Package only
+	BoundaryPackage Boundary = "package"
+)
+
+// GraphFilter selects a part of a call graph
+type GraphFilter struct {
+	// Root limits the graph to functions reachable from it
+	Root string
+	// Depth limits how many calls away from Root nodes may be, 0 means no limit
+	Depth int
+	// Boundary defaults to BoundaryNone when empty
+	Boundary Boundary
+	// Package is the package Root is looked up in and BoundaryPackage keeps
+	Package string
+}
+
+// BuildGraph builds the call graph of files. Calls made inside function
+// literals are attributed to the enclosing declaration.
+func (a *CallChainAnalyzer) BuildGraph(files []SourceFile) *Graph {
+	graph := &Graph{callees: make(map[*ast.CallExpr]Node)}
+	nodes := make(map[string]Node)
+	calls := make(map[[2]string]int)
+
+	for _, source := range files {
+		for _, decl := range source.File.Decls {
+			funcDecl, ok := decl.(*ast.FuncDecl)
+			if !ok {
+				continue
+			}
+			name := funcDecl.Name.Name
+			if typeName := receiverType(funcDecl); typeName != "" {
+				name = typeName + "." + name
+			}
+			pos := a.fset.Position(funcDecl.Name.Pos())
+			from := Node{ID: source.Package + "." + name, Package: source.Package, Name: name, File: source.Path, Line: pos.Line}
+			nodes[from.ID] = from
+
+			if funcDecl.Body == nil {
+				continue
+			}
+			ast.Inspect(funcDecl.Body, func(n ast.Node) bool {
+				call, ok := n.(*ast.CallExpr)
+				if !ok {
+					return true
+				}
+				to, ok := a.graphCallee(source, call.Fun)
+				if !ok {
+					return true
+				}
+				if _, exists := nodes[to.ID]; !exists {
+					nodes[to.ID] = to
+				}
+				calls[[2]string{from.ID, to.ID}]++
+				graph.callees[call] = to
+				return true
+			})
+		}
+	}
+
+	for _, node := range nodes {
+		graph.Nodes = append(graph.Nodes, node)
+	}
+	for key, count := range calls {
+		graph.Edges = append(graph.Edges, Edge{From: key[0], To: key[1], Calls: count})
+	}
+	graph.sort()
+
+	logger.Log.DebugPrintf("[CallChainAnalyzer] Graph: %d nodes, %d edges", len(graph.Nodes), len(graph.Edges))
+	return graph
+}
+
+// graphCallee names the function called by expr. Calls of local functions and
+// package functions are resolved by name, method calls by the static type of
+// their receiver. Calls that cannot be resolved, e.g. of function values or
+// with receivers that don't type-check, are left out.
+func (a *CallChainAnalyzer) graphCallee(source SourceFile, expr ast.Expr) (Node, bool) {
+	switch x := expr.(type) {
+	case *ast.Ident:
+		if x.Obj != nil && x.Obj.Kind != ast.Fun {
+			return Node{}, false
+		}
+		if isBuiltin(x.Name) && x.Obj == nil {
+			return Node{}, false
+		}
+		return Node{ID: source.Package + "." + x.Name, Package: source.Package, Name: x.Name, External: true}, true
+	case *ast.SelectorExpr:
+		if ident, ok := x.X.(*ast.Ident); ok && ident.Obj == nil {
+			if pkg, ok := source.Imports[ident.Name]; ok {
+				return Node{ID: pkg + "." + x.Sel.Name, Package: pkg, Name: x.Sel.Name, External: true}, true
+			}
+		}
+		return methodNode(source.Info, x)
+	}
+	return Node{}, false
+}
+
+// methodNode names the method selected by x after the type declaring it,
+// e.g. store.Store.Save for s.Save with s of type *store.Store
+func methodNode(info *types.Info, x *ast.SelectorExpr) (Node, bool) {
+	if info == nil {
+		return Node{}, false
+	}
+	selection, ok := info.Selections[x]
+	if !ok || selection.Kind() == types.FieldVal {
+		return Node{}, false
+	}
+	method, ok := selection.Obj().(*types.Func)
+	if !ok {
+		return Node{}, false
+	}
+	recv := method.Type().(*types.Signature).Recv()
+	if recv == nil {
+		return Node{}, false
+	}
+	recvType := recv.Type()
+	if pointer, ok := recvType.(*types.Pointer); ok {
+		recvType = pointer.Elem()
+	}
+	named, ok := recvType.(*types.Named)
+	if !ok || named.Obj().Pkg() == nil {
+		return Node{}, false
+	}
+	pkg := named.Obj().Pkg().Path()
+	name := named.Obj().Name() + "." + method.Name()
+	return Node{ID: pkg + "." + name, Package: pkg, Name: name, External: true}, true
+}
+
+// Filter returns the part of the graph selected by filter
+func (g *Graph) Filter(filter GraphFilter) (*Graph, error) {
+	switch filter.Boundary {
+	case "", BoundaryNone, BoundaryModule, BoundaryPackage:
+	default:
+		return nil, fmt.Errorf("unknown boundary %q, expected none, module or package", filter.Boundary)
+	}
+
+	byID := make(map[string]Node)
+	for _, node := range g.Nodes {
+		byID[node.ID] = node
+	}
+
+	keep := func(node Node) bool {
+		switch filter.Boundary {
+		case BoundaryModule:
+			return !node.External
+		case BoundaryPackage:
+			return !node.External && node.Package == filter.Package
+		}
+		return true
+	}
+
+	var edges []Edge
+	for _, edge := range g.Edges {
+		if keep(byID[edge.From]) && keep(byID[edge.To]) {
+			edges = append(edges, edge)
+		}
+	}
+
+	included := make(map[string]bool)
+	if filter.Root == "" {
+		for _, node := range g.Nodes {
+			if keep(node) {
+				included[node.ID] = true
+			}
+		}
+	} else {
+		var queue []string
+		for _, node := range g.Nodes {
+			if keep(node) && matchesRoot(node, filter) {
+				included[node.ID] = true
+				queue = append(queue, node.ID)
+			}
+		}
+		if len(queue) == 0 {
+			return nil, fmt.Errorf("function %s not found", filter.Root)
+		}
+
+		for depth := 1; len(queue) > 0 && (filter.Depth <= 0 || depth <= filter.Depth); depth++ {
+			var next []string
+			for _, id := range queue {
+				for _, edge := range edges {
+					if edge.From == id && !included[edge.To] {
+						included[edge.To] = true
+						next = append(next, edge.To)
+					}
+				}
+			}
+			queue = next
+		}
+	}
+
+	result := &Graph{}
+	for _, node := range g.Nodes {
+		if included[node.ID] {
+			result.Nodes = append(result.Nodes, node)
+		}
+	}
+	for _, edge := range edges {
+		if included[edge.From] && included[edge.To] {
+			result.Edges = append(result.Edges, edge)
+		}
+	}
+	return result, nil
+}
+
+// matchesRoot accepts the node ID, Name or the bare method name
+func matchesRoot(node Node, filter GraphFilter) bool {
+	if node.ID == filter.Root {
+		return true
+	}
+	if filter.Package != "" && node.Package != filter.Package {
+		return false
+	}
+	return node.Name == filter.Root || strings.HasSuffix(node.Name, "."+filter.Root)
+}
+
+func (g *Graph) sort() {
+	sort.Slice(g.Nodes, func(i, j int) bool { return g.Nodes[i].ID < g.Nodes[j].ID })
+	sort.Slice(g.Edges, func(i, j int) bool {
+		if g.Edges[i].From != g.Edges[j].From {
+			return g.Edges[i].From < g.Edges[j].From
+		}
+		return g.Edges[i].To < g.Edges[j].To
+	})
+}
+
+// receiverType returns T for methods declared on T or *T
+func receiverType(funcDecl *ast.FuncDecl) string {
+	if funcDecl.Recv == nil || len(funcDecl.Recv.List) == 0 {
+		return ""
+	}
+	expr := funcDecl.Recv.List[0].Type
+	if star, ok := expr.(*ast.StarExpr); ok {
+		expr = star.X
+	}
+	switch x := expr.(type) {
+	case *ast.Ident:
+		return x.Name
+	case *ast.IndexExpr:
+		if ident, ok := x.X.(*ast.Ident); ok {
+			return ident.Name
+		}
+	case *ast.IndexListExpr:
+		if ident, ok := x.X.(*ast.Ident); ok {
+			return ident.Name
+		}
+	}
+	return ""
+}
+
+// isBuiltin reports whether name is a builtin function or a conversion to a
+// predeclared type
+func isBuiltin(name string) bool {
+	switch name {
+	case "append", "cap", "clear", "close", "complex", "copy", "delete", "imag", "len",
+		"make", "max", "min", "new", "panic", "print", "println", "real", "recover",
+		"bool", "byte", "complex64", "complex128", "error", "float32", "float64",
+		"int", "int8", "int16", "int32", "int64", "rune", "string",
+		"uint", "uint8", "uint16", "uint32", "uint64", "uintptr", "any":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,82 @@
+package analyzer
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// GraphFormat is an output format of WriteGraph
+type GraphFormat string
+
+const (
+	FormatDOT     GraphFormat = "dot"
+	FormatJSON    GraphFormat = "json"
+	FormatMermaid GraphFormat = "mermaid"
+)
+
+// WriteGraph writes g to w in the given format
+func WriteGraph(w io.Writer, g *Graph, format GraphFormat) error {
+	switch format {
+	case FormatDOT:
+		return writeDOT(w, g)
+	case FormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(g)
+	case FormatMermaid:
+		return writeMermaid(w, g)
+	}
+	return fmt.Errorf("unknown graph format %q", format)
+}
+
+// writeDOT writes a Graphviz digraph, external functions are drawn dashed
+func writeDOT(w io.Writer, g *Graph) error {
+	out := bufio.NewWriter(w)
+	fmt.Fprintln(out, "digraph calls {")
+	fmt.Fprintln(out, "\trankdir=LR;")
+	fmt.Fprintln(out, "\tnode [shape=box];")
+	for _, node := range g.Nodes {
+		attrs := "label=" + strconv.Quote(node.Label())
+		if node.External {
+			attrs += ", style=dashed"
+		}
+		fmt.Fprintf(out, "\t%s [%s];\n", strconv.Quote(node.ID), attrs)
+	}
+	for _, edge := range g.Edges {
+		fmt.Fprintf(out, "\t%s -> %s", strconv.Quote(edge.From), strconv.Quote(edge.To))
+		if edge.Calls > 1 {
+			fmt.Fprintf(out, " [label=\"%d\"]", edge.Calls)
+		}
+		fmt.Fprintln(out, ";")
+	}
+	fmt.Fprintln(out, "}")
+	return out.Flush()
+}
+
+// writeMermaid writes a flowchart. Mermaid IDs can't hold the characters of
+// import paths, so nodes are numbered and the name goes into the label.
+func writeMermaid(w io.Writer, g *Graph) error {
+	out := bufio.NewWriter(w)
+	ids := make(map[string]string, len(g.Nodes))
+	fmt.Fprintln(out, "flowchart LR")
+	for i, node := range g.Nodes {
+		id := fmt.Sprintf("n%d", i)
+		ids[node.ID] = id
+		if node.External {
+			fmt.Fprintf(out, "    %s([%q])\n", id, node.Label())
+		} else {
+			fmt.Fprintf(out, "    %s[%q]\n", id, node.Label())
+		}
+	}
+	for _, edge := range g.Edges {
+		if edge.Calls > 1 {
+			fmt.Fprintf(out, "    %s -->|%d| %s\n", ids[edge.From], edge.Calls, ids[edge.To])
+		} else {
+			fmt.Fprintf(out, "    %s --> %s\n", ids[edge.From], ids[edge.To])
+		}
+	}
+	return out.Flush()
+}
@@ -0,0 +1,371 @@
+package analyzer
+
+import (
+	"go/ast"
+	"go/types"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/back2nix/go-arg-propagation/pkg/logger"
+)
+
+// SourceFile is a parsed file of the analyzed module
+type SourceFile struct {
+	Path string
+	// Package identifies the package the file belongs to, e.g. its import path
+	Package string
+	File    *ast.File
+	// Imports maps the names used in the file to the imported package keys.
+	// Packages outside of the analyzed set may be left out.
+	Imports map[string]string
+	// Info holds the type-checked uses and selections, it may be nil or
+	// incomplete for code that does not type-check
+	Info *types.Info
+}
+
+// UnusedParam is a parameter that is never read by its function
+type UnusedParam struct {
+	Package string `json:"package"`
+	Func    string `json:"func"`
+	Name    string `json:"name"`
+	// Index is the position of the parameter in the signature
+	Index  int    `json:"index"`
+	File   string `json:"file"`
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+	// Exported is set for exported functions outside of package main, code
+	// outside of the module may call them too
+	Exported bool `json:"exported,omitempty"`
+}
+
+// UnusedChain is a group of unused parameters connected by pass-through
+// calls. Removing a parameter is only safe together with its whole chain.
+type UnusedChain struct {
+	Params []UnusedParam `json:"params"`
+}
+
+type paramKey struct {
+	pkg   string
+	fn    string
+	index int
+}
+
+type funcKey struct {
+	pkg string
+	fn  string
+}
+
+type paramInfo struct {
+	param UnusedParam
+	// read is set once the parameter is used for anything but passing it on
+	read bool
+	// passedTo lists the parameters this one is forwarded to unchanged
+	passedTo []paramKey
+}
+
+// FindUnusedParams reports parameters that are never read in the body and are
+// only passed on to callees that don't read them either. Methods, program
+// roots and functions used as values are skipped, since their signature is
+// fixed by something other than their callers.
+func (a *CallChainAnalyzer) FindUnusedParams(files []SourceFile) []UnusedChain {
+	graph := a.BuildGraph(files)
+
+	decls := make(map[funcKey]*ast.FuncDecl)
+	for _, source := range files {
+		for _, decl := range source.File.Decls {
+			if funcDecl, ok := decl.(*ast.FuncDecl); ok && funcDecl.Recv == nil && funcDecl.Body != nil {
+				decls[funcKey{source.Package, funcDecl.Name.Name}] = funcDecl
+			}
+		}
+	}
+
+	excluded := make(map[funcKey]bool)
+	for _, source := range files {
+		a.markFuncValues(source, decls, excluded)
+	}
+
+	params := make(map[paramKey]*paramInfo)
+	for _, source := range files {
+		for _, decl := range source.File.Decls {
+			funcDecl, ok := decl.(*ast.FuncDecl)
+			if !ok || funcDecl.Recv != nil || funcDecl.Body == nil {
+				continue
+			}
+			key := funcKey{source.Package, funcDecl.Name.Name}
+			if excluded[key] || isRootFunc(funcDecl) {
+				continue
+			}
+			a.collectParams(source, funcDecl, decls, graph, params)
+		}
+	}
+
+	// Fixed point: a parameter forwarded to a parameter that is read is read too
+	for changed := true; changed; {
+		changed = false
+		for _, info := range params {
+			if info.read {
+				continue
+			}
+			for _, target := range info.passedTo {
+				if next, ok := params[target]; !ok || next.read {
+					info.read = true
+					changed = true
+					break
+				}
+			}
+		}
+	}
+
+	chains := groupChains(params)
+	logger.Log.DebugPrintf("[CallChainAnalyzer] Unused parameter chains: %d", len(chains))
+	return chains
+}
+
+// collectParams records the parameters of funcDecl and how each of them is
+// used. The callees of forwarding calls are taken from graph.
+func (a *CallChainAnalyzer) collectParams(source SourceFile, funcDecl *ast.FuncDecl, decls map[funcKey]*ast.FuncDecl, graph *Graph, params map[paramKey]*paramInfo) {
+	objects := make(map[*ast.Object]*paramInfo)
+	index := 0
+	for _, field := range funcDecl.Type.Params.List {
+		if len(field.Names) == 0 {
+			index++
+			continue
+		}
+		for _, name := range field.Names {
+			if name.Name != "_" && name.Obj != nil {
+				pos := a.fset.Position(name.Pos())
+				info := &paramInfo{param: UnusedParam{
+					Package:  source.Package,
+					Func:     funcDecl.Name.Name,
+					Name:     name.Name,
+					Index:    index,
+					File:     source.Path,
+					Line:     pos.Line,
+					Column:   pos.Column,
+					Exported: ast.IsExported(funcDecl.Name.Name) && source.File.Name.Name != "main",
+				}}
+				params[paramKey{source.Package, funcDecl.Name.Name, index}] = info
+				objects[name.Obj] = info
+			}
+			index++
+		}
+	}
+	if len(objects) == 0 {
+		return
+	}
+
+	// Uses as a plain argument of a known function are forwards, not reads
+	forwarded := make(map[*ast.Ident]bool)
+	ast.Inspect(funcDecl.Body, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok || call.Ellipsis.IsValid() {
+			return true
+		}
+		node, ok := graph.callees[call]
+		if !ok {
+			return true
+		}
+		callee := funcKey{node.Package, node.Name}
+		calleeDecl, ok := decls[callee]
+		if !ok {
+			return true
+		}
+		for i, arg := range call.Args {
+			ident, ok := arg.(*ast.Ident)
+			if !ok || objects[ident.Obj] == nil || isVariadicAt(calleeDecl, i) {
+				continue
+			}
+			info := objects[ident.Obj]
+			info.passedTo = append(info.passedTo, paramKey{callee.pkg, callee.fn, i})
+			forwarded[ident] = true
+		}
+		return true
+	})
+
+	ast.Inspect(funcDecl.Body, func(n ast.Node) bool {
+		ident, ok := n.(*ast.Ident)
+		if !ok || forwarded[ident] {
+			return true
+		}
+		if info := objects[ident.Obj]; info != nil {
+			info.read = true
+		}
+		return true
+	})
+}
+
+// markFuncValues excludes functions that are referenced other than by a call,
+// e.g. assigned to a variable or passed as a callback
+func (a *CallChainAnalyzer) markFuncValues(source SourceFile, decls map[funcKey]*ast.FuncDecl, excluded map[funcKey]bool) {
+	// Callees, declared names, field names and literal keys are not values
+	skip := make(map[ast.Node]bool)
+	ast.Inspect(source.File, func(n ast.Node) bool {
+		switch x := n.(type) {
+		case *ast.CallExpr:
+			skip[x.Fun] = true
+		case *ast.FuncDecl:
+			skip[x.Name] = true
+		case *ast.SelectorExpr:
+			skip[x.Sel] = true
+		case *ast.CompositeLit:
+			for _, elt := range x.Elts {
+				if kv, ok := elt.(*ast.KeyValueExpr); ok {
+					skip[kv.Key] = true
+				}
+			}
+		}
+		return true
+	})
+
+	ast.Inspect(source.File, func(n ast.Node) bool {
+		switch x := n.(type) {
+		case *ast.SelectorExpr, *ast.Ident:
+			if skip[x] {
+				return true
+			}
+			if key, ok := resolveCallee(source, x.(ast.Expr), decls); ok {
+				excluded[key] = true
+			}
+		}
+		return true
+	})
+}
+
+// resolveCallee maps foo and pkg.Foo to a function declared in the analyzed files
+func resolveCallee(source SourceFile, expr ast.Expr, decls map[funcKey]*ast.FuncDecl) (funcKey, bool) {
+	var key funcKey
+	switch x := expr.(type) {
+	case *ast.Ident:
+		// Local variables shadowing the function are not calls to it
+		if x.Obj != nil && x.Obj.Kind != ast.Fun {
+			return key, false
+		}
+		key = funcKey{source.Package, x.Name}
+	case *ast.SelectorExpr:
+		ident, ok := x.X.(*ast.Ident)
+		if !ok || ident.Obj != nil {
+			return key, false
+		}
+		pkg, ok := source.Imports[ident.Name]
+		if !ok {
+			return key, false
+		}
+		key = funcKey{pkg, x.Sel.Name}
+	default:
+		return key, false
+	}
+	_, ok := decls[key]
+	return key, ok
+}
+
+func isVariadicAt(funcDecl *ast.FuncDecl, index int) bool {
+	fields := funcDecl.Type.Params.List
+	if len(fields) == 0 {
+		return false
+	}
+	if _, ok := fields[len(fields)-1].Type.(*ast.Ellipsis); !ok {
+		return false
+	}
+	count := 0
+	for _, field := range fields {
+		if len(field.Names) == 0 {
+			count++
+		} else {
+			count += len(field.Names)
+		}
+	}
+	return index >= count-1
+}
+
+func isRootFunc(funcDecl *ast.FuncDecl) bool {
+	name := funcDecl.Name.Name
+	if name == "main" || name == "init" {
+		return true
+	}
+	for _, prefix := range []string{"Test", "Benchmark", "Fuzz", "Example"} {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// groupChains joins unused parameters linked by forwards into chains
+func groupChains(params map[paramKey]*paramInfo) []UnusedChain {
+	parent := make(map[paramKey]paramKey)
+	var find func(paramKey) paramKey
+	find = func(k paramKey) paramKey {
+		if p, ok := parent[k]; ok && p != k {
+			root := find(p)
+			parent[k] = root
+			return root
+		}
+		return k
+	}
+
+	for key, info := range params {
+		if info.read {
+			continue
+		}
+		parent[key] = key
+	}
+	for key, info := range params {
+		if info.read {
+			continue
+		}
+		for _, target := range info.passedTo {
+			parent[find(target)] = find(key)
+		}
+	}
+
+	groups := make(map[paramKey][]UnusedParam)
+	for key, info := range params {
+		if !info.read {
+			root := find(key)
+			groups[root] = append(groups[root], info.param)
+		}
+	}
+
+	chains := make([]UnusedChain, 0, len(groups))
+	for _, group := range groups {
+		sort.Slice(group, func(i, j int) bool { return lessParam(group[i], group[j]) })
+		chains = append(chains, UnusedChain{Params: group})
+	}
+	sort.Slice(chains, func(i, j int) bool { return lessParam(chains[i].Params[0], chains[j].Params[0]) })
+	return chains
+}
+
+func lessParam(a, b UnusedParam) bool {
+	if a.File != b.File {
+		return a.File < b.File
+	}
+	if a.Line != b.Line {
+		return a.Line < b.Line
+	}
+	return a.Column < b.Column
+}
+
+// ImportNames maps the names under which file refers to its imports to their
+// paths. packageNames supplies the package names of paths that differ from
+// their last element.
+func ImportNames(file *ast.File, packageNames map[string]string) map[string]string {
+	names := make(map[string]string)
+	for _, imp := range file.Imports {
+		path, err := strconv.Unquote(imp.Path.Value)
+		if err != nil {
+			continue
+		}
+		name := path[strings.LastIndex(path, "/")+1:]
+		if pkgName, ok := packageNames[path]; ok {
+			name = pkgName
+		}
+		if imp.Name != nil {
+			name = imp.Name.Name
+		}
+		if name != "_" && name != "." {
+			names[name] = path
+		}
+	}
+	return names
+}
@@ -1,21 +1,33 @@
 package coordinator
 
 import (
+	"bytes"
 	"fmt"
 	"go/ast"
 	"go/printer"
 	"go/token"
 	"log"
-	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/back2nix/go-arg-propagation/pkg/analyzer"
 	"github.com/back2nix/go-arg-propagation/pkg/filemanager"
 	"github.com/back2nix/go-arg-propagation/pkg/logger"
 	"github.com/back2nix/go-arg-propagation/pkg/modifier"
 	"github.com/back2nix/go-arg-propagation/pkg/parser"
+	"github.com/back2nix/go-arg-propagation/pkg/report"
 	"github.com/back2nix/go-arg-propagation/pkg/traverser"
 )
 
+// GraphScope selects the files a call graph is built from
+type GraphScope string
+
+const (
+	ScopeFile    GraphScope = "file"
+	ScopePackage GraphScope = "package"
+	ScopeModule  GraphScope = "module"
+)
+
 type MainCoordinator struct {
 	analyzer    *analyzer.CallChainAnalyzer
 	parser      *parser.Parser
@@ -23,6 +35,10 @@ type MainCoordinator struct {
 	traverser   *traverser.ASTTraverser
 	astModifier modifier.IASTModifier
 	fset        *token.FileSet
+	// tolerant lets refactorings run on files with syntax errors
+	tolerant bool
+	// unsaved holds the files open in the editor with unsaved changes
+	unsaved map[string]bool
 }
 
 func NewMainCoordinator() *MainCoordinator {
@@ -35,26 +51,48 @@ func NewMainCoordinator() *MainCoordinator {
 	}
 }
 
-func (mc *MainCoordinator) AddArgumentToFunction(filePath, targetFunc, paramName, paramType string) error {
+// SetTolerant lets refactorings run on files with syntax errors as long as the
+// errors are outside of the declarations being changed. The errors that were
+// left in place are reported as report.KindTolerated changes.
+func (mc *MainCoordinator) SetTolerant(tolerant bool) {
+	mc.tolerant = tolerant
+	mc.analyzer.SetTolerant(tolerant)
+}
+
+// SetUnsavedFiles names the files the editor holds unsaved changes of.
+// RemoveUnusedParams changes files across the module and refuses to write
+// them, the unsaved changes would be lost.
+func (mc *MainCoordinator) SetUnsavedFiles(paths []string) {
+	mc.unsaved = make(map[string]bool)
+	for _, path := range paths {
+		if abs, err := filepath.Abs(path); err == nil {
+			mc.unsaved[abs] = true
+		}
+	}
+}
+
+// AddArgumentToFunction adds a parameter to targetFunc and propagates it up the
+// call chain. It returns every declaration and call site that was changed.
+func (mc *MainCoordinator) AddArgumentToFunction(filePath, targetFunc, paramName, paramType string) ([]report.Change, error) {
 	logger.Log.DebugPrintf("Starting AddArgumentToFunction for %s in %s", targetFunc, filePath)
 
 	// Step 1: Read the file
 	src, err := mc.readFile(filePath)
 	if err != nil {
-		return fmt.Errorf("failed to read file: %w", err)
+		return nil, fmt.Errorf("failed to read file: %w", err)
 	}
 
 	// Step 2: Analyze the call chain
 	functionsToModify, err := mc.analyzeCallChain(src, targetFunc)
 	if err != nil {
-		return fmt.Errorf("failed to analyze call chain: %w", err)
+		return nil, fmt.Errorf("failed to analyze call chain: %w", err)
 	}
 	logger.Log.DebugPrintf("Functions to modify: %v", functionsToModify)
 
 	// Step 3: Parse the AST
-	file, err := mc.parseAST(src)
+	file, edit, err := mc.parseAST(src)
 	if err != nil {
-		return fmt.Errorf("failed to parse AST: %w", err)
+		return nil, fmt.Errorf("failed to parse AST: %w", err)
 	}
 
 	// Step 4: Set up the AST modifier
@@ -66,66 +104,359 @@ func (mc *MainCoordinator) AddArgumentToFunction(filePath, targetFunc, paramName
 	// Step 6: Traverse and modify the AST
 	err = mc.traverseAndModifyAST(file, functionsToModify, paramName, paramType)
 	if err != nil {
-		return fmt.Errorf("failed to traverse and modify AST: %w", err)
+		return nil, fmt.Errorf("failed to traverse and modify AST: %w", err)
 	}
 
 	// Step 7: Write the modified AST back to the file
-	err = mc.writeModifiedAST(filePath, file)
+	err = mc.writeModifiedAST(filePath, file, edit)
 	if err != nil {
-		return fmt.Errorf("failed to write modified AST: %w", err)
+		return nil, fmt.Errorf("failed to write modified AST: %w", err)
 	}
 
 	log.Println("Successfully added argument to function and its call chain")
-	return nil
+	return report.WithFile(append(mc.astModifier.Changes(), edit.tolerated(filePath)...), filePath), nil
 }
 
-func (mc *MainCoordinator) readFile(filePath string) ([]byte, error) {
-	return mc.fileManager.ReadFile(filePath)
+// AddContextToFunction threads ctx context.Context from targetFunc up its call
+// chain. Callers at the root of the chain pass r.Context() in HTTP handlers and
+// context.Background() in main and tests.
+func (mc *MainCoordinator) AddContextToFunction(filePath, targetFunc string) ([]report.Change, error) {
+	logger.Log.DebugPrintf("Starting AddContextToFunction for %s in %s", targetFunc, filePath)
+
+	// Step 1: Read the file
+	src, err := mc.readFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	// Step 2: Analyze the call chain
+	functionsToModify, err := mc.analyzeCallChain(src, targetFunc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to analyze call chain: %w", err)
+	}
+	logger.Log.DebugPrintf("Functions to modify: %v", functionsToModify)
+
+	// Step 3: Parse the AST
+	file, edit, err := mc.parseAST(src)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse AST: %w", err)
+	}
+
+	// Step 4: Drop the root contexts the new parameters replace and parse
+	// the file again without them
+	contextModifier := modifier.NewContextModifier(functionsToModify, mc.fset)
+	if trimmed, dropped := contextModifier.DropRootContexts(file, src); dropped {
+		file, edit, err = mc.parseAST(trimmed)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse AST: %w", err)
+		}
+	}
+
+	// Step 5: Thread ctx through the chain
+	contextModifier.SetInfo(mc.packageInfo(filePath, file))
+	if err := contextModifier.Modify(file); err != nil {
+		return nil, fmt.Errorf("failed to thread context: %w", err)
+	}
+
+	// Step 6: Write the modified AST back to the file
+	err = mc.writeModifiedAST(filePath, file, edit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write modified AST: %w", err)
+	}
+
+	log.Println("Successfully threaded context through the call chain")
+	return report.WithFile(append(contextModifier.Changes(), edit.tolerated(filePath)...), filePath), nil
 }
 
-func (mc *MainCoordinator) analyzeCallChain(src []byte, targetFunc string) ([]string, error) {
-	return mc.analyzer.AnalyzeCallChain(src, targetFunc)
+// InjectFieldIntoReceiver adds a dependency as a field on the receiver type of
+// targetFunc. Uses inside the call chain read the field, and constructors of
+// the type receive the dependency as a parameter that is propagated above
+// them like AddArgumentToFunction does.
+func (mc *MainCoordinator) InjectFieldIntoReceiver(filePath, targetFunc, fieldName, fieldType string) ([]report.Change, error) {
+	logger.Log.DebugPrintf("Starting InjectFieldIntoReceiver for %s in %s", targetFunc, filePath)
+
+	// Step 1: Read the file
+	src, err := mc.readFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	// Step 2: Analyze the call chain, the analyzer knows methods by name
+	methodName := targetFunc[strings.LastIndex(targetFunc, ".")+1:]
+	functionsToModify, err := mc.analyzeCallChain(src, methodName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to analyze call chain: %w", err)
+	}
+	logger.Log.DebugPrintf("Functions to modify: %v", functionsToModify)
+
+	// Step 3: Parse the AST
+	file, edit, err := mc.parseAST(src)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse AST: %w", err)
+	}
+
+	// Step 4: Move the dependency onto the receiver
+	injector := modifier.NewFieldInjector(functionsToModify, mc.fset)
+	constructors, err := injector.Inject(file, targetFunc, fieldName, fieldType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inject field: %w", err)
+	}
+
+	// Step 5: Propagate the dependency above the constructors
+	var constructorChain []string
+	seen := make(map[string]bool)
+	for _, constructor := range constructors {
+		constructorAnalyzer := analyzer.NewCallChainAnalyzer(mc.fset)
+		constructorAnalyzer.SetTolerant(mc.tolerant)
+		chain, err := constructorAnalyzer.AnalyzeCallChain(src, constructor)
+		if err != nil {
+			return nil, fmt.Errorf("failed to analyze call chain of %s: %w", constructor, err)
+		}
+		for _, funcName := range chain {
+			if !seen[funcName] {
+				seen[funcName] = true
+				constructorChain = append(constructorChain, funcName)
+			}
+		}
+	}
+	var propagated []report.Change
+	if len(constructorChain) > 0 {
+		mc.astModifier = modifier.NewASTModifier(constructorChain, mc.fset)
+		mc.traverser = traverser.NewASTTraverser(mc.parser, mc.astModifier)
+		err = mc.traverseAndModifyAST(file, constructorChain, fieldName, fieldType)
+		if err != nil {
+			return nil, fmt.Errorf("failed to traverse and modify AST: %w", err)
+		}
+		propagated = mc.astModifier.Changes()
+
+		// main and tests have nothing to pass, the user has to provide it
+		injector.ReportRootCalls(file, fieldName, fieldType)
+	}
+	changes := withoutUndone(append(injector.Changes(), propagated...))
+
+	// Step 6: Write the modified AST back to the file
+	err = mc.writeModifiedAST(filePath, file, edit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write modified AST: %w", err)
+	}
+
+	log.Println("Successfully injected dependency into the receiver")
+	changes = append(changes, edit.tolerated(filePath)...)
+	return report.Sorted(report.WithFile(changes, filePath)), nil
+}
+
+// withoutUndone drops the call site changes at the position of a manual
+// change, their edit was undone and left to the user
+func withoutUndone(changes []report.Change) []report.Change {
+	manual := make(map[[2]int]bool)
+	for _, change := range changes {
+		if change.Kind == report.KindManual {
+			manual[[2]int{change.Line, change.Column}] = true
+		}
+	}
+	var result []report.Change
+	for _, change := range changes {
+		if change.Kind == report.KindCallSite && manual[[2]int{change.Line, change.Column}] {
+			continue
+		}
+		result = append(result, change)
+	}
+	return result
 }
 
-func (mc *MainCoordinator) parseAST(src []byte) (*ast.File, error) {
-	return mc.parser.Parse(src)
+// AddErrorReturn adds an error result to targetFunc and rewrites its callers
+// to check it. Callers keep propagating the error up to maxDepth levels
+// (maxDepth <= 0 means no limit) and stop at the functions listed in stopAt.
+func (mc *MainCoordinator) AddErrorReturn(filePath, targetFunc string, maxDepth int, stopAt []string) ([]report.Change, error) {
+	logger.Log.DebugPrintf("Starting AddErrorReturn for %s in %s", targetFunc, filePath)
+
+	// Step 1: Read the file
+	src, err := mc.readFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	// Step 2: Build the call graph for the caller chain
+	if _, err := mc.analyzeCallChain(src, targetFunc); err != nil {
+		return nil, fmt.Errorf("failed to analyze call chain: %w", err)
+	}
+
+	// Step 3: Parse the AST
+	file, edit, err := mc.parseAST(src)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse AST: %w", err)
+	}
+
+	// Step 4: Add the error result and propagate error handling
+	errorModifier := modifier.NewErrorModifier(mc.fset, mc.analyzer.Callers, maxDepth, stopAt)
+	errorModifier.SetInfo(mc.packageInfo(filePath, file))
+	if err := errorModifier.Modify(file, targetFunc); err != nil {
+		return nil, fmt.Errorf("failed to add error return: %w", err)
+	}
+
+	// Step 5: Write the modified AST back to the file with the TODO comments
+	// of the ignored errors
+	content, err := mc.printAST(file, edit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write modified AST: %w", err)
+	}
+	if err := mc.fileManager.WriteFile(filePath, errorModifier.AddTODOs(content)); err != nil {
+		return nil, fmt.Errorf("failed to write modified AST: %w", err)
+	}
+
+	log.Println("Successfully added error return and propagated error handling")
+	return report.WithFile(append(errorModifier.Changes(), edit.tolerated(filePath)...), filePath), nil
 }
 
-func (mc *MainCoordinator) traverseAndModifyAST(file *ast.File, functionsToModify []string, paramName, paramType string) error {
-	return mc.traverser.Traverse(file, functionsToModify, paramName, paramType)
+// FindUnusedParams reports the parameter chains of the module containing
+// filePath that are never read
+func (mc *MainCoordinator) FindUnusedParams(filePath string) ([]analyzer.UnusedChain, error) {
+	logger.Log.DebugPrintf("Starting FindUnusedParams for %s", filePath)
+
+	// Step 1: Parse every file of the module
+	files, _, err := mc.loadModule(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load module: %w", err)
+	}
+
+	// Step 2: Analyze parameter uses across the call graph
+	return mc.analyzer.FindUnusedParams(files), nil
 }
 
-func (mc *MainCoordinator) writeModifiedAST(filePath string, file *ast.File) error {
-	// Create a temporary file
-	tmpFile, err := os.CreateTemp("", "modified_*.go")
+// RemoveUnusedParams deletes the parameters of a chain reported by
+// FindUnusedParams along with the arguments passed for them. Nothing is
+// written if one of the files to change has unsaved changes.
+func (mc *MainCoordinator) RemoveUnusedParams(filePath string, chain analyzer.UnusedChain) ([]report.Change, error) {
+	logger.Log.DebugPrintf("Starting RemoveUnusedParams for %d parameters", len(chain.Params))
+
+	// Step 1: Parse every file of the module
+	files, edits, err := mc.loadModule(filePath)
 	if err != nil {
-		return fmt.Errorf("failed to create temporary file: %w", err)
+		return nil, fmt.Errorf("failed to load module: %w", err)
+	}
+
+	// Step 2: Remove the parameters and the matching arguments
+	remover := modifier.NewParamRemover(chain.Params, mc.fset)
+	var changed []analyzer.SourceFile
+	for _, source := range files {
+		if !remover.Remove(source) {
+			continue
+		}
+		if mc.unsaved[source.Path] {
+			return nil, fmt.Errorf("%s has unsaved changes, write it first", source.Path)
+		}
+		changed = append(changed, source)
+	}
+
+	// Step 3: Write every modified file back
+	var tolerated []report.Change
+	for _, source := range changed {
+		edit := edits[source.Path]
+		if err := mc.writeModifiedAST(source.Path, source.File, edit); err != nil {
+			return nil, fmt.Errorf("failed to write modified AST: %w", err)
+		}
+		tolerated = append(tolerated, edit.tolerated(source.Path)...)
 	}
-	defer tmpFile.Close()
 
-	// Use the printer package to write the AST to the temporary file
-	err = printer.Fprint(tmpFile, mc.fset, file)
+	log.Println("Successfully removed unused parameters")
+	return report.Sorted(append(remover.Changes(), tolerated...)), nil
+}
+
+// ExportCallGraph renders the call graph around filePath. An empty
+// filter.Package defaults to the package of filePath.
+func (mc *MainCoordinator) ExportCallGraph(filePath string, scope GraphScope, filter analyzer.GraphFilter, format analyzer.GraphFormat) (string, error) {
+	logger.Log.DebugPrintf("Starting ExportCallGraph for %s (%s)", filePath, scope)
+
+	// Step 1: Parse every file of the module
+	files, _, err := mc.loadModule(filePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to load module: %w", err)
+	}
+
+	// Step 2: Keep the files of the requested scope
+	current, err := findSourceFile(files, filePath)
 	if err != nil {
-		return fmt.Errorf("failed to write AST to temporary file: %w", err)
+		return "", err
+	}
+	if filter.Package == "" {
+		filter.Package = current.Package
+	}
+	var scoped []analyzer.SourceFile
+	for _, source := range files {
+		switch scope {
+		case ScopeFile:
+			if source.Path != current.Path {
+				continue
+			}
+		case ScopePackage:
+			if source.Package != current.Package {
+				continue
+			}
+		case ScopeModule:
+		default:
+			return "", fmt.Errorf("unknown scope %q", scope)
+		}
+		scoped = append(scoped, source)
 	}
 
-	// Close the temporary file
-	tmpFile.Close()
+	// Step 3: Build and filter the graph
+	graph, err := mc.analyzer.BuildGraph(scoped).Filter(filter)
+	if err != nil {
+		return "", fmt.Errorf("failed to filter call graph: %w", err)
+	}
+
+	// Step 4: Render it
+	var out strings.Builder
+	if err := analyzer.WriteGraph(&out, graph, format); err != nil {
+		return "", fmt.Errorf("failed to write call graph: %w", err)
+	}
+	return out.String(), nil
+}
+
+func (mc *MainCoordinator) readFile(filePath string) ([]byte, error) {
+	return mc.fileManager.ReadFile(filePath)
+}
+
+func (mc *MainCoordinator) analyzeCallChain(src []byte, targetFunc string) ([]string, error) {
+	return mc.analyzer.AnalyzeCallChain(src, targetFunc)
+}
 
-	// Read the contents of the temporary file
-	content, err := os.ReadFile(tmpFile.Name())
+// parseAST parses src and snapshots it for writing back when it contains
+// syntax errors that are tolerated
+func (mc *MainCoordinator) parseAST(src []byte) (*ast.File, *tolerantEdit, error) {
+	if !mc.tolerant {
+		file, err := mc.parser.Parse(src)
+		return file, mc.snapshot(file, src, nil), err
+	}
+	file, syntaxErrors, err := mc.parser.ParseTolerant(src)
 	if err != nil {
-		return fmt.Errorf("failed to read temporary file: %w", err)
+		return nil, nil, err
 	}
+	return file, mc.snapshot(file, src, syntaxErrors), nil
+}
 
-	// Write the contents to the original file
-	err = mc.fileManager.WriteFile(filePath, content)
+func (mc *MainCoordinator) traverseAndModifyAST(file *ast.File, functionsToModify []string, paramName, paramType string) error {
+	return mc.traverser.Traverse(file, functionsToModify, paramName, paramType)
+}
+
+func (mc *MainCoordinator) writeModifiedAST(filePath string, file *ast.File, edit *tolerantEdit) error {
+	content, err := mc.printAST(file, edit)
 	if err != nil {
-		return fmt.Errorf("failed to write to original file: %w", err)
+		return err
 	}
+	return mc.fileManager.WriteFile(filePath, content)
+}
 
-	// Remove the temporary file
-	os.Remove(tmpFile.Name())
+// printAST returns the new content of a modified file. Files with tolerated
+// syntax errors only get their changed declarations printed.
+func (mc *MainCoordinator) printAST(file *ast.File, edit *tolerantEdit) ([]byte, error) {
+	if len(edit.errors) > 0 {
+		return mc.render(file, edit)
+	}
 
-	return nil
+	var buf bytes.Buffer
+	if err := printer.Fprint(&buf, mc.fset, file); err != nil {
+		return nil, fmt.Errorf("failed to print AST: %w", err)
+	}
+	return buf.Bytes(), nil
 }
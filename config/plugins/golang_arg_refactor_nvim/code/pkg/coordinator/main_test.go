@@ -0,0 +1,1111 @@
+package coordinator
+
+import (
+	"fmt"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+
+	"github.com/back2nix/go-arg-propagation/pkg/analyzer"
+	"github.com/back2nix/go-arg-propagation/pkg/report"
+)
+
+// writeTempSource writes src to a temporary Go file and returns its path
+func writeTempSource(t *testing.T, src string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "main.go")
+	if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+		t.Fatalf("Failed to write temp file: %v", err)
+	}
+	return path
+}
+
+// assertSource compares the formatted file content with the expected code
+func assertSource(t *testing.T, path, expected string) {
+	t.Helper()
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read result: %v", err)
+	}
+	got, err := format.Source(content)
+	if err != nil {
+		t.Fatalf("Result is not valid Go: %v\n%s", err, content)
+	}
+	want, err := format.Source([]byte(expected))
+	if err != nil {
+		t.Fatalf("Expected code is not valid Go: %v", err)
+	}
+	if string(got) != string(want) {
+		dmp := diffmatchpatch.New()
+		diffs := dmp.DiffMain(string(want), string(got), false)
+		t.Errorf("Unexpected result:\n%s", dmp.DiffPrettyText(diffs))
+	}
+	// format.Source sorts imports, so their order is checked on the raw result
+	if gotImports, wantImports := importPaths(t, content), importPaths(t, want); fmt.Sprint(gotImports) != fmt.Sprint(wantImports) {
+		t.Errorf("Imports in order %v, want %v", gotImports, wantImports)
+	}
+}
+
+// importPaths returns the import paths of src in their order
+func importPaths(t *testing.T, src []byte) []string {
+	t.Helper()
+	file, err := parser.ParseFile(token.NewFileSet(), "", src, parser.ImportsOnly)
+	if err != nil {
+		t.Fatalf("Failed to parse imports: %v", err)
+	}
+	var paths []string
+	for _, spec := range file.Imports {
+		paths = append(paths, spec.Path.Value)
+	}
+	return paths
+}
+
+func TestAddContextToFunction(t *testing.T) {
+	tests := []struct {
+		name         string
+		code         string
+		targetFunc   string
+		expectedCode string
+		// expectedLines are the lines of the reported changes, when given
+		expectedLines []int
+	}{
+		{
+			name: "Thread ctx from main",
+			code: `package main
+
+import "fmt"
+
+func main() {
+	handle("x")
+}
+
+func handle(s string) {
+	fmt.Println(load(s))
+}
+
+func load(s string) string {
+	return s
+}
+`,
+			targetFunc: "load",
+			expectedCode: `package main
+
+import (
+	"context"
+	"fmt"
+)
+
+func main() {
+	handle(context.Background(), "x")
+}
+
+func handle(ctx context.Context, s string) {
+	fmt.Println(load(ctx, s))
+}
+
+func load(ctx context.Context, s string) string {
+	return s
+}
+`,
+		},
+		{
+			name: "Reuse existing ctx and request context",
+			code: `package main
+
+import (
+	"context"
+	"net/http"
+)
+
+func serve(w http.ResponseWriter, r *http.Request) {
+	process(r.Context())
+}
+
+func process(c context.Context) {
+	fetch()
+}
+
+func fetch() {
+	query(context.TODO())
+}
+
+func query(ctx context.Context) {}
+`,
+			targetFunc: "fetch",
+			expectedCode: `package main
+
+import (
+	"context"
+	"net/http"
+)
+
+func serve(w http.ResponseWriter, r *http.Request) {
+	process(r.Context())
+}
+
+func process(c context.Context) {
+	fetch(c)
+}
+
+func fetch(ctx context.Context) {
+	query(ctx)
+}
+
+func query(ctx context.Context) {}
+`,
+		},
+		{
+			name: "Drop local background ctx",
+			code: `package main
+
+import (
+	"context"
+	"net/http"
+)
+
+func serve(w http.ResponseWriter, r *http.Request) {
+	run()
+}
+
+func run() {
+	ctx := context.Background()
+	call(ctx)
+}
+
+func call(ctx context.Context) {}
+`,
+			targetFunc: "run",
+			expectedCode: `package main
+
+import (
+	"context"
+	"net/http"
+)
+
+func serve(w http.ResponseWriter, r *http.Request) {
+	run(r.Context())
+}
+
+func run(ctx context.Context) {
+	call(ctx)
+}
+
+func call(ctx context.Context) {}
+`,
+			expectedLines: []int{9, 12, 13},
+		},
+		{
+			name: "Pass derived ctx local",
+			code: `package main
+
+import (
+	"context"
+	"time"
+)
+
+func main() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	start(ctx)
+}
+
+func start(ctx context.Context) {
+	run()
+}
+
+func run() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	call()
+	if ready := true; ready {
+		call()
+	}
+}
+
+func call() {}
+`,
+			targetFunc: "call",
+			expectedCode: `package main
+
+import (
+	"context"
+	"time"
+)
+
+func main() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	start(ctx)
+}
+
+func start(ctx context.Context) {
+	run(ctx)
+}
+
+func run(parentCtx context.Context) {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second)
+	defer cancel()
+	call(ctx)
+	if ready := true; ready {
+		call(ctx)
+	}
+}
+
+func call(ctx context.Context) {}
+`,
+		},
+		{
+			name: "Insert context import in sorted order",
+			code: `package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/example/lib"
+)
+
+func main() {
+	work()
+}
+
+func work() {
+	fmt.Println(strings.ToUpper(lib.Name))
+}
+`,
+			targetFunc: "work",
+			expectedCode: `package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/example/lib"
+)
+
+func main() {
+	work(context.Background())
+}
+
+func work(ctx context.Context) {
+	fmt.Println(strings.ToUpper(lib.Name))
+}
+`,
+		},
+		{
+			name: "Leave methods of the same name alone",
+			code: `package main
+
+import "strings"
+
+type Cache struct {
+	Reset func()
+}
+
+func main() {
+	var b strings.Builder
+	b.Reset()
+	c := Cache{Reset: func() {}}
+	c.Reset()
+	Reset()
+}
+
+func Reset() {}
+`,
+			targetFunc: "Reset",
+			expectedCode: `package main
+
+import (
+	"context"
+	"strings"
+)
+
+type Cache struct {
+	Reset func()
+}
+
+func main() {
+	var b strings.Builder
+	b.Reset()
+	c := Cache{Reset: func() {}}
+	c.Reset()
+	Reset(context.Background())
+}
+
+func Reset(ctx context.Context) {}
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeTempSource(t, tt.code)
+
+			changes, err := NewMainCoordinator().AddContextToFunction(path, tt.targetFunc)
+			if err != nil {
+				t.Fatalf("AddContextToFunction() error = %v", err)
+			}
+			if len(changes) == 0 {
+				t.Errorf("Expected a change report, got none")
+			}
+			var lines []int
+			for _, change := range changes {
+				if change.File != path {
+					t.Errorf("Change %+v reported for wrong file", change)
+				}
+				lines = append(lines, change.Line)
+			}
+			if tt.expectedLines != nil && fmt.Sprint(lines) != fmt.Sprint(tt.expectedLines) {
+				t.Errorf("Changes reported at lines %v, want %v", lines, tt.expectedLines)
+			}
+
+			assertSource(t, path, tt.expectedCode)
+		})
+	}
+}
+
+func TestInjectFieldIntoReceiver(t *testing.T) {
+	code := `package main
+
+import "fmt"
+
+type Service struct {
+	name string
+}
+
+func NewService(name string) *Service {
+	return &Service{name: name}
+}
+
+func (*Service) Process() {
+	fmt.Println(db)
+}
+
+func (s *Service) Clone() Service {
+	return Service{name: s.name}
+}
+
+func setup() *Service {
+	return NewService("svc")
+}
+
+func main() {
+	setup().Process()
+}
+`
+	expected := `package main
+
+import "fmt"
+
+type Service struct {
+	name string
+	db   *DB
+}
+
+func NewService(name string, db *DB) *Service {
+	return &Service{name: name, db: db}
+}
+
+func (s *Service) Process() {
+	fmt.Println(s.db)
+}
+
+func (s *Service) Clone() Service {
+	return Service{name: s.name, db: s.db}
+}
+
+func setup(db *DB) *Service {
+	return NewService("svc", db)
+}
+
+func main() {
+	setup().Process()
+}
+`
+
+	path := writeTempSource(t, code)
+
+	changes, err := NewMainCoordinator().InjectFieldIntoReceiver(path, "Service.Process", "db", "*DB")
+	if err != nil {
+		t.Fatalf("InjectFieldIntoReceiver() error = %v", err)
+	}
+	if len(changes) == 0 {
+		t.Errorf("Expected a change report, got none")
+	}
+	// main has no db, the call is left for the user instead of passing an
+	// undefined identifier
+	manual := 0
+	for _, change := range changes {
+		if change.Kind == report.KindManual {
+			manual++
+			if change.Line != 26 {
+				t.Errorf("Manual change reported at line %d, want 26", change.Line)
+			}
+		}
+	}
+	if manual != 1 {
+		t.Errorf("Expected 1 manual change, got %d: %+v", manual, changes)
+	}
+
+	assertSource(t, path, expected)
+}
+
+func TestInjectFieldQualifiedMethod(t *testing.T) {
+	code := `package main
+
+type A struct{}
+
+type B struct{}
+
+func (a *A) Run() {
+	use(conn)
+}
+
+func (b *B) Run() {}
+
+func use(v any) {}
+`
+	expected := `package main
+
+type A struct{}
+
+type B struct{ conn *Conn }
+
+func (a *A) Run() {
+	use(conn)
+}
+
+func (b *B) Run() {}
+
+func use(v any) {}
+`
+
+	path := writeTempSource(t, code)
+	if _, err := NewMainCoordinator().InjectFieldIntoReceiver(path, "Run", "conn", "*Conn"); err == nil {
+		t.Errorf("Expected an error for a method declared on several types")
+	}
+
+	if _, err := NewMainCoordinator().InjectFieldIntoReceiver(path, "B.Run", "conn", "*Conn"); err != nil {
+		t.Fatalf("InjectFieldIntoReceiver() error = %v", err)
+	}
+	assertSource(t, path, expected)
+}
+
+func TestAddErrorReturn(t *testing.T) {
+	tests := []struct {
+		name         string
+		code         string
+		targetFunc   string
+		maxDepth     int
+		stopAt       []string
+		expectedCode string
+	}{
+		{
+			name: "Propagate to the root",
+			code: `package main
+
+import "fmt"
+
+type Config struct{ Name string }
+
+func main() {
+	fmt.Println(build())
+}
+
+func build() string {
+	cfg := load("app")
+	save(cfg)
+	return "built " + name(cfg)
+}
+
+func load(path string) Config {
+	return Config{Name: path}
+}
+
+func save(cfg Config) {
+	fmt.Println(cfg)
+}
+
+func name(cfg Config) string {
+	return cfg.Name
+}
+`,
+			targetFunc: "load",
+			expectedCode: `package main
+
+import "fmt"
+
+type Config struct{ Name string }
+
+func main() {
+	buildResult, err := build()
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(buildResult)
+}
+
+func build() (string, error) {
+	cfg, err := load("app")
+	if err != nil {
+		return "", err
+	}
+	save(cfg)
+	return "built " + name(cfg), nil
+}
+
+func load(path string) (Config, error) {
+	return Config{Name: path}, nil
+}
+
+func save(cfg Config) {
+	fmt.Println(cfg)
+}
+
+func name(cfg Config) string {
+	return cfg.Name
+}
+`,
+		},
+		{
+			name: "Stop at depth and forward returns",
+			code: `package main
+
+func main() {
+	run()
+}
+
+func run() {
+	step()
+}
+
+func step() int {
+	return count()
+}
+
+func count() int {
+	return 1
+}
+`,
+			targetFunc: "count",
+			maxDepth:   1,
+			expectedCode: `package main
+
+func main() {
+	run()
+}
+
+func run() {
+	if _, err := step(); err != nil {
+		_ = err // TODO: handle err
+	}
+}
+
+func step() (int, error) {
+	return count()
+}
+
+func count() (int, error) {
+	return 1, nil
+}
+`,
+		},
+		{
+			name: "Stop-point and existing error result",
+			code: `package main
+
+func main() {
+	if err := serve(); err != nil {
+		panic(err)
+	}
+	worker()
+}
+
+func serve() error {
+	write()
+	return nil
+}
+
+func worker() {
+	write()
+}
+
+func write() {
+}
+`,
+			targetFunc: "write",
+			stopAt:     []string{"worker"},
+			expectedCode: `package main
+
+func main() {
+	if err := serve(); err != nil {
+		panic(err)
+	}
+	worker()
+}
+
+func serve() error {
+	if err := write(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func worker() {
+	if err := write(); err != nil {
+		_ = err // TODO: handle err
+	}
+}
+
+func write() error {
+	return nil
+}
+`,
+		},
+		{
+			name: "Caller without results",
+			code: `package main
+
+func main() {
+	caller()
+}
+
+func caller() {
+	leaf(2)
+}
+
+func leaf(x int) int {
+	return x
+}
+`,
+			targetFunc: "leaf",
+			expectedCode: `package main
+
+func main() {
+	if err := caller(); err != nil {
+		panic(err)
+	}
+}
+
+func caller() error {
+	if _, err := leaf(2); err != nil {
+		return err
+	}
+	return nil
+}
+
+func leaf(x int) (int, error) {
+	return x, nil
+}
+`,
+		},
+		{
+			name: "Leave methods of the same name alone",
+			code: `package main
+
+import "strings"
+
+func main() {
+	run()
+}
+
+func run() {
+	var b strings.Builder
+	b.Reset()
+	Reset()
+}
+
+func Reset() {
+}
+`,
+			targetFunc: "Reset",
+			expectedCode: `package main
+
+import "strings"
+
+func main() {
+	if err := run(); err != nil {
+		panic(err)
+	}
+}
+
+func run() error {
+	var b strings.Builder
+	b.Reset()
+	if err := Reset(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func Reset() error {
+	return nil
+}
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeTempSource(t, tt.code)
+
+			changes, err := NewMainCoordinator().AddErrorReturn(path, tt.targetFunc, tt.maxDepth, tt.stopAt)
+			if err != nil {
+				t.Fatalf("AddErrorReturn() error = %v", err)
+			}
+			if len(changes) == 0 {
+				t.Errorf("Expected a change report, got none")
+			}
+
+			assertSource(t, path, tt.expectedCode)
+		})
+	}
+}
+
+// writeTempModule writes files relative to a new module root and returns it
+func writeTempModule(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for name, src := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+			t.Fatalf("Failed to write temp file: %v", err)
+		}
+	}
+	return root
+}
+
+func TestUnusedParams(t *testing.T) {
+	root := writeTempModule(t, map[string]string{
+		"go.mod": "module example.com/app\n\ngo 1.21\n",
+		"main.go": `package main
+
+import "example.com/app/store"
+
+func main() {
+	handle("a", 1, true)
+	store.Save("b", 2)
+}
+
+func handle(name string, retries int, verbose bool) {
+	println(name)
+	process(retries, verbose)
+}
+
+func process(retries int, verbose bool) {
+	store.Save("c", retries)
+	if verbose {
+		println("done")
+	}
+}
+`,
+		"store/store.go": `package store
+
+func Save(key string, attempts int) {
+	write(key, attempts)
+}
+
+func write(key string, attempts int) {
+	println(key)
+	register(callback)
+}
+
+func callback(unused int) {}
+
+func register(f func(int)) { f(0) }
+`,
+	})
+
+	mc := NewMainCoordinator()
+	chains, err := mc.FindUnusedParams(filepath.Join(root, "main.go"))
+	if err != nil {
+		t.Fatalf("FindUnusedParams() error = %v", err)
+	}
+
+	var got [][]string
+	for _, chain := range chains {
+		var names []string
+		for _, param := range chain.Params {
+			names = append(names, param.Func+"."+param.Name)
+		}
+		got = append(got, names)
+	}
+	want := [][]string{
+		{"handle.retries", "process.retries", "Save.attempts", "write.attempts"},
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("FindUnusedParams() = %v, want %v", got, want)
+	}
+	for _, param := range chains[0].Params {
+		if param.Exported != (param.Func == "Save") {
+			t.Errorf("Exported of %s = %v", param.Func, param.Exported)
+		}
+	}
+
+	original, err := os.ReadFile(filepath.Join(root, "main.go"))
+	if err != nil {
+		t.Fatalf("Failed to read main.go: %v", err)
+	}
+	unsaved := NewMainCoordinator()
+	unsaved.SetUnsavedFiles([]string{filepath.Join(root, "store", "store.go")})
+	if _, err := unsaved.RemoveUnusedParams(filepath.Join(root, "main.go"), chains[0]); err == nil {
+		t.Fatalf("Expected an error for a file with unsaved changes")
+	}
+	assertSource(t, filepath.Join(root, "main.go"), string(original))
+
+	changes, err := NewMainCoordinator().RemoveUnusedParams(filepath.Join(root, "main.go"), chains[0])
+	if err != nil {
+		t.Fatalf("RemoveUnusedParams() error = %v", err)
+	}
+	manual := 0
+	for _, change := range changes {
+		if change.Kind == report.KindManual {
+			manual++
+			if !strings.HasPrefix(change.Text, "Save is exported") {
+				t.Errorf("Unexpected manual change %q", change.Text)
+			}
+		}
+	}
+	if manual != 1 {
+		t.Errorf("Expected the exported Save to be reported, got %d manual changes", manual)
+	}
+
+	assertSource(t, filepath.Join(root, "main.go"), `package main
+
+import "example.com/app/store"
+
+func main() {
+	handle("a", true)
+	store.Save("b")
+}
+
+func handle(name string, verbose bool) {
+	println(name)
+	process(verbose)
+}
+
+func process(verbose bool) {
+	store.Save("c")
+	if verbose {
+		println("done")
+	}
+}
+`)
+	assertSource(t, filepath.Join(root, "store", "store.go"), `package store
+
+func Save(key string) {
+	write(key)
+}
+
+func write(key string) {
+	println(key)
+	register(callback)
+}
+
+func callback(unused int) {}
+
+func register(f func(int)) { f(0) }
+`)
+}
+
+func TestExportCallGraph(t *testing.T) {
+	root := writeTempModule(t, map[string]string{
+		"go.mod": "module example.com/app\n\ngo 1.21\n",
+		"main.go": `package main
+
+import (
+	"fmt"
+
+	"example.com/app/store"
+)
+
+func main() {
+	run()
+}
+
+func run() {
+	s := store.New()
+	s.Save(prepare())
+	s.Save(prepare())
+	fmt.Println("done")
+}
+
+func prepare() string {
+	return "x"
+}
+`,
+		"store/store.go": `package store
+
+type Store struct{}
+
+func New() *Store { return &Store{} }
+
+func (s *Store) Save(v string) { s.write(v) }
+
+func (s *Store) write(v string) {}
+`,
+	})
+	mainFile := filepath.Join(root, "main.go")
+
+	tests := []struct {
+		name     string
+		scope    GraphScope
+		filter   analyzer.GraphFilter
+		format   analyzer.GraphFormat
+		expected string
+	}{
+		{
+			name:   "Package scope as DOT",
+			scope:  ScopePackage,
+			filter: analyzer.GraphFilter{Boundary: analyzer.BoundaryNone},
+			format: analyzer.FormatDOT,
+			expected: `digraph calls {
+	rankdir=LR;
+	node [shape=box];
+	"example.com/app.main" [label="app.main"];
+	"example.com/app.prepare" [label="app.prepare"];
+	"example.com/app.run" [label="app.run"];
+	"example.com/app/store.New" [label="store.New", style=dashed];
+	"example.com/app/store.Store.Save" [label="store.Store.Save", style=dashed];
+	"fmt.Println" [label="fmt.Println", style=dashed];
+	"example.com/app.main" -> "example.com/app.run";
+	"example.com/app.run" -> "example.com/app.prepare" [label="2"];
+	"example.com/app.run" -> "example.com/app/store.New";
+	"example.com/app.run" -> "example.com/app/store.Store.Save" [label="2"];
+	"example.com/app.run" -> "fmt.Println";
+}
+`,
+		},
+		{
+			name:   "Module scope from root with depth as Mermaid",
+			scope:  ScopeModule,
+			filter: analyzer.GraphFilter{Root: "Save", Package: "example.com/app/store", Depth: 1, Boundary: analyzer.BoundaryModule},
+			format: analyzer.FormatMermaid,
+			expected: `flowchart LR
+    n0["store.Store.Save"]
+    n1["store.Store.write"]
+    n0 --> n1
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewMainCoordinator().ExportCallGraph(mainFile, tt.scope, tt.filter, tt.format)
+			if err != nil {
+				t.Fatalf("ExportCallGraph() error = %v", err)
+			}
+			if got != tt.expected {
+				t.Errorf("ExportCallGraph() =\n%s\nwant\n%s", got, tt.expected)
+			}
+		})
+	}
+
+	t.Run("Unknown boundary", func(t *testing.T) {
+		filter := analyzer.GraphFilter{Boundary: "pkg"}
+		if _, err := NewMainCoordinator().ExportCallGraph(mainFile, ScopePackage, filter, analyzer.FormatDOT); err == nil {
+			t.Errorf("Expected an error for boundary %q", filter.Boundary)
+		}
+	})
+}
+
+func TestTolerantRefactoring(t *testing.T) {
+	code := `package main
+
+func main() {
+	handle()
+}
+
+// handle is changed
+func handle() {
+	// keeps this comment
+	load()
+}
+
+func load() {}
+
+func unfinished() {
+	x := foo(
+}
+`
+	expected := `package main
+
+func main() {
+	handle(id)
+}
+
+// handle is changed
+func handle(id int) {
+	// keeps this comment
+	load(id)
+}
+
+func load(id int) {}
+
+func unfinished() {
+	x := foo(
+}
+`
+
+	t.Run("Strict mode fails", func(t *testing.T) {
+		path := writeTempSource(t, code)
+		if _, err := NewMainCoordinator().AddArgumentToFunction(path, "load", "id", "int"); err == nil {
+			t.Fatalf("Expected a parse error")
+		}
+	})
+
+	t.Run("Broken code outside of the change is kept", func(t *testing.T) {
+		path := writeTempSource(t, code)
+		mc := NewMainCoordinator()
+		mc.SetTolerant(true)
+
+		changes, err := mc.AddArgumentToFunction(path, "load", "id", "int")
+		if err != nil {
+			t.Fatalf("AddArgumentToFunction() error = %v", err)
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Failed to read result: %v", err)
+		}
+		if string(content) != expected {
+			t.Errorf("Unexpected result:\n%s", content)
+		}
+
+		tolerated := 0
+		for _, change := range changes {
+			if change.Kind == report.KindTolerated {
+				tolerated++
+				if change.Line != 17 {
+					t.Errorf("Tolerated error reported at line %d, want 17", change.Line)
+				}
+			}
+		}
+		if tolerated == 0 {
+			t.Errorf("Expected the syntax error to be reported, got %+v", changes)
+		}
+	})
+
+	t.Run("Broken code inside of the change fails", func(t *testing.T) {
+		path := writeTempSource(t, code)
+		mc := NewMainCoordinator()
+		mc.SetTolerant(true)
+
+		if _, err := mc.AddArgumentToFunction(path, "unfinished", "id", "int"); err == nil {
+			t.Fatalf("Expected the overlapping syntax error to fail the refactoring")
+		}
+		content, _ := os.ReadFile(path)
+		if string(content) != code {
+			t.Errorf("File changed although the refactoring failed:\n%s", content)
+		}
+	})
+}
@@ -0,0 +1,243 @@
+package coordinator
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"go/types"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/back2nix/go-arg-propagation/pkg/analyzer"
+	"github.com/back2nix/go-arg-propagation/pkg/logger"
+)
+
+// findModuleRoot walks up from dir to the directory containing go.mod and
+// returns it together with the module path. Without a go.mod dir itself is
+// treated as the module root.
+func findModuleRoot(dir string) (string, string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", "", err
+	}
+	for current := dir; ; {
+		content, err := os.ReadFile(filepath.Join(current, "go.mod"))
+		if err == nil {
+			return current, modulePath(content), nil
+		}
+		parent := filepath.Dir(current)
+		if parent == current {
+			return dir, filepath.Base(dir), nil
+		}
+		current = parent
+	}
+}
+
+// modulePath extracts the module directive from go.mod content
+func modulePath(gomod []byte) string {
+	scanner := bufio.NewScanner(bytes.NewReader(gomod))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "module" {
+			return strings.Trim(fields[1], `"`)
+		}
+	}
+	return ""
+}
+
+// loadModule parses and type-checks every Go file of the module containing
+// filePath. Packages are keyed by their import path; external test packages
+// get a _test suffix. The returned edits are keyed by file path and are needed
+// to write files back.
+func (mc *MainCoordinator) loadModule(filePath string) ([]analyzer.SourceFile, map[string]*tolerantEdit, error) {
+	root, module, err := findModuleRoot(filepath.Dir(filePath))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to find module root: %w", err)
+	}
+	logger.Log.DebugPrintf("Loading module %s from %s", module, root)
+
+	var files []analyzer.SourceFile
+	edits := make(map[string]*tolerantEdit)
+	packageNames := make(map[string]string)
+	err = filepath.WalkDir(root, func(filePath string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			name := entry.Name()
+			if filePath != root && (name == "vendor" || name == "testdata" ||
+				strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")) {
+				return filepath.SkipDir
+			}
+			// Nested modules are analyzed on their own
+			if filePath != root && mc.fileManager.FileExists(filepath.Join(filePath, "go.mod")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filepath.Ext(filePath) != ".go" {
+			return nil
+		}
+
+		src, err := mc.readFile(filePath)
+		if err != nil {
+			return err
+		}
+		file, err := parser.ParseFile(mc.fset, filePath, src, parser.ParseComments|parser.AllErrors)
+		var syntaxErrors scanner.ErrorList
+		if err != nil && (!mc.tolerant || file == nil || !errors.As(err, &syntaxErrors)) {
+			return fmt.Errorf("failed to parse %s: %w", filePath, err)
+		}
+		edits[filePath] = mc.snapshot(file, src, syntaxErrors)
+
+		rel, err := filepath.Rel(root, filepath.Dir(filePath))
+		if err != nil {
+			return err
+		}
+		pkg := path.Join(module, filepath.ToSlash(rel))
+		if strings.HasSuffix(file.Name.Name, "_test") {
+			pkg += "_test"
+		} else {
+			packageNames[pkg] = file.Name.Name
+		}
+
+		files = append(files, analyzer.SourceFile{Path: filePath, Package: pkg, File: file})
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	info := mc.typeCheck(files, importer.Default())
+	for i := range files {
+		files[i].Imports = analyzer.ImportNames(files[i].File, packageNames)
+		files[i].Info = info
+	}
+	return files, edits, nil
+}
+
+// typeCheck type-checks the packages of files, other imports are left to
+// fallback. Type errors, e.g. from syntax errors or imports that cannot be
+// found, are ignored; the expressions they affect are simply missing from the
+// returned info.
+func (mc *MainCoordinator) typeCheck(files []analyzer.SourceFile, fallback types.Importer) *types.Info {
+	info := &types.Info{
+		Uses:       make(map[*ast.Ident]types.Object),
+		Selections: make(map[*ast.SelectorExpr]*types.Selection),
+	}
+	packages := make(map[string][]*ast.File)
+	var order []string
+	for _, source := range files {
+		if packages[source.Package] == nil {
+			order = append(order, source.Package)
+		}
+		packages[source.Package] = append(packages[source.Package], source.File)
+	}
+
+	imp := &moduleImporter{
+		fset:     mc.fset,
+		packages: packages,
+		checked:  make(map[string]*types.Package),
+		info:     info,
+		fallback: fallback,
+	}
+	for _, pkg := range order {
+		imp.Import(pkg)
+	}
+	return info
+}
+
+// packageInfo type-checks file together with the other files of its package
+// in the same directory. Only standard library imports are resolved, code
+// using other packages is missing from the info.
+func (mc *MainCoordinator) packageInfo(filePath string, file *ast.File) *types.Info {
+	files := []analyzer.SourceFile{{Path: filePath, Package: file.Name.Name, File: file}}
+	abs, err := filepath.Abs(filePath)
+	if err != nil {
+		return mc.typeCheck(files, standardImporter{importer.Default()})
+	}
+	paths, _ := filepath.Glob(filepath.Join(filepath.Dir(abs), "*.go"))
+	for _, path := range paths {
+		if path == abs {
+			continue
+		}
+		src, err := mc.readFile(path)
+		if err != nil {
+			continue
+		}
+		sibling, _ := parser.ParseFile(mc.fset, path, src, 0)
+		if sibling != nil && sibling.Name.Name == file.Name.Name {
+			files = append(files, analyzer.SourceFile{Path: path, Package: file.Name.Name, File: sibling})
+		}
+	}
+	return mc.typeCheck(files, standardImporter{importer.Default()})
+}
+
+// standardImporter imports packages of the standard library, whose paths have
+// no dot in their first element, and fails for any other
+type standardImporter struct {
+	types.Importer
+}
+
+func (s standardImporter) Import(path string) (*types.Package, error) {
+	first, _, _ := strings.Cut(path, "/")
+	if strings.Contains(first, ".") {
+		return nil, fmt.Errorf("%s is not in the standard library", path)
+	}
+	return s.Importer.Import(path)
+}
+
+// moduleImporter type-checks the packages of the module from their parsed
+// files on first import and leaves other packages to the fallback importer
+type moduleImporter struct {
+	fset     *token.FileSet
+	packages map[string][]*ast.File
+	// checked holds the packages checked so far, nil while one is in progress
+	checked  map[string]*types.Package
+	info     *types.Info
+	fallback types.Importer
+}
+
+func (m *moduleImporter) Import(path string) (*types.Package, error) {
+	files, ok := m.packages[path]
+	if !ok {
+		return m.fallback.Import(path)
+	}
+	if pkg, done := m.checked[path]; done {
+		if pkg == nil {
+			return nil, fmt.Errorf("import cycle through %s", path)
+		}
+		return pkg, nil
+	}
+
+	m.checked[path] = nil
+	config := types.Config{
+		Importer: m,
+		Error:    func(err error) { logger.Log.DebugPrintf("Type error: %v", err) },
+	}
+	pkg, _ := config.Check(path, m.fset, files, m.info)
+	m.checked[path] = pkg
+	return pkg, nil
+}
+
+// findSourceFile returns the loaded file at filePath
+func findSourceFile(files []analyzer.SourceFile, filePath string) (analyzer.SourceFile, error) {
+	abs, err := filepath.Abs(filePath)
+	if err != nil {
+		return analyzer.SourceFile{}, err
+	}
+	for _, source := range files {
+		if source.Path == abs {
+			return source, nil
+		}
+	}
+	return analyzer.SourceFile{}, fmt.Errorf("%s is not a file of the module", filePath)
+}
@@ -0,0 +1,176 @@
+package coordinator
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/printer"
+	"go/scanner"
+	"go/token"
+	"sort"
+	"strings"
+
+	"github.com/back2nix/go-arg-propagation/pkg/report"
+)
+
+// tolerantEdit remembers the original state of a file parsed with syntax
+// errors. Printing such a file as a whole would turn the broken parts into
+// BadExpr and friends, so only the declarations changed by a refactoring are
+// printed and spliced into the original source.
+type tolerantEdit struct {
+	src    []byte
+	errors scanner.ErrorList
+	// before holds the printed form of every top-level declaration
+	before map[ast.Decl]string
+	// ranges holds the original offsets of every top-level declaration
+	ranges map[ast.Decl][2]int
+	// extents reach from each declaration to the next one. Declarations cut
+	// short by a syntax error have no valid end, so errors are looked up in
+	// the extent rather than the range.
+	extents map[ast.Decl][2]int
+}
+
+// snapshot records the declarations of file before it is modified. Files
+// without syntax errors need no snapshot and are printed as usual.
+func (mc *MainCoordinator) snapshot(file *ast.File, src []byte, syntaxErrors scanner.ErrorList) *tolerantEdit {
+	edit := &tolerantEdit{src: src, errors: syntaxErrors}
+	if len(syntaxErrors) == 0 {
+		return edit
+	}
+
+	edit.before = make(map[ast.Decl]string)
+	edit.ranges = make(map[ast.Decl][2]int)
+	edit.extents = make(map[ast.Decl][2]int)
+	for i, decl := range file.Decls {
+		start, end := declRange(decl)
+		startOffset := mc.fset.Position(start).Offset
+		endOffset := -1
+		if end.IsValid() && end >= start {
+			endOffset = mc.fset.Position(end).Offset
+		}
+		// Errors at the very end of the file belong to the last declaration
+		extent := len(src) + 1
+		if i+1 < len(file.Decls) {
+			next, _ := declRange(file.Decls[i+1])
+			extent = mc.fset.Position(next).Offset
+		}
+
+		edit.ranges[decl] = [2]int{startOffset, endOffset}
+		edit.extents[decl] = [2]int{startOffset, extent}
+		edit.before[decl] = mc.printDecl(file, decl)
+	}
+	return edit
+}
+
+// render returns the new content of the file. It fails when a changed
+// declaration overlaps a syntax error.
+func (mc *MainCoordinator) render(file *ast.File, edit *tolerantEdit) ([]byte, error) {
+	type splice struct {
+		start, end int
+		text       string
+	}
+	var splices []splice
+
+	current := make(map[ast.Decl]bool)
+	for _, decl := range file.Decls {
+		current[decl] = true
+		printed := mc.printDecl(file, decl)
+
+		original, known := edit.ranges[decl]
+		if !known {
+			// New declarations go right after the package clause
+			offset := mc.fset.Position(file.Name.End()).Offset
+			splices = append(splices, splice{offset, offset, "\n\n" + printed})
+			continue
+		}
+		if printed == edit.before[decl] {
+			continue
+		}
+		if broken := edit.overlapping(edit.extents[decl]); len(broken) > 0 || original[1] < 0 {
+			return nil, fmt.Errorf("changed code overlaps syntax errors: %v", broken)
+		}
+		splices = append(splices, splice{original[0], original[1], printed})
+	}
+	for decl, original := range edit.ranges {
+		if !current[decl] {
+			if broken := edit.overlapping(edit.extents[decl]); len(broken) > 0 || original[1] < 0 {
+				return nil, fmt.Errorf("removed code overlaps syntax errors: %v", broken)
+			}
+			splices = append(splices, splice{original[0], original[1], ""})
+		}
+	}
+
+	// Apply from the end so that earlier offsets stay valid
+	sort.SliceStable(splices, func(i, j int) bool { return splices[i].start > splices[j].start })
+	content := append([]byte(nil), edit.src...)
+	for _, s := range splices {
+		content = append(content[:s.start], append([]byte(s.text), content[s.end:]...)...)
+	}
+	return content, nil
+}
+
+// overlapping returns the syntax errors inside an offset range, the end
+// is exclusive
+func (edit *tolerantEdit) overlapping(offsets [2]int) scanner.ErrorList {
+	var result scanner.ErrorList
+	for _, e := range edit.errors {
+		if e.Pos.Offset >= offsets[0] && e.Pos.Offset < offsets[1] {
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
+// tolerated reports the syntax errors that were left in place
+func (edit *tolerantEdit) tolerated(filePath string) []report.Change {
+	var changes []report.Change
+	for _, e := range edit.errors {
+		changes = append(changes, report.Change{
+			File:   filePath,
+			Line:   e.Pos.Line,
+			Column: e.Pos.Column,
+			Kind:   report.KindTolerated,
+			Text:   e.Msg,
+		})
+	}
+	return changes
+}
+
+// printDecl prints a top-level declaration with its doc and inner comments
+func (mc *MainCoordinator) printDecl(file *ast.File, decl ast.Decl) string {
+	start, end := declRange(decl)
+	var comments []*ast.CommentGroup
+	for _, group := range file.Comments {
+		if group.Pos() >= start && group.End() <= end {
+			comments = append(comments, group)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := printer.Fprint(&buf, mc.fset, &printer.CommentedNode{Node: decl, Comments: comments}); err != nil {
+		return ""
+	}
+	// Nodes added by modifiers have no positions, formatting fixes the layout
+	printed := buf.Bytes()
+	if formatted, err := format.Source(printed); err == nil {
+		printed = formatted
+	}
+	return strings.TrimRight(string(printed), "\n")
+}
+
+// declRange returns the extent of a declaration including its doc comment
+func declRange(decl ast.Decl) (token.Pos, token.Pos) {
+	start := decl.Pos()
+	switch d := decl.(type) {
+	case *ast.FuncDecl:
+		if d.Doc != nil {
+			start = d.Doc.Pos()
+		}
+	case *ast.GenDecl:
+		if d.Doc != nil {
+			start = d.Doc.Pos()
+		}
+	}
+	return start, decl.End()
+}
@@ -0,0 +1,322 @@
+package modifier
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// calleeShortName returns the bare name of the called function: "foo" for
+// foo() and "Bar" for x.Bar()
+func calleeShortName(callExpr *ast.CallExpr) string {
+	switch fun := callExpr.Fun.(type) {
+	case *ast.Ident:
+		return fun.Name
+	case *ast.SelectorExpr:
+		return fun.Sel.Name
+	}
+	return ""
+}
+
+// calleeResolver finds the declarations of a file that calls refer to
+type calleeResolver struct {
+	info *types.Info
+	// decls maps the position of each declared name to its declaration
+	decls map[token.Pos]*ast.FuncDecl
+}
+
+// newCalleeResolver resolves calls in file with info. Without type
+// information only plain calls of the file's functions are resolved.
+func newCalleeResolver(file *ast.File, info *types.Info) *calleeResolver {
+	decls := make(map[token.Pos]*ast.FuncDecl)
+	for _, decl := range file.Decls {
+		if funcDecl, ok := decl.(*ast.FuncDecl); ok {
+			decls[funcDecl.Name.Pos()] = funcDecl
+		}
+	}
+	return &calleeResolver{info: info, decls: decls}
+}
+
+// resolve returns the declaration of the function or method called by
+// callExpr, nil if it is not declared in the file. x.Foo() on another type or
+// package is not a call of a local Foo.
+func (r *calleeResolver) resolve(callExpr *ast.CallExpr) *ast.FuncDecl {
+	fun := ast.Unparen(callExpr.Fun)
+	switch x := fun.(type) {
+	case *ast.IndexExpr:
+		fun = x.X
+	case *ast.IndexListExpr:
+		fun = x.X
+	}
+
+	var name *ast.Ident
+	switch x := fun.(type) {
+	case *ast.Ident:
+		name = x
+	case *ast.SelectorExpr:
+		name = x.Sel
+	default:
+		return nil
+	}
+
+	if r.info != nil {
+		if obj, ok := r.info.Uses[name]; ok {
+			fn, ok := obj.(*types.Func)
+			if !ok {
+				return nil
+			}
+			return r.decls[fn.Origin().Pos()]
+		}
+	}
+	if name == fun && name.Obj != nil && name.Obj.Kind == ast.Fun {
+		if funcDecl, ok := name.Obj.Decl.(*ast.FuncDecl); ok && r.decls[funcDecl.Name.Pos()] == funcDecl {
+			return funcDecl
+		}
+	}
+	return nil
+}
+
+// isSelector reports whether expr is pkg.name
+func isSelector(expr ast.Expr, pkg, name string) bool {
+	sel, ok := expr.(*ast.SelectorExpr)
+	if !ok {
+		return false
+	}
+	ident, ok := sel.X.(*ast.Ident)
+	return ok && ident.Name == pkg && sel.Sel.Name == name
+}
+
+// isPointerTo reports whether expr is *pkg.name
+func isPointerTo(expr ast.Expr, pkg, name string) bool {
+	star, ok := expr.(*ast.StarExpr)
+	return ok && isSelector(star.X, pkg, name)
+}
+
+// paramCount returns the number of parameters declared in a field list
+func paramCount(fields *ast.FieldList) int {
+	if fields == nil {
+		return 0
+	}
+	count := 0
+	for _, field := range fields.List {
+		if len(field.Names) == 0 {
+			count++
+		} else {
+			count += len(field.Names)
+		}
+	}
+	return count
+}
+
+// findParam returns the name of the first parameter whose type matches
+func findParam(fields *ast.FieldList, match func(ast.Expr) bool) (string, bool) {
+	if fields == nil {
+		return "", false
+	}
+	for _, field := range fields.List {
+		if !match(field.Type) {
+			continue
+		}
+		for _, name := range field.Names {
+			if name.Name != "_" {
+				return name.Name, true
+			}
+		}
+	}
+	return "", false
+}
+
+// hasImport reports whether the file imports path
+func hasImport(file *ast.File, path string) bool {
+	for _, imp := range file.Imports {
+		if value, err := strconv.Unquote(imp.Path.Value); err == nil && value == path {
+			return true
+		}
+	}
+	return false
+}
+
+// ensureImport adds an import of path to the file unless it is already there.
+// It reports whether the file was changed.
+func ensureImport(file *ast.File, path string) bool {
+	if hasImport(file, path) {
+		return false
+	}
+
+	spec := &ast.ImportSpec{
+		Path: &ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(path)},
+	}
+	file.Imports = append(file.Imports, spec)
+
+	for _, decl := range file.Decls {
+		genDecl, ok := decl.(*ast.GenDecl)
+		if !ok || genDecl.Tok != token.IMPORT {
+			continue
+		}
+		i := importIndex(genDecl.Specs, path)
+		genDecl.Specs = append(genDecl.Specs[:i], append([]ast.Spec{spec}, genDecl.Specs[i:]...)...)
+		if !genDecl.Lparen.IsValid() {
+			// A single import without parentheses becomes a block
+			genDecl.Lparen = genDecl.Specs[0].Pos()
+		}
+		return true
+	}
+
+	genDecl := &ast.GenDecl{
+		Tok:   token.IMPORT,
+		Specs: []ast.Spec{spec},
+	}
+	file.Decls = append([]ast.Decl{genDecl}, file.Decls...)
+	return true
+}
+
+// importIndex returns where an import of path goes among specs: in sorted
+// order within the standard library imports, which come first, or sorted
+// among the others
+func importIndex(specs []ast.Spec, path string) int {
+	std := isStandardImport(path)
+	last := -1
+	for i, spec := range specs {
+		value, err := strconv.Unquote(spec.(*ast.ImportSpec).Path.Value)
+		if err != nil || isStandardImport(value) != std {
+			continue
+		}
+		if value > path {
+			return i
+		}
+		last = i
+	}
+	switch {
+	case last >= 0:
+		return last + 1
+	case std:
+		return 0
+	}
+	return len(specs)
+}
+
+// isStandardImport reports whether path belongs to the standard library,
+// whose paths have no dot in their first element
+func isStandardImport(path string) bool {
+	first, _, _ := strings.Cut(path, "/")
+	return !strings.Contains(first, ".")
+}
+
+// isTestEntryPoint reports whether a function is invoked by `go test`
+func isTestEntryPoint(funcDecl *ast.FuncDecl) bool {
+	if funcDecl.Recv != nil {
+		return false
+	}
+	for _, prefix := range []string{"Test", "Benchmark", "Fuzz", "Example"} {
+		if strings.HasPrefix(funcDecl.Name.Name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// isProgramRoot reports whether a function is called by the runtime or
+// the test runner rather than by user code
+func isProgramRoot(funcDecl *ast.FuncDecl) bool {
+	if funcDecl.Recv == nil && (funcDecl.Name.Name == "main" || funcDecl.Name.Name == "init") {
+		return true
+	}
+	return isTestEntryPoint(funcDecl)
+}
+
+// replaceExpr replaces every occurrence of old inside root with replacement
+func replaceExpr(root ast.Node, old, replacement ast.Expr) {
+	ast.Inspect(root, func(n ast.Node) bool {
+		if n == nil {
+			return false
+		}
+		value := reflect.ValueOf(n)
+		if value.Kind() != reflect.Ptr || value.Elem().Kind() != reflect.Struct {
+			return true
+		}
+		elem := value.Elem()
+		for i := 0; i < elem.NumField(); i++ {
+			field := elem.Field(i)
+			switch {
+			case field.Kind() == reflect.Interface && field.CanSet():
+				if !field.IsNil() && field.Interface() == ast.Node(old) {
+					field.Set(reflect.ValueOf(replacement))
+				}
+			case field.Kind() == reflect.Slice && field.Type().Elem() == reflect.TypeOf((*ast.Expr)(nil)).Elem():
+				for j := 0; j < field.Len(); j++ {
+					if field.Index(j).Interface() == ast.Expr(old) {
+						field.Index(j).Set(reflect.ValueOf(replacement))
+					}
+				}
+			}
+		}
+		return true
+	})
+}
+
+// zeroValue builds the zero value literal of a type expression. Local types
+// are looked up in file.
+func zeroValue(expr ast.Expr, file *ast.File) ast.Expr {
+	switch x := expr.(type) {
+	case *ast.Ident:
+		switch x.Name {
+		case "bool":
+			return ast.NewIdent("false")
+		case "string":
+			return &ast.BasicLit{Kind: token.STRING, Value: `""`}
+		case "int", "int8", "int16", "int32", "int64",
+			"uint", "uint8", "uint16", "uint32", "uint64", "uintptr",
+			"float32", "float64", "complex64", "complex128", "byte", "rune":
+			return &ast.BasicLit{Kind: token.INT, Value: "0"}
+		case "error", "any":
+			return ast.NewIdent("nil")
+		}
+		if typeSpec := findTypeSpec(file, x.Name); typeSpec != nil && typeSpec.TypeParams == nil {
+			switch underlying := typeSpec.Type.(type) {
+			case *ast.StructType, *ast.ArrayType:
+				if array, ok := underlying.(*ast.ArrayType); ok && array.Len == nil {
+					return ast.NewIdent("nil")
+				}
+				return &ast.CompositeLit{Type: ast.NewIdent(x.Name)}
+			case *ast.Ident:
+				if typeSpec.Assign.IsValid() || underlying.Name != x.Name {
+					return zeroValue(underlying, file)
+				}
+			default:
+				return zeroValue(underlying, file)
+			}
+		}
+	case *ast.StarExpr, *ast.MapType, *ast.ChanType, *ast.FuncType, *ast.InterfaceType:
+		return ast.NewIdent("nil")
+	case *ast.ArrayType:
+		if x.Len == nil {
+			return ast.NewIdent("nil")
+		}
+		return &ast.CompositeLit{Type: x}
+	case *ast.StructType:
+		return &ast.CompositeLit{Type: x}
+	}
+	// Works for any type, including imported and generic ones
+	return &ast.StarExpr{X: &ast.CallExpr{Fun: ast.NewIdent("new"), Args: []ast.Expr{expr}}}
+}
+
+func findTypeSpec(file *ast.File, name string) *ast.TypeSpec {
+	if file == nil {
+		return nil
+	}
+	for _, decl := range file.Decls {
+		genDecl, ok := decl.(*ast.GenDecl)
+		if !ok || genDecl.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range genDecl.Specs {
+			if typeSpec := spec.(*ast.TypeSpec); typeSpec.Name.Name == name {
+				return typeSpec
+			}
+		}
+	}
+	return nil
+}
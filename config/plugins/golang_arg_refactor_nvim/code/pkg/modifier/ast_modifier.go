@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	"github.com/back2nix/go-arg-propagation/pkg/logger"
+	"github.com/back2nix/go-arg-propagation/pkg/report"
 )
 
 type ASTModifier struct {
@@ -15,6 +16,7 @@ type ASTModifier struct {
 	modifiedFunctions  map[string]bool
 	anonymousFuncCount map[token.Pos]bool
 	fset               *token.FileSet
+	recorder           *report.Recorder
 	newArgName         string
 	newArgType         string
 }
@@ -35,6 +37,7 @@ func NewASTModifier(functionsToModify []string, fset *token.FileSet) *ASTModifie
 		modifiedFunctions:  make(map[string]bool),
 		anonymousFuncCount: make(map[token.Pos]bool),
 		fset:               fset,
+		recorder:           report.NewRecorder(fset),
 	}
 }
 
@@ -76,6 +79,8 @@ func (m *ASTModifier) modifyFuncDecl(funcDecl *ast.FuncDecl) {
 	}
 
 	funcDecl.Type.Params.List = append(funcDecl.Type.Params.List, newParam)
+	m.recorder.Add(funcDecl.Name.Pos(), report.KindDeclaration,
+		fmt.Sprintf("added parameter %s %s to %s", m.newArgName, m.newArgType, funcDecl.Name.Name))
 
 	if funcDecl.Body != nil {
 		m.modifyFunctionBody(funcDecl.Body)
@@ -114,6 +119,8 @@ func (m *ASTModifier) modifyFuncLit(funcLit *ast.FuncLit) {
 			Type:  ast.NewIdent(m.newArgType),
 		}
 		funcLit.Type.Params.List = append(funcLit.Type.Params.List, newParam)
+		m.recorder.Add(funcLit.Pos(), report.KindDeclaration,
+			fmt.Sprintf("added parameter %s %s to anonymous function", m.newArgName, m.newArgType))
 		logger.Log.DebugPrintf("Modified anonymous function: %s", funcName)
 	}
 
@@ -139,6 +146,7 @@ func (m *ASTModifier) modifyCallExpr(callExpr *ast.CallExpr) {
 		if len(callExpr.Args) < expectedArgCount {
 			newArg := &ast.Ident{Name: m.newArgName}
 			callExpr.Args = append(callExpr.Args, newArg)
+			m.recordCall(callExpr, funcName)
 			logger.Log.DebugPrintf("Modified function call: %s", shortFuncName)
 		}
 	}
@@ -148,6 +156,7 @@ func (m *ASTModifier) modifyCallExpr(callExpr *ast.CallExpr) {
 			m.modifyFuncLit(funcLit)
 			if i == len(callExpr.Args)-1 && m.ShouldModifyFunction(shortFuncName) {
 				callExpr.Args = append(callExpr.Args, &ast.Ident{Name: m.newArgName})
+				m.recordCall(callExpr, funcName)
 			}
 		}
 	}
@@ -156,12 +165,13 @@ func (m *ASTModifier) modifyCallExpr(callExpr *ast.CallExpr) {
 func (m *ASTModifier) modifyFunctionBody(body *ast.BlockStmt) {
 	ast.Inspect(body, func(n ast.Node) bool {
 		if returnStmt, ok := n.(*ast.ReturnStmt); ok {
-			for i, expr := range returnStmt.Results {
+			for _, expr := range returnStmt.Results {
 				if call, ok := expr.(*ast.CallExpr); ok {
 					if ident, ok := call.Fun.(*ast.Ident); ok {
 						if m.ShouldModifyFunction(ident.Name) {
-							call.Args = append(call.Args, ast.NewIdent(m.newArgName))
-							returnStmt.Results[i] = call
+							// Goes through the argument count check so the call
+							// is not extended twice when it is visited again
+							m.modifyCallExpr(call)
 						}
 					}
 				}
@@ -171,6 +181,16 @@ func (m *ASTModifier) modifyFunctionBody(body *ast.BlockStmt) {
 	})
 }
 
+func (m *ASTModifier) recordCall(callExpr *ast.CallExpr, funcName string) {
+	m.recorder.Add(callExpr.Pos(), report.KindCallSite,
+		fmt.Sprintf("passed %s to %s", m.newArgName, funcName))
+}
+
+// Changes returns every declaration and call site touched so far
+func (m *ASTModifier) Changes() []report.Change {
+	return m.recorder.Changes()
+}
+
 func (m *ASTModifier) extractFuncName(callExpr *ast.CallExpr) (string, bool) {
 	switch fun := callExpr.Fun.(type) {
 	case *ast.Ident:
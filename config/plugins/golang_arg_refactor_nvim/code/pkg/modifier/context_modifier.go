@@ -0,0 +1,534 @@
+package modifier
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"sort"
+	"strings"
+
+	"github.com/back2nix/go-arg-propagation/pkg/logger"
+	"github.com/back2nix/go-arg-propagation/pkg/report"
+)
+
+// ContextParamName is the name of the parameter inserted by ContextModifier
+const ContextParamName = "ctx"
+
+// parentContextName names the inserted parameter in functions that declare
+// a ctx of their own, usually derived from it with a deadline or a value
+const parentContextName = "parentCtx"
+
+// ContextModifier threads ctx context.Context through a call chain. Functions
+// in the chain get ctx as their first parameter, call sites pass the ctx that
+// is in scope, and context.TODO()/context.Background() calls inside the chain
+// are replaced with the threaded ctx.
+type ContextModifier struct {
+	functionsToModify map[string]struct{}
+	fset              *token.FileSet
+	recorder          *report.Recorder
+	// info resolves the callees of method and package calls
+	info *types.Info
+}
+
+func NewContextModifier(functionsToModify []string, fset *token.FileSet) *ContextModifier {
+	modifierMap := make(map[string]struct{})
+	for _, funcName := range functionsToModify {
+		modifierMap[funcName] = struct{}{}
+	}
+
+	logger.Log.DebugPrintf("[ContextModifier] functionsToModify: %s", functionsToModify)
+
+	return &ContextModifier{
+		functionsToModify: modifierMap,
+		fset:              fset,
+		recorder:          report.NewRecorder(fset),
+	}
+}
+
+// contextSource describes where a function gets its ctx from
+type contextSource struct {
+	// expr builds the expression passed to callees
+	expr func() ast.Expr
+	// threaded is true when ctx comes from a parameter or a request rather
+	// than being created at the root
+	threaded bool
+}
+
+// SetInfo supplies the type information calls are resolved with. Without it
+// only plain calls of the file's functions get ctx.
+func (m *ContextModifier) SetInfo(info *types.Info) {
+	m.info = info
+}
+
+// plan decides which functions get a new ctx parameter, so that call sites
+// can be updated regardless of declaration order. It returns their parameter
+// count before the change and the name of the new parameter.
+func (m *ContextModifier) plan(file *ast.File) (map[*ast.FuncDecl]int, map[*ast.FuncDecl]string) {
+	newParam := make(map[*ast.FuncDecl]int)
+	paramNames := make(map[*ast.FuncDecl]string)
+	for _, decl := range file.Decls {
+		funcDecl, ok := decl.(*ast.FuncDecl)
+		if !ok || !m.ShouldModifyFunction(funcDecl.Name.Name) {
+			continue
+		}
+		if _, ok := findParam(funcDecl.Type.Params, isContextType); ok {
+			continue
+		}
+		if isProgramRoot(funcDecl) || isHTTPHandler(funcDecl) {
+			continue
+		}
+		newParam[funcDecl] = paramCount(funcDecl.Type.Params)
+		paramNames[funcDecl] = contextParamName(funcDecl)
+	}
+	return newParam, paramNames
+}
+
+// DropRootContexts returns src without the top-level
+// `ctx := context.Background()` statements of the functions that get a ctx
+// parameter, which replaces them. Whole lines are removed so that no blank
+// line is left behind. It reports whether anything was dropped; the file has
+// to be parsed again from the returned source before calling Modify.
+func (m *ContextModifier) DropRootContexts(file *ast.File, src []byte) ([]byte, bool) {
+	_, paramNames := m.plan(file)
+
+	var ranges [][2]int
+	for funcDecl, name := range paramNames {
+		if funcDecl.Body == nil || name != ContextParamName {
+			continue
+		}
+		for _, stmt := range funcDecl.Body.List {
+			if !isRootContext(funcDecl.Body, stmt) {
+				continue
+			}
+			m.recorder.Add(stmt.Pos(), report.KindCallSite,
+				fmt.Sprintf("removed local %s in %s", ContextParamName, funcDecl.Name.Name))
+			start, end := m.fset.Position(stmt.Pos()).Offset, m.fset.Position(stmt.End()).Offset
+			ranges = append(ranges, statementLines(src, start, end))
+		}
+	}
+	if len(ranges) == 0 {
+		return src, false
+	}
+
+	// Cut from the end so that earlier offsets stay valid
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i][0] > ranges[j][0] })
+	result := append([]byte(nil), src...)
+	for _, r := range ranges {
+		result = append(result[:r[0]], result[r[1]:]...)
+	}
+	return result, true
+}
+
+// statementLines widens the offsets of a statement to its whole lines when
+// nothing but blanks and a trailing comment share them
+func statementLines(src []byte, start, end int) [2]int {
+	lineStart := bytes.LastIndexByte(src[:start], '\n') + 1
+	lineEnd := len(src)
+	if i := bytes.IndexByte(src[end:], '\n'); i >= 0 {
+		lineEnd = end + i + 1
+	}
+	before := bytes.TrimSpace(src[lineStart:start])
+	after := bytes.TrimSpace(src[end:lineEnd])
+	if len(before) == 0 && (len(after) == 0 || bytes.HasPrefix(after, []byte("//"))) {
+		return [2]int{lineStart, lineEnd}
+	}
+	return [2]int{start, end}
+}
+
+func (m *ContextModifier) Modify(file *ast.File) error {
+	newParam, paramNames := m.plan(file)
+	resolver := newCalleeResolver(file, m.info)
+
+	needsImport := false
+	for _, decl := range file.Decls {
+		funcDecl, ok := decl.(*ast.FuncDecl)
+		if !ok || funcDecl.Body == nil {
+			continue
+		}
+
+		source := m.contextSourceFor(funcDecl, paramNames)
+
+		if name, ok := paramNames[funcDecl]; ok {
+			m.addContextParam(funcDecl, name)
+			needsImport = true
+		}
+
+		if source.threaded && m.ShouldModifyFunction(funcDecl.Name.Name) {
+			m.replaceContextConstructors(funcDecl, source)
+		}
+
+		if m.passContextToCalls(funcDecl.Body, resolver, newParam, source) {
+			needsImport = true
+		}
+	}
+
+	if needsImport && ensureImport(file, "context") {
+		m.recorder.Add(file.Name.Pos(), report.KindImport, `added import "context"`)
+	}
+
+	return nil
+}
+
+// contextSourceFor returns where the calls of funcDecl get ctx from when no
+// context local is in scope. paramNames holds the names of the parameters
+// about to be added.
+func (m *ContextModifier) contextSourceFor(funcDecl *ast.FuncDecl, paramNames map[*ast.FuncDecl]string) contextSource {
+	if name, ok := findParam(funcDecl.Type.Params, isContextType); ok {
+		return contextSource{expr: identExpr(name), threaded: true}
+	}
+	if name, ok := paramNames[funcDecl]; ok {
+		return contextSource{expr: identExpr(name), threaded: true}
+	}
+	if isHTTPHandler(funcDecl) {
+		if name, ok := findParam(funcDecl.Type.Params, isHTTPRequest); ok {
+			return contextSource{
+				expr: func() ast.Expr {
+					return &ast.CallExpr{Fun: &ast.SelectorExpr{X: ast.NewIdent(name), Sel: ast.NewIdent("Context")}}
+				},
+				threaded: true,
+			}
+		}
+	}
+	if isProgramRoot(funcDecl) {
+		return contextSource{expr: contextCall("Background")}
+	}
+	// A caller outside the chain has no ctx to pass; make that visible
+	return contextSource{expr: contextCall("TODO")}
+}
+
+// contextParamName returns the name of the ctx parameter added to funcDecl.
+// A body that declares ctx, other than the `ctx := context.Background()`
+// dropped for the parameter, keeps it and gets parentCtx instead.
+func contextParamName(funcDecl *ast.FuncDecl) string {
+	if funcDecl.Body == nil {
+		return ContextParamName
+	}
+	declared := false
+	ast.Inspect(funcDecl.Body, func(n ast.Node) bool {
+		switch x := n.(type) {
+		case *ast.AssignStmt:
+			if x.Tok != token.DEFINE || isRootContext(funcDecl.Body, x) {
+				return true
+			}
+			for _, lhs := range x.Lhs {
+				if ident, ok := lhs.(*ast.Ident); ok && ident.Name == ContextParamName {
+					declared = true
+				}
+			}
+		case *ast.RangeStmt:
+			for _, expr := range []ast.Expr{x.Key, x.Value} {
+				if ident, ok := expr.(*ast.Ident); ok && x.Tok == token.DEFINE && ident.Name == ContextParamName {
+					declared = true
+				}
+			}
+		case *ast.ValueSpec:
+			for _, name := range x.Names {
+				if name.Name == ContextParamName {
+					declared = true
+				}
+			}
+		}
+		return !declared
+	})
+	if declared {
+		return parentContextName
+	}
+	return ContextParamName
+}
+
+// isRootContext reports whether stmt is a top-level
+// `ctx := context.Background()` or `ctx := context.TODO()` of body
+func isRootContext(body *ast.BlockStmt, stmt ast.Stmt) bool {
+	assign, ok := stmt.(*ast.AssignStmt)
+	if !ok || assign.Tok != token.DEFINE || len(assign.Lhs) != 1 || len(assign.Rhs) != 1 || !isContextConstructor(assign.Rhs[0]) {
+		return false
+	}
+	if ident, ok := assign.Lhs[0].(*ast.Ident); !ok || ident.Name != ContextParamName {
+		return false
+	}
+	for _, top := range body.List {
+		if top == stmt {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *ContextModifier) addContextParam(funcDecl *ast.FuncDecl, name string) {
+	if funcDecl.Type.Params == nil {
+		funcDecl.Type.Params = &ast.FieldList{}
+	}
+	// Placed at the opening parenthesis the printer keeps the comments of
+	// the body in the body and the parameters on one line
+	pos := funcDecl.Type.Params.Opening
+	newParam := &ast.Field{
+		Names: []*ast.Ident{{NamePos: pos, Name: name}},
+		Type:  &ast.SelectorExpr{X: &ast.Ident{NamePos: pos, Name: "context"}, Sel: &ast.Ident{NamePos: pos, Name: "Context"}},
+	}
+	funcDecl.Type.Params.List = append([]*ast.Field{newParam}, funcDecl.Type.Params.List...)
+
+	m.recorder.Add(funcDecl.Name.Pos(), report.KindDeclaration,
+		fmt.Sprintf("added parameter %s context.Context to %s", name, funcDecl.Name.Name))
+	logger.Log.DebugPrintf("[ContextModifier] Added ctx to %s", funcDecl.Name.Name)
+}
+
+// replaceContextConstructors swaps context.TODO() and context.Background()
+// for the threaded ctx. A top-level `ctx := context.Background()` replaced by
+// the parameter was already dropped by DropRootContexts.
+func (m *ContextModifier) replaceContextConstructors(funcDecl *ast.FuncDecl, source contextSource) {
+	body := funcDecl.Body
+	replace := func(expr ast.Expr) ast.Expr {
+		if !isContextConstructor(expr) {
+			return expr
+		}
+		m.recorder.Add(expr.Pos(), report.KindCallSite,
+			fmt.Sprintf("replaced %s with threaded ctx in %s", exprString(expr), funcDecl.Name.Name))
+		return source.expr()
+	}
+
+	ast.Inspect(body, func(n ast.Node) bool {
+		switch x := n.(type) {
+		case *ast.CallExpr:
+			for i, arg := range x.Args {
+				x.Args[i] = replace(arg)
+			}
+		case *ast.AssignStmt:
+			for i, rhs := range x.Rhs {
+				x.Rhs[i] = replace(rhs)
+			}
+		case *ast.ValueSpec:
+			for i, value := range x.Values {
+				x.Values[i] = replace(value)
+			}
+		case *ast.ReturnStmt:
+			for i, result := range x.Results {
+				x.Results[i] = replace(result)
+			}
+		case *ast.KeyValueExpr:
+			x.Value = replace(x.Value)
+		}
+		return true
+	})
+}
+
+// passContextToCalls prepends ctx to every call of a function that received a
+// new ctx parameter. A ctx or context.Context local declared before the call
+// is passed when there is one, source otherwise. It reports whether a
+// context.Background() or context.TODO() call was inserted.
+func (m *ContextModifier) passContextToCalls(body *ast.BlockStmt, resolver *calleeResolver, newParam map[*ast.FuncDecl]int, source contextSource) bool {
+	locals := contextLocals(body)
+	changed := false
+	ast.Inspect(body, func(n ast.Node) bool {
+		callExpr, ok := n.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+		callee := resolver.resolve(callExpr)
+		originalCount, ok := newParam[callee]
+		if callee == nil || !ok || len(callExpr.Args) != originalCount {
+			return true
+		}
+		arg := source.expr()
+		if name, ok := localAt(locals, callExpr.Pos()); ok {
+			arg = ast.NewIdent(name)
+		}
+		callExpr.Args = append([]ast.Expr{arg}, callExpr.Args...)
+		m.recorder.Add(callExpr.Pos(), report.KindCallSite,
+			fmt.Sprintf("passed %s to %s", exprString(arg), callee.Name.Name))
+		if isContextConstructor(arg) {
+			changed = true
+		}
+		return true
+	})
+	return changed
+}
+
+// contextLocal is a context variable declared in a function body. It is
+// visible from the end of its declaration to the end of its scope.
+type contextLocal struct {
+	name       string
+	start, end token.Pos
+}
+
+// contextLocals collects the context variables declared in body
+func contextLocals(body *ast.BlockStmt) []contextLocal {
+	var locals []contextLocal
+	add := func(stmt ast.Stmt, scopeEnd token.Pos) {
+		for _, name := range declaredContexts(stmt) {
+			locals = append(locals, contextLocal{name: name, start: stmt.End(), end: scopeEnd})
+		}
+	}
+	ast.Inspect(body, func(n ast.Node) bool {
+		switch x := n.(type) {
+		case *ast.BlockStmt:
+			for _, stmt := range x.List {
+				add(stmt, x.End())
+			}
+		case *ast.CaseClause:
+			for _, stmt := range x.Body {
+				add(stmt, x.End())
+			}
+		case *ast.CommClause:
+			for _, stmt := range x.Body {
+				add(stmt, x.End())
+			}
+		case *ast.IfStmt:
+			if x.Init != nil {
+				add(x.Init, x.End())
+			}
+		case *ast.SwitchStmt:
+			if x.Init != nil {
+				add(x.Init, x.End())
+			}
+		case *ast.TypeSwitchStmt:
+			if x.Init != nil {
+				add(x.Init, x.End())
+			}
+		}
+		return true
+	})
+	return locals
+}
+
+// localAt returns the context local visible at pos that was declared last
+func localAt(locals []contextLocal, pos token.Pos) (string, bool) {
+	best := -1
+	for i, local := range locals {
+		if local.start <= pos && pos < local.end && (best < 0 || local.start > locals[best].start) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return "", false
+	}
+	return locals[best].name, true
+}
+
+// declaredContexts returns the variables declared by stmt that hold a
+// context: those named ctx, those declared as context.Context and those
+// initialized by the context package or a Context() method
+func declaredContexts(stmt ast.Stmt) []string {
+	var names []string
+	check := func(ident *ast.Ident, typ ast.Expr, value ast.Expr) {
+		if ident.Name == "_" {
+			return
+		}
+		if ident.Name == ContextParamName || (typ != nil && isContextType(typ)) || (value != nil && isContextValue(value)) {
+			names = append(names, ident.Name)
+		}
+	}
+	// valueAt returns the expression assigned to the i-th name; a call with
+	// several results only tells the first one
+	valueAt := func(values []ast.Expr, i, count int) ast.Expr {
+		if len(values) == count {
+			return values[i]
+		}
+		if len(values) == 1 && i == 0 {
+			return values[0]
+		}
+		return nil
+	}
+
+	switch x := stmt.(type) {
+	case *ast.AssignStmt:
+		if x.Tok != token.DEFINE {
+			return nil
+		}
+		for i, lhs := range x.Lhs {
+			if ident, ok := lhs.(*ast.Ident); ok {
+				check(ident, nil, valueAt(x.Rhs, i, len(x.Lhs)))
+			}
+		}
+	case *ast.DeclStmt:
+		genDecl, ok := x.Decl.(*ast.GenDecl)
+		if !ok || genDecl.Tok != token.VAR {
+			return nil
+		}
+		for _, spec := range genDecl.Specs {
+			valueSpec := spec.(*ast.ValueSpec)
+			for i, name := range valueSpec.Names {
+				check(name, valueSpec.Type, valueAt(valueSpec.Values, i, len(valueSpec.Names)))
+			}
+		}
+	}
+	return names
+}
+
+// isContextValue reports whether expr makes a context: context.Background()
+// and friends, context.WithCancel(...) and friends, or x.Context()
+func isContextValue(expr ast.Expr) bool {
+	call, ok := expr.(*ast.CallExpr)
+	if !ok {
+		return false
+	}
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok {
+		return false
+	}
+	if ident, ok := sel.X.(*ast.Ident); ok && ident.Name == "context" {
+		return sel.Sel.Name == "Background" || sel.Sel.Name == "TODO" || strings.HasPrefix(sel.Sel.Name, "With")
+	}
+	return sel.Sel.Name == "Context" && len(call.Args) == 0
+}
+
+func (m *ContextModifier) ShouldModifyFunction(funcName string) bool {
+	_, shouldModify := m.functionsToModify[funcName]
+	return shouldModify
+}
+
+// Changes returns every declaration and call site touched so far
+func (m *ContextModifier) Changes() []report.Change {
+	return m.recorder.Changes()
+}
+
+func isContextType(expr ast.Expr) bool {
+	return isSelector(expr, "context", "Context")
+}
+
+func isHTTPRequest(expr ast.Expr) bool {
+	return isPointerTo(expr, "http", "Request")
+}
+
+// isHTTPHandler reports whether the function has the http.HandlerFunc shape
+func isHTTPHandler(funcDecl *ast.FuncDecl) bool {
+	if funcDecl.Type.Params == nil || paramCount(funcDecl.Type.Params) != 2 {
+		return false
+	}
+	_, hasWriter := findParam(funcDecl.Type.Params, func(expr ast.Expr) bool {
+		return isSelector(expr, "http", "ResponseWriter")
+	})
+	_, hasRequest := findParam(funcDecl.Type.Params, isHTTPRequest)
+	return hasWriter && hasRequest
+}
+
+func isContextConstructor(expr ast.Expr) bool {
+	call, ok := expr.(*ast.CallExpr)
+	if !ok || len(call.Args) != 0 {
+		return false
+	}
+	return isSelector(call.Fun, "context", "TODO") || isSelector(call.Fun, "context", "Background")
+}
+
+func identExpr(name string) func() ast.Expr {
+	return func() ast.Expr { return ast.NewIdent(name) }
+}
+
+func contextCall(name string) func() ast.Expr {
+	return func() ast.Expr {
+		return &ast.CallExpr{Fun: &ast.SelectorExpr{X: ast.NewIdent("context"), Sel: ast.NewIdent(name)}}
+	}
+}
+
+func exprString(expr ast.Expr) string {
+	switch x := expr.(type) {
+	case *ast.Ident:
+		return x.Name
+	case *ast.SelectorExpr:
+		return exprString(x.X) + "." + x.Sel.Name
+	case *ast.CallExpr:
+		return exprString(x.Fun) + "()"
+	}
+	return "expression"
+}
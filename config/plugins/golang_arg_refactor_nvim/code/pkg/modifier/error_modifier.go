@@ -0,0 +1,714 @@
+package modifier
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"sort"
+	"strings"
+
+	"github.com/back2nix/go-arg-propagation/pkg/logger"
+	"github.com/back2nix/go-arg-propagation/pkg/report"
+)
+
+// ErrorModifier adds an error result to a function and propagates error
+// handling up its callers. Callers that can return the error get an error
+// result themselves. Callers in main and in tests handle it with panic(err),
+// callers at the stop-points or beyond the depth limit get `_ = err`, marked
+// with a TODO comment by AddTODOs, since a panic there would take down the
+// whole program.
+type ErrorModifier struct {
+	callers  func(funcName string) []string
+	maxDepth int
+	stopAt   map[string]bool
+	fset     *token.FileSet
+	recorder *report.Recorder
+	// info resolves the callees of method and package calls
+	info *types.Info
+
+	file     *ast.File
+	resolver *calleeResolver
+	// growing maps every function that gains an error result to the number
+	// of results it had before
+	growing map[*ast.FuncDecl]int
+	// generated holds return statements created by the modifier
+	generated map[*ast.ReturnStmt]bool
+	// todos holds the `_ = err` statements that need a TODO comment
+	todos    map[ast.Stmt]bool
+	tmpCount int
+}
+
+// NewErrorModifier creates an ErrorModifier. callers returns the direct
+// callers of a function, maxDepth <= 0 means no limit.
+func NewErrorModifier(fset *token.FileSet, callers func(string) []string, maxDepth int, stopAt []string) *ErrorModifier {
+	stop := make(map[string]bool)
+	for _, funcName := range stopAt {
+		stop[funcName] = true
+	}
+	return &ErrorModifier{
+		callers:   callers,
+		maxDepth:  maxDepth,
+		stopAt:    stop,
+		fset:      fset,
+		recorder:  report.NewRecorder(fset),
+		growing:   make(map[*ast.FuncDecl]int),
+		generated: make(map[*ast.ReturnStmt]bool),
+		todos:     make(map[ast.Stmt]bool),
+	}
+}
+
+// SetInfo supplies the type information calls are resolved with. Without it
+// only plain calls of the file's functions are rewritten.
+func (m *ErrorModifier) SetInfo(info *types.Info) {
+	m.info = info
+}
+
+// errorScope describes how errors are handled inside a function body
+type errorScope struct {
+	name    string
+	results *ast.FieldList
+	// propagate is true when the scope returns the error instead of handling
+	// it in place
+	propagate bool
+	// root is true in main, init and tests, where an error may panic
+	root bool
+	// grows is true when the scope gains an error result
+	grows bool
+	// originalResults is the result count before the error result was added
+	originalResults int
+}
+
+func (m *ErrorModifier) Modify(file *ast.File, targetFunc string) error {
+	m.file = file
+	m.resolver = newCalleeResolver(file, m.info)
+
+	decls := make(map[string]*ast.FuncDecl)
+	for _, decl := range file.Decls {
+		if funcDecl, ok := decl.(*ast.FuncDecl); ok {
+			if _, exists := decls[funcDecl.Name.Name]; !exists {
+				decls[funcDecl.Name.Name] = funcDecl
+			}
+		}
+	}
+
+	target := decls[shortName(targetFunc)]
+	if target == nil {
+		return fmt.Errorf("function %s not found", targetFunc)
+	}
+	if returnsError(target.Type) {
+		return fmt.Errorf("function %s already returns an error", target.Name.Name)
+	}
+
+	m.planPropagation(target, decls)
+	var growing []string
+	for decl := range m.growing {
+		growing = append(growing, decl.Name.Name)
+	}
+	sort.Strings(growing)
+	logger.Log.DebugPrintf("[ErrorModifier] Functions gaining an error result: %v", growing)
+
+	for _, decl := range file.Decls {
+		funcDecl, ok := decl.(*ast.FuncDecl)
+		if !ok || funcDecl.Body == nil {
+			continue
+		}
+		scope := m.scopeFor(funcDecl)
+		if scope.grows {
+			m.addErrorResult(funcDecl)
+			scope.results = funcDecl.Type.Results
+		}
+		funcDecl.Body.List = m.rewriteBlock(funcDecl.Body.List, scope)
+		if scope.grows {
+			m.fixReturns(funcDecl, scope)
+		}
+	}
+
+	return nil
+}
+
+// planPropagation walks up the callers of target breadth-first and decides
+// which functions gain an error result
+func (m *ErrorModifier) planPropagation(target *ast.FuncDecl, decls map[string]*ast.FuncDecl) {
+	type level struct {
+		decl  *ast.FuncDecl
+		depth int
+	}
+
+	m.growing[target] = paramCount(target.Type.Results)
+	queue := []level{{target, 0}}
+
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+
+		if m.maxDepth > 0 && current.depth >= m.maxDepth {
+			continue
+		}
+
+		for _, caller := range m.callers(current.decl.Name.Name) {
+			decl := decls[caller]
+			if decl == nil || m.stopAt[caller] {
+				continue
+			}
+			if _, ok := m.growing[decl]; ok {
+				continue
+			}
+			if decl.Body == nil || isProgramRoot(decl) || isHTTPHandler(decl) || returnsError(decl.Type) {
+				continue
+			}
+			// Calls made only from closures are handled inside the closure
+			if !m.callsDirectly(decl.Body, current.decl) {
+				continue
+			}
+			m.growing[decl] = paramCount(decl.Type.Results)
+			queue = append(queue, level{decl, current.depth + 1})
+		}
+	}
+}
+
+// callsDirectly reports whether body calls callee outside of function literals
+func (m *ErrorModifier) callsDirectly(body *ast.BlockStmt, callee *ast.FuncDecl) bool {
+	found := false
+	ast.Inspect(body, func(n ast.Node) bool {
+		switch x := n.(type) {
+		case *ast.FuncLit:
+			return false
+		case *ast.CallExpr:
+			if m.resolver.resolve(x) == callee {
+				found = true
+			}
+		}
+		return !found
+	})
+	return found
+}
+
+func (m *ErrorModifier) scopeFor(funcDecl *ast.FuncDecl) errorScope {
+	originalResults, grows := m.growing[funcDecl]
+	return errorScope{
+		name:            funcDecl.Name.Name,
+		results:         funcDecl.Type.Results,
+		propagate:       grows || returnsError(funcDecl.Type),
+		root:            isProgramRoot(funcDecl),
+		grows:           grows,
+		originalResults: originalResults,
+	}
+}
+
+func (m *ErrorModifier) addErrorResult(funcDecl *ast.FuncDecl) {
+	if funcDecl.Type.Results == nil {
+		funcDecl.Type.Results = &ast.FieldList{}
+	}
+	results := funcDecl.Type.Results
+
+	field := &ast.Field{Type: ast.NewIdent("error")}
+	if len(results.List) > 0 && len(results.List[0].Names) > 0 {
+		field.Names = []*ast.Ident{ast.NewIdent("err")}
+	}
+	results.List = append(results.List, field)
+
+	m.recorder.Add(funcDecl.Name.Pos(), report.KindDeclaration,
+		fmt.Sprintf("added error result to %s", funcDecl.Name.Name))
+}
+
+// fixReturns appends nil to the existing return statements of a function
+// that gained an error result
+func (m *ErrorModifier) fixReturns(funcDecl *ast.FuncDecl, scope errorScope) {
+	ast.Inspect(funcDecl.Body, func(n ast.Node) bool {
+		switch x := n.(type) {
+		case *ast.FuncLit:
+			return false
+		case *ast.ReturnStmt:
+			if m.generated[x] || m.isForwardingReturn(x, scope) {
+				return true
+			}
+			// Bare returns with named results already return err
+			if len(x.Results) == 0 && scope.originalResults > 0 {
+				return true
+			}
+			x.Results = append(x.Results, ast.NewIdent("nil"))
+		}
+		return true
+	})
+
+	if scope.originalResults == 0 && !endsWithTerminator(funcDecl.Body) {
+		funcDecl.Body.List = append(funcDecl.Body.List, &ast.ReturnStmt{
+			Results: []ast.Expr{ast.NewIdent("nil")},
+		})
+	}
+}
+
+// isForwardingReturn reports whether `return f(...)` already returns every
+// result of f, including its new error
+func (m *ErrorModifier) isForwardingReturn(ret *ast.ReturnStmt, scope errorScope) bool {
+	if len(ret.Results) != 1 {
+		return false
+	}
+	call, ok := ret.Results[0].(*ast.CallExpr)
+	if !ok {
+		return false
+	}
+	originalResults, ok := m.growingCallee(call)
+	return ok && originalResults+1 == paramCount(scope.results)
+}
+
+// growingCallee returns the result count before the change of the function
+// called by call, if it gains an error result
+func (m *ErrorModifier) growingCallee(call *ast.CallExpr) (int, bool) {
+	decl := m.resolver.resolve(call)
+	if decl == nil {
+		return 0, false
+	}
+	originalResults, ok := m.growing[decl]
+	return originalResults, ok
+}
+
+// rewriteBlock rewrites the calls of growing functions in a statement list
+func (m *ErrorModifier) rewriteBlock(list []ast.Stmt, scope errorScope) []ast.Stmt {
+	var result []ast.Stmt
+	for _, stmt := range list {
+		m.rewriteNested(stmt, scope)
+		result = append(result, m.rewriteStmt(stmt, scope, result)...)
+	}
+	return result
+}
+
+// rewriteNested processes blocks and function literals inside stmt
+func (m *ErrorModifier) rewriteNested(stmt ast.Stmt, scope errorScope) {
+	switch x := stmt.(type) {
+	case *ast.BlockStmt:
+		x.List = m.rewriteBlock(x.List, scope)
+	case *ast.IfStmt:
+		x.Body.List = m.rewriteBlock(x.Body.List, scope)
+		if x.Else != nil {
+			m.rewriteNested(x.Else, scope)
+		}
+	case *ast.ForStmt:
+		x.Body.List = m.rewriteBlock(x.Body.List, scope)
+	case *ast.RangeStmt:
+		x.Body.List = m.rewriteBlock(x.Body.List, scope)
+	case *ast.SwitchStmt:
+		m.rewriteNested(x.Body, scope)
+	case *ast.TypeSwitchStmt:
+		m.rewriteNested(x.Body, scope)
+	case *ast.SelectStmt:
+		m.rewriteNested(x.Body, scope)
+	case *ast.CaseClause:
+		x.Body = m.rewriteBlock(x.Body, scope)
+	case *ast.CommClause:
+		x.Body = m.rewriteBlock(x.Body, scope)
+	case *ast.LabeledStmt:
+		m.rewriteNested(x.Stmt, scope)
+	}
+
+	// Function literals get their own scope. Nested statements were already
+	// handled above, so only the expressions of stmt itself are searched.
+	ast.Inspect(stmt, func(n ast.Node) bool {
+		switch x := n.(type) {
+		case ast.Stmt:
+			return x == stmt
+		case *ast.FuncLit:
+			litScope := errorScope{
+				name:      "function literal",
+				results:   x.Type.Results,
+				propagate: returnsError(x.Type),
+				root:      scope.root,
+			}
+			x.Body.List = m.rewriteBlock(x.Body.List, litScope)
+			return false
+		}
+		return true
+	})
+}
+
+// rewriteStmt returns the statements replacing stmt. previous holds the
+// statements already emitted in the same block.
+func (m *ErrorModifier) rewriteStmt(stmt ast.Stmt, scope errorScope, previous []ast.Stmt) []ast.Stmt {
+	switch x := stmt.(type) {
+	case *ast.ExprStmt:
+		if call, ok := x.X.(*ast.CallExpr); ok {
+			if originalResults, ok := m.growingCallee(call); ok {
+				return []ast.Stmt{m.checkedCallStmt(call, originalResults, scope)}
+			}
+		}
+	case *ast.AssignStmt:
+		if len(x.Rhs) == 1 {
+			if call, ok := x.Rhs[0].(*ast.CallExpr); ok {
+				if originalResults, ok := m.growingCallee(call); ok && originalResults == len(x.Lhs) {
+					return m.checkedAssign(x, call, scope, previous)
+				}
+			}
+		}
+	case *ast.ReturnStmt:
+		if scope.propagate && m.isForwardingReturn(x, scope) {
+			call := x.Results[0].(*ast.CallExpr)
+			m.recorder.Add(call.Pos(), report.KindCallSite,
+				fmt.Sprintf("%s returns the error of %s", scope.name, calleeShortName(call)))
+			return []ast.Stmt{x}
+		}
+	}
+
+	return append(m.liftCalls(stmt, scope), stmt)
+}
+
+// checkedCallStmt turns `f(...)` into `if err := f(...); err != nil {...}`
+func (m *ErrorModifier) checkedCallStmt(call *ast.CallExpr, originalResults int, scope errorScope) ast.Stmt {
+	lhs := make([]ast.Expr, 0, originalResults+1)
+	for i := 0; i < originalResults; i++ {
+		lhs = append(lhs, ast.NewIdent("_"))
+	}
+	lhs = append(lhs, ast.NewIdent("err"))
+
+	m.recordCallSite(call, scope)
+	return &ast.IfStmt{
+		Init: &ast.AssignStmt{Lhs: lhs, Tok: token.DEFINE, Rhs: []ast.Expr{call}},
+		Cond: errNotNil(),
+		Body: m.handleError(scope),
+	}
+}
+
+// checkedAssign turns `x := f(...)` into `x, err := f(...)` followed by a check
+func (m *ErrorModifier) checkedAssign(assign *ast.AssignStmt, call *ast.CallExpr, scope errorScope, previous []ast.Stmt) []ast.Stmt {
+	var result []ast.Stmt
+	declared := declaresErr(previous) || m.isErrInSignature(scope)
+
+	switch assign.Tok {
+	case token.DEFINE:
+		if declared && allBlank(assign.Lhs) {
+			assign.Tok = token.ASSIGN
+		}
+	case token.ASSIGN:
+		if !declared {
+			result = append(result, varErrDecl())
+		}
+	}
+	assign.Lhs = append(assign.Lhs, ast.NewIdent("err"))
+
+	m.recordCallSite(call, scope)
+	return append(result, assign, &ast.IfStmt{Cond: errNotNil(), Body: m.handleError(scope)})
+}
+
+// liftCalls moves single-result calls of growing functions nested in stmt
+// into temporaries checked before stmt
+func (m *ErrorModifier) liftCalls(stmt ast.Stmt, scope errorScope) []ast.Stmt {
+	var calls []*ast.CallExpr
+	var unsupported []*ast.CallExpr
+
+	collect := func(root ast.Node, allowed bool) {
+		if root == nil {
+			return
+		}
+		ast.Inspect(root, func(n ast.Node) bool {
+			switch x := n.(type) {
+			case *ast.FuncLit, *ast.BlockStmt:
+				return false
+			case *ast.CallExpr:
+				originalResults, ok := m.growingCallee(x)
+				if !ok {
+					return true
+				}
+				if allowed && originalResults == 1 {
+					calls = append(calls, x)
+					return false
+				}
+				unsupported = append(unsupported, x)
+			}
+			return true
+		})
+	}
+
+	switch x := stmt.(type) {
+	case *ast.IfStmt:
+		collect(x.Init, true)
+		collect(x.Cond, true)
+		if elseIf, ok := x.Else.(*ast.IfStmt); ok {
+			collect(elseIf.Init, false)
+			collect(elseIf.Cond, false)
+		}
+	case *ast.ForStmt:
+		collect(x.Init, true)
+		collect(x.Cond, false)
+		collect(x.Post, false)
+	case *ast.RangeStmt:
+		collect(x.X, true)
+	case *ast.SwitchStmt:
+		collect(x.Init, true)
+		collect(x.Tag, true)
+	case *ast.TypeSwitchStmt:
+		collect(x.Init, true)
+		collect(x.Assign, true)
+	case *ast.ExprStmt, *ast.AssignStmt, *ast.ReturnStmt, *ast.DeclStmt,
+		*ast.SendStmt, *ast.IncDecStmt, *ast.GoStmt, *ast.DeferStmt:
+		collect(x, true)
+	}
+
+	for _, call := range unsupported {
+		m.recorder.Add(call.Pos(), report.KindCallSite,
+			fmt.Sprintf("error of %s is not handled, rewrite this call manually", calleeShortName(call)))
+	}
+
+	var result []ast.Stmt
+	for _, call := range calls {
+		tmp := ast.NewIdent(m.tmpName(calleeShortName(call)))
+		replaceExpr(stmt, call, tmp)
+
+		result = append(result,
+			&ast.AssignStmt{
+				Lhs: []ast.Expr{ast.NewIdent(tmp.Name), ast.NewIdent("err")},
+				Tok: token.DEFINE,
+				Rhs: []ast.Expr{call},
+			},
+			&ast.IfStmt{Cond: errNotNil(), Body: m.handleError(scope)},
+		)
+		m.recordCallSite(call, scope)
+	}
+
+	return result
+}
+
+// handleError builds the body run when err != nil
+func (m *ErrorModifier) handleError(scope errorScope) *ast.BlockStmt {
+	if !scope.propagate && scope.root {
+		return &ast.BlockStmt{List: []ast.Stmt{
+			&ast.ExprStmt{X: &ast.CallExpr{Fun: ast.NewIdent("panic"), Args: []ast.Expr{ast.NewIdent("err")}}},
+		}}
+	}
+	if !scope.propagate {
+		ignore := &ast.AssignStmt{
+			Lhs: []ast.Expr{ast.NewIdent("_")},
+			Tok: token.ASSIGN,
+			Rhs: []ast.Expr{ast.NewIdent("err")},
+		}
+		m.todos[ignore] = true
+		return &ast.BlockStmt{List: []ast.Stmt{ignore}}
+	}
+
+	var results []ast.Expr
+	if scope.results != nil {
+		for _, field := range scope.results.List {
+			count := len(field.Names)
+			if count == 0 {
+				count = 1
+			}
+			for i := 0; i < count; i++ {
+				results = append(results, zeroValue(field.Type, m.file))
+			}
+		}
+	}
+	// The last result is the error, either existing or just added
+	results[len(results)-1] = ast.NewIdent("err")
+
+	ret := &ast.ReturnStmt{Results: results}
+	m.generated[ret] = true
+	return &ast.BlockStmt{List: []ast.Stmt{ret}}
+}
+
+func (m *ErrorModifier) recordCallSite(call *ast.CallExpr, scope errorScope) {
+	switch {
+	case scope.propagate:
+		m.recorder.Add(call.Pos(), report.KindCallSite,
+			fmt.Sprintf("%s returns the error of %s", scope.name, calleeShortName(call)))
+	case scope.root:
+		m.recorder.Add(call.Pos(), report.KindCallSite,
+			fmt.Sprintf("%s panics on the error of %s", scope.name, calleeShortName(call)))
+	default:
+		m.recorder.Add(call.Pos(), report.KindManual,
+			fmt.Sprintf("%s ignores the error of %s, handle it at the TODO", scope.name, calleeShortName(call)))
+	}
+}
+
+// isErrInSignature reports whether err is a named parameter or result
+func (m *ErrorModifier) isErrInSignature(scope errorScope) bool {
+	if scope.results == nil {
+		return false
+	}
+	for _, field := range scope.results.List {
+		for _, name := range field.Names {
+			if name.Name == "err" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (m *ErrorModifier) tmpName(funcName string) string {
+	m.tmpCount++
+	name := strings.ToLower(funcName[:1]) + funcName[1:] + "Result"
+	if m.tmpCount > 1 {
+		name = fmt.Sprintf("%s%d", name, m.tmpCount)
+	}
+	return name
+}
+
+// AddTODOs returns src, the printed form of the modified file, with a
+// `// TODO: handle err` comment after every `_ = err` generated by Modify.
+// The printer has no position to place a comment on new nodes, so the
+// comments are added to the text. The statements are found again by their
+// function and their order among the `_ = err` statements in it.
+func (m *ErrorModifier) AddTODOs(src []byte) []byte {
+	if len(m.todos) == 0 {
+		return src
+	}
+
+	type site struct {
+		decl  string
+		index int
+	}
+	marked := make(map[site]bool)
+	for _, decl := range m.file.Decls {
+		if funcDecl, ok := decl.(*ast.FuncDecl); ok {
+			for i, stmt := range ignoredErrors(funcDecl) {
+				if m.todos[stmt] {
+					marked[site{funcKey(funcDecl), i}] = true
+				}
+			}
+		}
+	}
+
+	fset := token.NewFileSet()
+	printed, _ := parser.ParseFile(fset, "", src, parser.AllErrors)
+	if printed == nil {
+		return src
+	}
+	var offsets []int
+	for _, decl := range printed.Decls {
+		if funcDecl, ok := decl.(*ast.FuncDecl); ok {
+			for i, stmt := range ignoredErrors(funcDecl) {
+				if marked[site{funcKey(funcDecl), i}] {
+					offsets = append(offsets, fset.Position(stmt.End()).Offset)
+				}
+			}
+		}
+	}
+
+	// Insert from the end so that earlier offsets stay valid
+	sort.Sort(sort.Reverse(sort.IntSlice(offsets)))
+	result := append([]byte(nil), src...)
+	for _, offset := range offsets {
+		result = append(result[:offset], append([]byte(" // TODO: handle err"), result[offset:]...)...)
+	}
+	return result
+}
+
+// ignoredErrors returns the `_ = err` statements of funcDecl in source order
+func ignoredErrors(funcDecl *ast.FuncDecl) []ast.Stmt {
+	var stmts []ast.Stmt
+	if funcDecl.Body == nil {
+		return nil
+	}
+	ast.Inspect(funcDecl.Body, func(n ast.Node) bool {
+		assign, ok := n.(*ast.AssignStmt)
+		if !ok || assign.Tok != token.ASSIGN || len(assign.Lhs) != 1 || len(assign.Rhs) != 1 {
+			return true
+		}
+		lhs, lhsOK := assign.Lhs[0].(*ast.Ident)
+		rhs, rhsOK := assign.Rhs[0].(*ast.Ident)
+		if lhsOK && rhsOK && lhs.Name == "_" && rhs.Name == "err" {
+			stmts = append(stmts, assign)
+		}
+		return true
+	})
+	return stmts
+}
+
+// funcKey names a function declaration, methods after their receiver type
+func funcKey(funcDecl *ast.FuncDecl) string {
+	return receiverTypeName(funcDecl) + "." + funcDecl.Name.Name
+}
+
+// Changes returns every declaration and call site touched so far
+func (m *ErrorModifier) Changes() []report.Change {
+	return m.recorder.Changes()
+}
+
+func returnsError(funcType *ast.FuncType) bool {
+	if funcType.Results == nil || len(funcType.Results.List) == 0 {
+		return false
+	}
+	last := funcType.Results.List[len(funcType.Results.List)-1]
+	ident, ok := last.Type.(*ast.Ident)
+	return ok && ident.Name == "error"
+}
+
+func errNotNil() ast.Expr {
+	return &ast.BinaryExpr{X: ast.NewIdent("err"), Op: token.NEQ, Y: ast.NewIdent("nil")}
+}
+
+func varErrDecl() ast.Stmt {
+	return &ast.DeclStmt{Decl: &ast.GenDecl{
+		Tok: token.VAR,
+		Specs: []ast.Spec{&ast.ValueSpec{
+			Names: []*ast.Ident{ast.NewIdent("err")},
+			Type:  ast.NewIdent("error"),
+		}},
+	}}
+}
+
+// declaresErr reports whether any statement declares err in the current block
+func declaresErr(stmts []ast.Stmt) bool {
+	for _, stmt := range stmts {
+		switch x := stmt.(type) {
+		case *ast.AssignStmt:
+			if x.Tok != token.DEFINE {
+				continue
+			}
+			for _, lhs := range x.Lhs {
+				if ident, ok := lhs.(*ast.Ident); ok && ident.Name == "err" {
+					return true
+				}
+			}
+		case *ast.DeclStmt:
+			genDecl, ok := x.Decl.(*ast.GenDecl)
+			if !ok {
+				continue
+			}
+			for _, spec := range genDecl.Specs {
+				if valueSpec, ok := spec.(*ast.ValueSpec); ok {
+					for _, name := range valueSpec.Names {
+						if name.Name == "err" {
+							return true
+						}
+					}
+				}
+			}
+		}
+	}
+	return false
+}
+
+func allBlank(exprs []ast.Expr) bool {
+	for _, expr := range exprs {
+		if ident, ok := expr.(*ast.Ident); !ok || ident.Name != "_" {
+			return false
+		}
+	}
+	return true
+}
+
+// endsWithTerminator reports whether a body ends with return or panic
+func endsWithTerminator(body *ast.BlockStmt) bool {
+	if len(body.List) == 0 {
+		return false
+	}
+	switch x := body.List[len(body.List)-1].(type) {
+	case *ast.ReturnStmt:
+		return true
+	case *ast.ExprStmt:
+		if call, ok := x.X.(*ast.CallExpr); ok {
+			if ident, ok := call.Fun.(*ast.Ident); ok && ident.Name == "panic" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func shortName(funcName string) string {
+	if i := strings.LastIndex(funcName, "."); i >= 0 {
+		return funcName[i+1:]
+	}
+	return funcName
+}
@@ -0,0 +1,357 @@
+package modifier
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"strings"
+	"unicode"
+
+	"github.com/back2nix/go-arg-propagation/pkg/logger"
+	"github.com/back2nix/go-arg-propagation/pkg/report"
+)
+
+// FieldInjector turns a new dependency into a field on the receiver type of a
+// method instead of threading it through parameters. Uses inside the chain
+// become recv.field, and every function building the type with a composite
+// literal becomes a constructor that receives the dependency as a parameter.
+type FieldInjector struct {
+	functionsToModify map[string]struct{}
+	fset              *token.FileSet
+	recorder          *report.Recorder
+}
+
+func NewFieldInjector(functionsToModify []string, fset *token.FileSet) *FieldInjector {
+	modifierMap := make(map[string]struct{})
+	for _, funcName := range functionsToModify {
+		modifierMap[funcName] = struct{}{}
+	}
+
+	logger.Log.DebugPrintf("[FieldInjector] functionsToModify: %s", functionsToModify)
+
+	return &FieldInjector{
+		functionsToModify: modifierMap,
+		fset:              fset,
+		recorder:          report.NewRecorder(fset),
+	}
+}
+
+// Inject adds fieldName of fieldType to the receiver type of targetFunc and
+// returns the names of the constructors the dependency has to be passed to
+func (f *FieldInjector) Inject(file *ast.File, targetFunc, fieldName, fieldType string) ([]string, error) {
+	target, err := findMethod(file, targetFunc)
+	if err != nil {
+		return nil, err
+	}
+
+	typeName := receiverTypeName(target)
+	if typeName == "" {
+		return nil, fmt.Errorf("unsupported receiver type of %s", targetFunc)
+	}
+
+	structType := findStructType(file, typeName)
+	if structType == nil {
+		return nil, fmt.Errorf("struct type %s is not declared in this file", typeName)
+	}
+
+	f.addField(structType, typeName, fieldName, fieldType)
+
+	for _, decl := range file.Decls {
+		funcDecl, ok := decl.(*ast.FuncDecl)
+		if !ok || funcDecl.Body == nil {
+			continue
+		}
+		if receiverTypeName(funcDecl) == typeName && f.ShouldModifyFunction(funcDecl.Name.Name) {
+			f.rewriteUses(funcDecl, fieldName)
+		}
+	}
+
+	var constructors []string
+	for _, decl := range file.Decls {
+		funcDecl, ok := decl.(*ast.FuncDecl)
+		if !ok || funcDecl.Body == nil {
+			continue
+		}
+		if f.fillCompositeLiterals(funcDecl, typeName, fieldName) && receiverTypeName(funcDecl) != typeName {
+			constructors = append(constructors, funcDecl.Name.Name)
+		}
+	}
+
+	logger.Log.DebugPrintf("[FieldInjector] Constructors of %s: %v", typeName, constructors)
+	return constructors, nil
+}
+
+func (f *FieldInjector) addField(structType *ast.StructType, typeName, fieldName, fieldType string) {
+	for _, field := range structType.Fields.List {
+		for _, name := range field.Names {
+			if name.Name == fieldName {
+				return
+			}
+		}
+	}
+
+	structType.Fields.List = append(structType.Fields.List, &ast.Field{
+		Names: []*ast.Ident{ast.NewIdent(fieldName)},
+		Type:  ast.NewIdent(fieldType),
+	})
+	f.recorder.Add(structType.Pos(), report.KindDeclaration,
+		fmt.Sprintf("added field %s %s to %s", fieldName, fieldType, typeName))
+}
+
+// rewriteUses replaces free identifiers named fieldName with recv.fieldName
+func (f *FieldInjector) rewriteUses(funcDecl *ast.FuncDecl, fieldName string) {
+	recvName := f.ensureReceiverName(funcDecl)
+
+	// Selector names and struct literal keys are not references to a variable
+	skip := make(map[*ast.Ident]bool)
+	ast.Inspect(funcDecl.Body, func(n ast.Node) bool {
+		switch x := n.(type) {
+		case *ast.SelectorExpr:
+			skip[x.Sel] = true
+		case *ast.KeyValueExpr:
+			if ident, ok := x.Key.(*ast.Ident); ok {
+				skip[ident] = true
+			}
+		}
+		return true
+	})
+
+	var uses []*ast.Ident
+	ast.Inspect(funcDecl.Body, func(n ast.Node) bool {
+		ident, ok := n.(*ast.Ident)
+		if ok && ident.Name == fieldName && ident.Obj == nil && !skip[ident] {
+			uses = append(uses, ident)
+		}
+		return true
+	})
+
+	for _, ident := range uses {
+		replaceExpr(funcDecl.Body, ident, fieldSelector(recvName, fieldName, ident.Pos()))
+		f.recorder.Add(ident.Pos(), report.KindCallSite,
+			fmt.Sprintf("%s now reads %s.%s in %s", fieldName, recvName, fieldName, funcDecl.Name.Name))
+	}
+}
+
+// fieldSelector builds recv.field, placed at pos to keep the comments around
+// the replaced expression where they were
+func fieldSelector(recvName, fieldName string, pos token.Pos) *ast.SelectorExpr {
+	return &ast.SelectorExpr{
+		X:   &ast.Ident{NamePos: pos, Name: recvName},
+		Sel: &ast.Ident{NamePos: pos, Name: fieldName},
+	}
+}
+
+// ensureReceiverName names an anonymous receiver so that fields can be reached
+func (f *FieldInjector) ensureReceiverName(funcDecl *ast.FuncDecl) string {
+	recv := funcDecl.Recv.List[0]
+	if len(recv.Names) > 0 && recv.Names[0].Name != "_" {
+		return recv.Names[0].Name
+	}
+
+	typeName := receiverTypeName(funcDecl)
+	name := string(unicode.ToLower([]rune(typeName)[0]))
+	recv.Names = []*ast.Ident{ast.NewIdent(name)}
+	f.recorder.Add(recv.Pos(), report.KindDeclaration,
+		fmt.Sprintf("named receiver of %s %s", funcDecl.Name.Name, name))
+	return name
+}
+
+// fillCompositeLiterals sets fieldName in every literal of typeName inside
+// funcDecl. Methods of the type copy the value from their receiver, other
+// functions take it from the new parameter. It reports whether any literal
+// was changed.
+func (f *FieldInjector) fillCompositeLiterals(funcDecl *ast.FuncDecl, typeName, fieldName string) bool {
+	value := func() ast.Expr { return ast.NewIdent(fieldName) }
+	if receiverTypeName(funcDecl) == typeName {
+		recvName := f.ensureReceiverName(funcDecl)
+		value = func() ast.Expr { return fieldSelector(recvName, fieldName, token.NoPos) }
+	}
+
+	changed := false
+	ast.Inspect(funcDecl.Body, func(n ast.Node) bool {
+		lit, ok := n.(*ast.CompositeLit)
+		if !ok || typeIdentName(lit.Type) != typeName {
+			return true
+		}
+
+		keyed := len(lit.Elts) == 0
+		for _, elt := range lit.Elts {
+			kv, ok := elt.(*ast.KeyValueExpr)
+			if !ok {
+				continue
+			}
+			keyed = true
+			if key, ok := kv.Key.(*ast.Ident); ok && key.Name == fieldName {
+				return true
+			}
+		}
+
+		if keyed {
+			lit.Elts = append(lit.Elts, &ast.KeyValueExpr{
+				Key:   ast.NewIdent(fieldName),
+				Value: value(),
+			})
+		} else {
+			// The field is appended last, so positional literals take it last
+			lit.Elts = append(lit.Elts, value())
+		}
+		f.recorder.Add(lit.Pos(), report.KindCallSite,
+			fmt.Sprintf("set %s in %s literal in %s", fieldName, typeName, funcDecl.Name.Name))
+		changed = true
+		return true
+	})
+	return changed
+}
+
+// ReportRootCalls undoes the dependency argument passed by call sites in
+// main, init and tests that have no such variable in scope, and reports
+// them as changes needing manual work. The argument was added by the
+// propagation above the constructors.
+func (f *FieldInjector) ReportRootCalls(file *ast.File, fieldName, fieldType string) {
+	declared := file.Scope != nil && file.Scope.Lookup(fieldName) != nil
+	for _, decl := range file.Decls {
+		funcDecl, ok := decl.(*ast.FuncDecl)
+		if !ok || funcDecl.Body == nil || !isProgramRoot(funcDecl) {
+			continue
+		}
+		if declared || declaresVar(funcDecl, fieldName) {
+			continue
+		}
+		ast.Inspect(funcDecl.Body, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok || len(call.Args) == 0 {
+				return true
+			}
+			// Arguments added by the propagation have no position
+			last, ok := call.Args[len(call.Args)-1].(*ast.Ident)
+			if !ok || last.Name != fieldName || last.Pos().IsValid() {
+				return true
+			}
+			call.Args = call.Args[:len(call.Args)-1]
+			f.recorder.Add(call.Pos(), report.KindManual,
+				fmt.Sprintf("%s has no %s to pass to %s, provide a %s here", funcDecl.Name.Name, fieldName, exprString(call.Fun), fieldType))
+			return true
+		})
+	}
+}
+
+// declaresVar reports whether name is a parameter or local variable of
+// funcDecl
+func declaresVar(funcDecl *ast.FuncDecl, name string) bool {
+	if funcDecl.Type.Params != nil {
+		for _, field := range funcDecl.Type.Params.List {
+			for _, ident := range field.Names {
+				if ident.Name == name {
+					return true
+				}
+			}
+		}
+	}
+	declared := false
+	ast.Inspect(funcDecl.Body, func(n ast.Node) bool {
+		if ident, ok := n.(*ast.Ident); ok && ident.Name == name && ident.Obj != nil && ident.Obj.Kind == ast.Var {
+			declared = true
+		}
+		return !declared
+	})
+	return declared
+}
+
+func (f *FieldInjector) ShouldModifyFunction(funcName string) bool {
+	_, shouldModify := f.functionsToModify[funcName]
+	return shouldModify
+}
+
+// Changes returns every declaration and use touched so far
+func (f *FieldInjector) Changes() []report.Change {
+	return f.recorder.Changes()
+}
+
+// findMethod looks up a method by name; "T.Method", "(*T).Method" and
+// "recv.Method" pick the one declared on T or with receiver recv. A bare
+// name declared on several types is ambiguous.
+func findMethod(file *ast.File, name string) (*ast.FuncDecl, error) {
+	qualifier := ""
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		qualifier = strings.TrimSuffix(strings.TrimPrefix(name[:i], "(*"), ")")
+		name = name[i+1:]
+	}
+
+	var found []*ast.FuncDecl
+	for _, decl := range file.Decls {
+		funcDecl, ok := decl.(*ast.FuncDecl)
+		if !ok || funcDecl.Recv == nil || funcDecl.Name.Name != name {
+			continue
+		}
+		if qualifier != "" && receiverTypeName(funcDecl) != qualifier && receiverName(funcDecl) != qualifier {
+			continue
+		}
+		found = append(found, funcDecl)
+	}
+
+	switch {
+	case len(found) == 0 && qualifier != "":
+		return nil, fmt.Errorf("method %s of %s not found", name, qualifier)
+	case len(found) == 0:
+		return nil, fmt.Errorf("method %s not found", name)
+	case len(found) > 1:
+		var types []string
+		for _, funcDecl := range found {
+			types = append(types, receiverTypeName(funcDecl))
+		}
+		return nil, fmt.Errorf("method %s is declared on %s, name it as T.%s", name, strings.Join(types, ", "), name)
+	}
+	return found[0], nil
+}
+
+// receiverName returns the name of the receiver variable of a method
+func receiverName(funcDecl *ast.FuncDecl) string {
+	if funcDecl.Recv == nil || len(funcDecl.Recv.List) == 0 || len(funcDecl.Recv.List[0].Names) == 0 {
+		return ""
+	}
+	return funcDecl.Recv.List[0].Names[0].Name
+}
+
+// receiverTypeName returns T for methods declared on T or *T
+func receiverTypeName(funcDecl *ast.FuncDecl) string {
+	if funcDecl.Recv == nil || len(funcDecl.Recv.List) == 0 {
+		return ""
+	}
+	expr := funcDecl.Recv.List[0].Type
+	if star, ok := expr.(*ast.StarExpr); ok {
+		expr = star.X
+	}
+	return typeIdentName(expr)
+}
+
+// typeIdentName returns the name of a local type, including generic instances
+func typeIdentName(expr ast.Expr) string {
+	switch x := expr.(type) {
+	case *ast.Ident:
+		return x.Name
+	case *ast.IndexExpr:
+		return typeIdentName(x.X)
+	case *ast.IndexListExpr:
+		return typeIdentName(x.X)
+	}
+	return ""
+}
+
+func findStructType(file *ast.File, typeName string) *ast.StructType {
+	for _, decl := range file.Decls {
+		genDecl, ok := decl.(*ast.GenDecl)
+		if !ok || genDecl.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range genDecl.Specs {
+			typeSpec := spec.(*ast.TypeSpec)
+			if typeSpec.Name.Name != typeName {
+				continue
+			}
+			if structType, ok := typeSpec.Type.(*ast.StructType); ok {
+				return structType
+			}
+		}
+	}
+	return nil
+}
@@ -2,6 +2,8 @@ package modifier
 
 import (
 	"go/ast"
+
+	"github.com/back2nix/go-arg-propagation/pkg/report"
 )
 
 // IASTModifier представляет единый интерфейс для модификации AST
@@ -15,4 +17,7 @@ type IASTModifier interface {
 	// UpdateFunctionDeclarations обновляет объявления функций в файле AST
 	// Этот метод оставлен для обратной совместимости
 	UpdateFunctionDeclarations(file *ast.File, paramName, paramType string) error
+
+	// Changes возвращает список изменённых объявлений и мест вызова
+	Changes() []report.Change
 }
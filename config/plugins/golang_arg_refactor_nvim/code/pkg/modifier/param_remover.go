@@ -0,0 +1,151 @@
+package modifier
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"sort"
+
+	"github.com/back2nix/go-arg-propagation/pkg/analyzer"
+	"github.com/back2nix/go-arg-propagation/pkg/logger"
+	"github.com/back2nix/go-arg-propagation/pkg/report"
+)
+
+// ParamRemover deletes parameters from function declarations together with
+// the matching arguments at every call site
+type ParamRemover struct {
+	// removed maps package and function name to the parameter indexes to drop
+	removed map[string]map[string][]int
+	// exported holds the package and name of exported functions
+	exported map[[2]string]bool
+	fset     *token.FileSet
+	recorder *report.Recorder
+}
+
+func NewParamRemover(params []analyzer.UnusedParam, fset *token.FileSet) *ParamRemover {
+	removed := make(map[string]map[string][]int)
+	exported := make(map[[2]string]bool)
+	for _, param := range params {
+		if param.Exported {
+			exported[[2]string{param.Package, param.Func}] = true
+		}
+		if removed[param.Package] == nil {
+			removed[param.Package] = make(map[string][]int)
+		}
+		removed[param.Package][param.Func] = append(removed[param.Package][param.Func], param.Index)
+	}
+	// Dropping from the end keeps the remaining indexes valid
+	for _, funcs := range removed {
+		for _, indexes := range funcs {
+			sort.Sort(sort.Reverse(sort.IntSlice(indexes)))
+		}
+	}
+
+	logger.Log.DebugPrintf("[ParamRemover] Parameters to remove: %v", removed)
+
+	return &ParamRemover{
+		removed:  removed,
+		exported: exported,
+		fset:     fset,
+		recorder: report.NewRecorder(fset),
+	}
+}
+
+// Remove updates the declarations and calls in source. It reports whether
+// the file was changed.
+func (r *ParamRemover) Remove(source analyzer.SourceFile) bool {
+	changed := false
+	ast.Inspect(source.File, func(n ast.Node) bool {
+		switch x := n.(type) {
+		case *ast.FuncDecl:
+			if x.Recv == nil {
+				if indexes, ok := r.removed[source.Package][x.Name.Name]; ok {
+					r.removeParams(x, indexes)
+					if r.exported[[2]string{source.Package, x.Name.Name}] {
+						r.recorder.Add(x.Name.Pos(), report.KindManual,
+							fmt.Sprintf("%s is exported, callers outside of the module have to be updated", x.Name.Name))
+					}
+					changed = true
+				}
+			}
+		case *ast.CallExpr:
+			pkg, name, ok := calleeOf(source, x.Fun)
+			if !ok {
+				return true
+			}
+			if indexes, ok := r.removed[pkg][name]; ok {
+				r.removeArgs(x, name, indexes)
+				changed = true
+			}
+		}
+		return true
+	})
+	return changed
+}
+
+func (r *ParamRemover) removeParams(funcDecl *ast.FuncDecl, indexes []int) {
+	for _, index := range indexes {
+		position := 0
+		for i, field := range funcDecl.Type.Params.List {
+			count := len(field.Names)
+			if count == 0 {
+				count = 1
+			}
+			if index >= position+count {
+				position += count
+				continue
+			}
+			if len(field.Names) > 1 {
+				name := field.Names[index-position]
+				field.Names = append(field.Names[:index-position], field.Names[index-position+1:]...)
+				r.recorder.Add(funcDecl.Name.Pos(), report.KindDeclaration,
+					fmt.Sprintf("removed parameter %s from %s", name.Name, funcDecl.Name.Name))
+			} else {
+				list := funcDecl.Type.Params.List
+				funcDecl.Type.Params.List = append(list[:i:i], list[i+1:]...)
+				r.recorder.Add(funcDecl.Name.Pos(), report.KindDeclaration,
+					fmt.Sprintf("removed parameter %s from %s", field.Names[0].Name, funcDecl.Name.Name))
+			}
+			break
+		}
+	}
+}
+
+func (r *ParamRemover) removeArgs(callExpr *ast.CallExpr, name string, indexes []int) {
+	for _, index := range indexes {
+		if index >= len(callExpr.Args) {
+			// f(g()) with a multi-value g can't be split automatically
+			r.recorder.Add(callExpr.Pos(), report.KindCallSite,
+				fmt.Sprintf("argument %d of %s has to be removed manually", index+1, name))
+			continue
+		}
+		callExpr.Args = append(callExpr.Args[:index:index], callExpr.Args[index+1:]...)
+		r.recorder.Add(callExpr.Pos(), report.KindCallSite,
+			fmt.Sprintf("removed argument %d from call to %s", index+1, name))
+	}
+}
+
+// Changes returns every declaration and call site touched so far
+func (r *ParamRemover) Changes() []report.Change {
+	return r.recorder.Changes()
+}
+
+// calleeOf resolves foo and pkg.Foo calls to a package key and a function name
+func calleeOf(source analyzer.SourceFile, expr ast.Expr) (string, string, bool) {
+	switch x := expr.(type) {
+	case *ast.Ident:
+		if x.Obj != nil && x.Obj.Kind != ast.Fun {
+			return "", "", false
+		}
+		return source.Package, x.Name, true
+	case *ast.SelectorExpr:
+		ident, ok := x.X.(*ast.Ident)
+		if !ok || ident.Obj != nil {
+			return "", "", false
+		}
+		if pkg, ok := source.Imports[ident.Name]; ok {
+			return pkg, x.Sel.Name, true
+		}
+	}
+	return "", "", false
+}
@@ -1,8 +1,10 @@
 package parser
 
 import (
+	"errors"
 	"go/ast"
 	"go/parser"
+	"go/scanner"
 	"go/token"
 )
 
@@ -23,6 +25,21 @@ func (p *Parser) Parse(src []byte) (*ast.File, error) {
 	return parser.ParseFile(p.fset, "", src, parser.ParseComments)
 }
 
+// ParseTolerant parses the source code like Parse, but keeps the partial AST
+// go/parser builds around syntax errors and returns the errors alongside it.
+// An error is returned only when no usable AST could be built.
+func (p *Parser) ParseTolerant(src []byte) (*ast.File, scanner.ErrorList, error) {
+	file, err := parser.ParseFile(p.fset, "", src, parser.ParseComments|parser.AllErrors)
+	if err == nil {
+		return file, nil, nil
+	}
+	var syntaxErrors scanner.ErrorList
+	if !errors.As(err, &syntaxErrors) || file == nil || file.Name == nil || file.Name.Name == "_" {
+		return nil, nil, err
+	}
+	return file, syntaxErrors, nil
+}
+
 // GetFuncDecl finds a specific function declaration in the AST
 func (p *Parser) GetFuncDecl(file *ast.File, funcName string) *ast.FuncDecl {
 	for _, decl := range file.Decls {
@@ -0,0 +1,86 @@
+package report
+
+import (
+	"go/token"
+	"sort"
+)
+
+// ChangeKind describes what kind of code location was touched by a refactoring
+type ChangeKind string
+
+const (
+	KindDeclaration ChangeKind = "declaration"
+	KindCallSite    ChangeKind = "call"
+	KindImport      ChangeKind = "import"
+	// KindTolerated marks a syntax error outside of the changed code that
+	// was left as it is
+	KindTolerated ChangeKind = "tolerated"
+	// KindManual marks a change the refactoring could not make and left to
+	// the user
+	KindManual ChangeKind = "manual"
+)
+
+// Change is a single location touched by a refactoring. The JSON shape maps
+// directly onto a quickfix item on the Lua side.
+type Change struct {
+	File   string     `json:"file"`
+	Line   int        `json:"line"`
+	Column int        `json:"column"`
+	Kind   ChangeKind `json:"kind"`
+	Text   string     `json:"text"`
+}
+
+// Recorder collects changes produced while modifying an AST
+type Recorder struct {
+	fset    *token.FileSet
+	changes []Change
+}
+
+// NewRecorder creates a Recorder resolving positions through fset
+func NewRecorder(fset *token.FileSet) *Recorder {
+	return &Recorder{fset: fset}
+}
+
+// Add records a change at pos
+func (r *Recorder) Add(pos token.Pos, kind ChangeKind, text string) {
+	position := r.fset.Position(pos)
+	r.changes = append(r.changes, Change{
+		File:   position.Filename,
+		Line:   position.Line,
+		Column: position.Column,
+		Kind:   kind,
+		Text:   text,
+	})
+}
+
+// Changes returns the recorded changes ordered by position
+func (r *Recorder) Changes() []Change {
+	return Sorted(r.changes)
+}
+
+// WithFile returns a copy of changes with an empty File replaced by filePath
+func WithFile(changes []Change, filePath string) []Change {
+	result := make([]Change, len(changes))
+	for i, c := range changes {
+		if c.File == "" {
+			c.File = filePath
+		}
+		result[i] = c
+	}
+	return result
+}
+
+// Sorted returns a copy of changes ordered by file, line and column
+func Sorted(changes []Change) []Change {
+	result := append([]Change(nil), changes...)
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].File != result[j].File {
+			return result[i].File < result[j].File
+		}
+		if result[i].Line != result[j].Line {
+			return result[i].Line < result[j].Line
+		}
+		return result[i].Column < result[j].Column
+	})
+	return result
+}
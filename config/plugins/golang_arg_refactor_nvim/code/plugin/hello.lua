@@ -44,38 +44,192 @@ local function ensure_job()
 	return jobid
 end
 
-vim.api.nvim_create_user_command("AddArgument", function()
-	vim.ui.input({ prompt = "Enter argument name and type (separated by space): " }, function(input)
+-- Loads the change report of a refactoring into the quickfix list
+local function show_changes(title, changes)
+	if not changes or #changes == 0 then
+		return
+	end
+	local items = {}
+	for _, change in ipairs(changes) do
+		table.insert(items, {
+			filename = change.file,
+			lnum = change.line,
+			col = change.column,
+			text = "[" .. change.kind .. "] " .. change.text,
+		})
+	end
+	vim.fn.setqflist({}, " ", { title = title, items = items })
+	local ok, trouble = pcall(require, "trouble")
+	if ok then
+		trouble.open("quickfix")
+	else
+		vim.cmd("copen")
+	end
+end
+
+-- Calls an RPC method returning an encoded Result and reports the outcome
+local function request(title, method, args)
+	local json_result, err = vim.fn.rpcrequest(ensure_job(), method, args)
+	if err then
+		log(title .. " failed: " .. tostring(err))
+		vim.notify(title .. " failed: " .. tostring(err), vim.log.levels.ERROR)
+		return
+	end
+
+	local success, result = pcall(vim.fn.json_decode, json_result)
+	if not success then
+		log("Error decoding JSON result: " .. tostring(result))
+		vim.notify("Error decoding result", vim.log.levels.ERROR)
+		return
+	end
+
+	if result.success then
+		log(title .. " succeeded: " .. result.message)
+		vim.notify(result.message, vim.log.levels.INFO)
+		show_changes(title, result.changes)
+	else
+		log(title .. " failed: " .. (result.error or "Unknown error"))
+		vim.notify(result.error or "Unknown error", vim.log.levels.ERROR)
+	end
+	return result
+end
+
+-- Asks for "<name> <type>" and passes both to the given RPC method
+local function prompt_name_and_type(title, method, prompt)
+	vim.ui.input({ prompt = prompt }, function(input)
 		if not input or input == "" then
 			print("Input must be non-empty")
 			return
 		end
-		local arg_name, arg_type = input:match("(%S+)%s+(%S+)")
-		if not arg_name or not arg_type then
-			print("Invalid input format. Please provide both argument name and type.")
+		local name, type_ = input:match("(%S+)%s+(%S+)")
+		if not name or not type_ then
+			print("Invalid input format. Please provide both name and type.")
 			return
 		end
 
-		local json_result, err = vim.fn.rpcrequest(ensure_job(), "addArgument", { arg_name, arg_type })
-		if err then
-			log("Error adding argument: " .. tostring(err))
-			vim.notify("Error adding argument: " .. tostring(err), vim.log.levels.ERROR)
-			return
+		request(title, method, { name, type_ })
+	end)
+end
+
+vim.api.nvim_create_user_command("AddArgument", function()
+	prompt_name_and_type("AddArgument", "addArgument", "Enter argument name and type (separated by space): ")
+end, {})
+
+-- Like AddArgument, but the dependency becomes a field on the method receiver
+vim.api.nvim_create_user_command("InjectField", function()
+	prompt_name_and_type("InjectField", "injectField", "Enter field name and type (separated by space): ")
+end, {})
+
+vim.api.nvim_create_user_command("AddContext", function()
+	request("AddContext", "addContext", {})
+end, {})
+
+-- Usage: :AddErrorReturn [depth] [stop_func ...]
+vim.api.nvim_create_user_command("AddErrorReturn", function(opts)
+	request("AddErrorReturn", "addErrorReturn", opts.fargs)
+end, { nargs = "*" })
+
+local unused_ns = vim.api.nvim_create_namespace("golang_arg_refactor_unused")
+local unused_chains = {}
+
+local function chain_label(chain)
+	local names = {}
+	for _, param in ipairs(chain.params) do
+		local name = param.func .. "." .. param.name
+		if param.exported then
+			name = name .. " (exported)"
 		end
+		table.insert(names, name)
+	end
+	return table.concat(names, " -> ")
+end
 
-		local success, result = pcall(vim.fn.json_decode, json_result)
-		if not success then
-			log("Error decoding JSON result: " .. tostring(result))
-			vim.notify("Error decoding result", vim.log.levels.ERROR)
-			return
+-- Shows unused parameters of the module as diagnostics, one chain at a time
+vim.api.nvim_create_user_command("UnusedParams", function()
+	local result = request("UnusedParams", "unusedParams", {})
+	if not result or not result.success then
+		return
+	end
+
+	vim.diagnostic.reset(unused_ns)
+	unused_chains = result.chains or {}
+	local by_buffer = {}
+	for i, chain in ipairs(unused_chains) do
+		for _, param in ipairs(chain.params) do
+			local bufnr = vim.fn.bufadd(param.file)
+			by_buffer[bufnr] = by_buffer[bufnr] or {}
+			table.insert(by_buffer[bufnr], {
+				lnum = param.line - 1,
+				col = param.column - 1,
+				end_col = param.column - 1 + #param.name,
+				severity = vim.diagnostic.severity.HINT,
+				source = "UnusedParams",
+				message = string.format("parameter %s is never used (chain %d: %s)", param.name, i, chain_label(chain)),
+			})
+		end
+	end
+	for bufnr, diagnostics in pairs(by_buffer) do
+		vim.diagnostic.set(unused_ns, bufnr, diagnostics)
+	end
+end, {})
+
+local function remove_chain(chain)
+	request("RemoveUnusedParams", "removeUnusedParams", { vim.fn.json_encode(chain) })
+	vim.diagnostic.reset(unused_ns)
+	unused_chains = {}
+end
+
+-- Removes the chain of the parameter under the cursor, or asks which one
+vim.api.nvim_create_user_command("RemoveUnusedParams", function()
+	if #unused_chains == 0 then
+		vim.notify("Run :UnusedParams first", vim.log.levels.WARN)
+		return
+	end
+
+	local file = vim.api.nvim_buf_get_name(0)
+	local line = vim.api.nvim_win_get_cursor(0)[1]
+	for _, chain in ipairs(unused_chains) do
+		for _, param in ipairs(chain.params) do
+			if param.file == file and param.line == line then
+				remove_chain(chain)
+				return
+			end
 		end
+	end
 
-		if result.success then
-			log("Argument added successfully: " .. result.message)
-			vim.notify(result.message, vim.log.levels.INFO)
-		else
-			log("Error adding argument: " .. (result.error or "Unknown error"))
-			vim.notify(result.error or "Unknown error", vim.log.levels.ERROR)
+	vim.ui.select(unused_chains, { prompt = "Remove unused parameters", format_item = chain_label }, function(chain)
+		if chain then
+			remove_chain(chain)
 		end
 	end)
 end, {})
+
+-- Usage: :CallGraph [scope=package] [format=dot] [root=<func>] [depth=N] [boundary=module]
+-- root=% stands for the word under the cursor
+vim.api.nvim_create_user_command("CallGraph", function(opts)
+	local args = {}
+	for _, arg in ipairs(opts.fargs) do
+		if arg == "root=%" then
+			arg = "root=" .. vim.fn.expand("<cword>")
+		end
+		table.insert(args, arg)
+	end
+	request("CallGraph", "callGraph", args)
+end, {
+	nargs = "*",
+	complete = function()
+		return {
+			"scope=file",
+			"scope=package",
+			"scope=module",
+			"format=dot",
+			"format=json",
+			"format=mermaid",
+			"root=%",
+			"depth=",
+			"boundary=none",
+			"boundary=module",
+			"boundary=package",
+		}
+	end,
+})
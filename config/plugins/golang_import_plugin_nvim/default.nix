@@ -14,7 +14,7 @@ buildGoModule rec {
   vendorHash = "sha256-/Bl4G5STa5lnNntZnMmt+BfES+N7ZYAwC9tzpuqUKcc=";
 
   buildPhase = ''
-    go build -mod=vendor -o ${pname} main.go
+    go build -mod=vendor -o ${pname} .
   '';
 
   installPhase = ''
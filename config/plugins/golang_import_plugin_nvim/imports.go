@@ -0,0 +1,253 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/neovim/go-client/nvim"
+)
+
+// textEdit replaces the bytes between two offsets of the buffer content
+type textEdit struct {
+	start, end int
+	text       string
+}
+
+// Import groups in the order goimports writes them
+const (
+	groupStdlib = iota
+	groupThirdParty
+	groupLocal
+)
+
+// importGroup classifies an import path. local holds the prefixes of
+// local imports, like goimports -local.
+func importGroup(path string, local []string) int {
+	for _, prefix := range local {
+		if prefix != "" && (path == prefix || strings.HasPrefix(path, strings.TrimSuffix(prefix, "/")+"/")) {
+			return groupLocal
+		}
+	}
+	if isStdlib(path) {
+		return groupStdlib
+	}
+	return groupThirdParty
+}
+
+// localPrefixes splits a comma-separated -local setting, the module path of
+// root is the default
+func localPrefixes(setting, root string) []string {
+	if setting == "" {
+		if module := packagePath(root, root); module != "" {
+			return []string{module}
+		}
+		return nil
+	}
+	return strings.Split(setting, ",")
+}
+
+var majorVersion = regexp.MustCompile(`^v[0-9]+$`)
+
+// assumedName guesses the package name of an import path the way
+// goimports does: "github.com/go-yaml/yaml.v3" is yaml and
+// "example.com/mod/v2" is mod
+func assumedName(path string) string {
+	elements := strings.Split(path, "/")
+	name := elements[len(elements)-1]
+	if majorVersion.MatchString(name) && len(elements) > 1 {
+		name = elements[len(elements)-2]
+	}
+	name = strings.TrimPrefix(name, "go-")
+	if i := strings.IndexAny(name, ".-"); i >= 0 {
+		name = name[:i]
+	}
+	return name
+}
+
+// packageName returns the name of the package at path, read from the
+// package when it is known and guessed from the path otherwise
+func packageName(root, path string) string {
+	for _, pkg := range projectPackages(root) {
+		if pkg.Path == path {
+			return pkg.Name
+		}
+	}
+	if dir, found := moduleDir(root, path); found {
+		if pkg := scanPackage(dir, path); pkg != nil {
+			return pkg.Name
+		}
+	}
+	return assumedName(path)
+}
+
+// addImportEdit returns the edit importing path as name into content, ""
+// name imports it under its package name. It follows
+// astutil.AddNamedImport: an existing import is left alone, the import goes
+// into the first import declaration, sorted into the group of its kind, and
+// a new group is started when the declaration has none of that kind.
+func addImportEdit(content []byte, name, path string, local []string) (textEdit, bool, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "", content, parser.ImportsOnly|parser.ParseComments)
+	if f == nil || f.Name == nil {
+		return textEdit{}, false, fmt.Errorf("failed to parse the package clause: %v", err)
+	}
+	for _, spec := range f.Imports {
+		existing, _ := strconv.Unquote(spec.Path.Value)
+		if existing == path && (spec.Name == nil && name == "" || spec.Name != nil && spec.Name.Name == name) {
+			return textEdit{}, false, nil
+		}
+	}
+
+	newSpec := strconv.Quote(path)
+	if name != "" {
+		newSpec = name + " " + newSpec
+	}
+	offset := func(pos token.Pos) int { return fset.Position(pos).Offset }
+
+	var decl *ast.GenDecl
+	for _, d := range f.Decls {
+		if gen, ok := d.(*ast.GenDecl); ok && gen.Tok == token.IMPORT {
+			decl = gen
+			break
+		}
+	}
+	if decl == nil {
+		// A new declaration after the package clause and its comment
+		end := lineEnd(content, offset(f.Name.End()))
+		return textEdit{end, end, "\n\nimport " + newSpec}, true, nil
+	}
+
+	group := importGroup(path, local)
+	if !decl.Lparen.IsValid() {
+		// A single import becomes a factored block
+		spec := decl.Specs[0].(*ast.ImportSpec)
+		existing, _ := strconv.Unquote(spec.Path.Value)
+		old := string(content[offset(spec.Pos()):offset(spec.End())])
+		specs := []string{old, newSpec}
+		separator := "\n\t"
+		switch existingGroup := importGroup(existing, local); {
+		case existingGroup > group:
+			specs[0], specs[1] = newSpec, old
+			separator = "\n\n\t"
+		case existingGroup < group:
+			separator = "\n\n\t"
+		case existing > path:
+			specs[0], specs[1] = newSpec, old
+		}
+		return textEdit{offset(decl.Pos()), offset(decl.End()), "import (\n\t" + specs[0] + separator + specs[1] + "\n)"}, true, nil
+	}
+
+	if len(decl.Specs) == 0 {
+		return textEdit{offset(decl.Lparen), offset(decl.Rparen) + 1, "(\n\t" + newSpec + "\n)"}, true, nil
+	}
+
+	// Runs of specs without blank lines between them are the groups
+	type specInfo struct {
+		spec  *ast.ImportSpec
+		path  string
+		group int
+		run   int
+	}
+	var infos []specInfo
+	run := 0
+	for i, s := range decl.Specs {
+		spec := s.(*ast.ImportSpec)
+		if i > 0 && fset.Position(specStart(spec)).Line > fset.Position(decl.Specs[i-1].End()).Line+1 {
+			run++
+		}
+		existing, _ := strconv.Unquote(spec.Path.Value)
+		infos = append(infos, specInfo{spec, existing, importGroup(existing, local), run})
+	}
+	indent := lineIndent(content, offset(specStart(infos[0].spec)))
+
+	// Sort into the first run of the same group
+	sameRun := -1
+	for _, info := range infos {
+		if info.group == group {
+			sameRun = info.run
+			break
+		}
+	}
+	if sameRun >= 0 {
+		var runSpecs []specInfo
+		for _, info := range infos {
+			if info.run == sameRun {
+				runSpecs = append(runSpecs, info)
+			}
+		}
+		i := sort.Search(len(runSpecs), func(i int) bool { return runSpecs[i].path > path })
+		if i < len(runSpecs) {
+			at := lineStart(content, offset(specStart(runSpecs[i].spec)))
+			return textEdit{at, at, indent + newSpec + "\n"}, true, nil
+		}
+		at := lineEnd(content, offset(runSpecs[len(runSpecs)-1].spec.End()))
+		return textEdit{at, at, "\n" + indent + newSpec}, true, nil
+	}
+
+	// A new group before the first group of a later kind, or at the end
+	for _, info := range infos {
+		if info.group > group {
+			at := lineStart(content, offset(specStart(info.spec)))
+			return textEdit{at, at, indent + newSpec + "\n\n"}, true, nil
+		}
+	}
+	at := lineEnd(content, offset(infos[len(infos)-1].spec.End()))
+	return textEdit{at, at, "\n\n" + indent + newSpec}, true, nil
+}
+
+// specStart returns where an import spec starts, including its doc comment
+func specStart(spec *ast.ImportSpec) token.Pos {
+	if spec.Doc != nil {
+		return spec.Doc.Pos()
+	}
+	return spec.Pos()
+}
+
+func lineStart(content []byte, offset int) int {
+	for offset > 0 && content[offset-1] != '\n' {
+		offset--
+	}
+	return offset
+}
+
+// lineEnd returns the offset of the newline ending the line of offset,
+// trailing comments stay on their line
+func lineEnd(content []byte, offset int) int {
+	for offset < len(content) && content[offset] != '\n' {
+		offset++
+	}
+	return offset
+}
+
+// lineIndent returns the white space the line of offset starts with
+func lineIndent(content []byte, offset int) string {
+	start := lineStart(content, offset)
+	end := start
+	for end < len(content) && (content[end] == ' ' || content[end] == '\t') {
+		end++
+	}
+	return string(content[start:end])
+}
+
+// applyEdit sends one edit of content to buffer with nvim_buf_set_text,
+// the rest of the buffer is left alone
+func applyEdit(v *nvim.Nvim, buffer nvim.Buffer, content []byte, edit textEdit) error {
+	position := func(offset int) (int, int) {
+		before := content[:offset]
+		row := strings.Count(string(before), "\n")
+		return row, offset - (strings.LastIndex(string(before), "\n") + 1)
+	}
+	startRow, startCol := position(edit.start)
+	endRow, endCol := position(edit.end)
+	var replacement [][]byte
+	for _, line := range strings.Split(edit.text, "\n") {
+		replacement = append(replacement, []byte(line))
+	}
+	return v.SetBufferText(buffer, startRow, startCol, endRow, endCol, replacement)
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// indexVersion changes with the cache format, caches of other versions are
+// rebuilt
+const indexVersion = 2
+
+// refreshInterval is how old an index may get before a lookup starts a
+// refresh in the background
+const refreshInterval = 5 * time.Second
+
+// indexedFile is what the index keeps of one .go file, the modification
+// time and size tell whether it has to be parsed again
+type indexedFile struct {
+	ModTime int64           `json:"mtime"`
+	Size    int64           `json:"size"`
+	Imports []indexedImport `json:"imports,omitempty"`
+}
+
+type indexedImport struct {
+	Alias string `json:"alias"`
+	Path  string `json:"path"`
+}
+
+// indexCache is the content of the cache file of a project
+type indexCache struct {
+	Version int                     `json:"version"`
+	Root    string                  `json:"root"`
+	Files   map[string]*indexedFile `json:"files"`
+}
+
+// Candidate is an import path known for an alias
+type Candidate struct {
+	Path string `msgpack:"path"`
+	// Count is the number of files importing the path under the alias, Local
+	// the number of those in the directory of the current file
+	Count int `msgpack:"count"`
+	Local int `msgpack:"local"`
+	// Stdlib is set for standard library packages
+	Stdlib bool `msgpack:"stdlib"`
+	// Remembered is set for the path chosen for the alias before
+	Remembered bool `msgpack:"remembered"`
+	// Exports is set when the package is known to export the selected name
+	Exports bool `msgpack:"exports"`
+}
+
+// aliasIndex maps the aliases used in a project to their import paths. It
+// lives as long as the plugin process, is persisted under the user cache
+// dir and refreshed from file modification times.
+type aliasIndex struct {
+	root      string
+	cachePath string
+
+	mu    sync.Mutex
+	files map[string]*indexedFile
+	// aliases counts the files importing a path under an alias
+	aliases map[string]map[string]int
+	// choices are the paths the user picked for ambiguous aliases
+	choices    map[string]string
+	refreshing bool
+	refreshed  time.Time
+}
+
+var (
+	indexesMu sync.Mutex
+	indexes   = make(map[string]*aliasIndex)
+)
+
+// indexFor returns the index of a project. A new index is loaded from the
+// cache and refreshed in the background, or built when there is no cache.
+func indexFor(root string) (*aliasIndex, error) {
+	indexesMu.Lock()
+	defer indexesMu.Unlock()
+
+	if index, found := indexes[root]; found {
+		index.refreshIfStale()
+		return index, nil
+	}
+
+	index := &aliasIndex{root: root, cachePath: cachePath(root)}
+	if index.load() {
+		index.refreshIfStale()
+	} else if err := index.refresh(); err != nil {
+		return nil, err
+	}
+	indexes[root] = index
+	return index, nil
+}
+
+// cachePath returns the cache file of a project root
+func cachePath(root string) string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	sum := sha1.Sum([]byte(root))
+	return filepath.Join(dir, "golang_import_plugin_nvim", hex.EncodeToString(sum[:])+".json")
+}
+
+// load reads the cache file, it reports false when there is no usable one
+func (x *aliasIndex) load() bool {
+	content, err := os.ReadFile(x.cachePath)
+	if err != nil {
+		return false
+	}
+	var cache indexCache
+	if err := json.Unmarshal(content, &cache); err != nil || cache.Version != indexVersion || cache.Root != x.root {
+		return false
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.setFiles(cache.Files)
+	return true
+}
+
+// save writes the cache file through a temporary file, so a crash never
+// leaves a truncated cache
+func (x *aliasIndex) save() error {
+	x.mu.Lock()
+	content, err := json.Marshal(indexCache{Version: indexVersion, Root: x.root, Files: x.files})
+	x.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(x.cachePath), 0o755); err != nil {
+		return err
+	}
+	tmp := x.cachePath + ".tmp"
+	if err := os.WriteFile(tmp, content, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, x.cachePath)
+}
+
+// refreshIfStale starts a background refresh when the index is older than
+// refreshInterval and no refresh is running
+func (x *aliasIndex) refreshIfStale() {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.refreshing || time.Since(x.refreshed) < refreshInterval {
+		return
+	}
+	x.refreshing = true
+	go func() {
+		if err := x.refresh(); err != nil {
+			log.Printf("failed to refresh the index of %s: %v", x.root, err)
+		}
+	}()
+}
+
+// refresh walks the project and parses the files that are new or changed
+// since they were indexed. Removed files are dropped.
+func (x *aliasIndex) refresh() error {
+	x.mu.Lock()
+	old := x.files
+	x.mu.Unlock()
+
+	files := make(map[string]*indexedFile, len(old))
+	changed := false
+	names := x.packageNames()
+	err := filepath.WalkDir(x.root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			// Unreadable directories are skipped, not fatal
+			if entry != nil && entry.IsDir() && path != x.root {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if entry.IsDir() {
+			if path != x.root && skipDir(entry.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(path, ".go") {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+		if known := old[path]; known != nil && known.ModTime == info.ModTime().UnixNano() && known.Size == info.Size() {
+			files[path] = known
+			return nil
+		}
+		files[path] = parseImports(path, info, names)
+		changed = true
+		return nil
+	})
+
+	x.mu.Lock()
+	if err == nil {
+		changed = changed || len(files) != len(old)
+		x.setFiles(files)
+		x.refreshed = time.Now()
+	}
+	x.refreshing = false
+	x.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+	if changed {
+		return x.save()
+	}
+	return nil
+}
+
+// updateFile indexes one file again, e.g. after it was written
+func (x *aliasIndex) updateFile(path string) error {
+	info, err := os.Stat(path)
+	x.mu.Lock()
+	files := make(map[string]*indexedFile, len(x.files))
+	for known, file := range x.files {
+		files[known] = file
+	}
+	if err != nil {
+		delete(files, path)
+	} else {
+		files[path] = parseImports(path, info, x.packageNames())
+	}
+	x.setFiles(files)
+	x.mu.Unlock()
+	return x.save()
+}
+
+// setFiles replaces the indexed files and recounts the aliases, x.mu must
+// be held
+func (x *aliasIndex) setFiles(files map[string]*indexedFile) {
+	if files == nil {
+		files = make(map[string]*indexedFile)
+	}
+	aliases := make(map[string]map[string]int)
+	for _, file := range files {
+		for _, imp := range file.Imports {
+			if aliases[imp.Alias] == nil {
+				aliases[imp.Alias] = make(map[string]int)
+			}
+			aliases[imp.Alias][imp.Path]++
+		}
+	}
+	x.files, x.aliases = files, aliases
+}
+
+// lookup returns the import paths known for alias, the most used first
+func (x *aliasIndex) lookup(alias string) []Candidate {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	var candidates []Candidate
+	for path, count := range x.aliases[alias] {
+		candidates = append(candidates, Candidate{Path: path, Count: count})
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].Count != candidates[j].Count {
+			return candidates[i].Count > candidates[j].Count
+		}
+		return candidates[i].Path < candidates[j].Path
+	})
+	return candidates
+}
+
+// skipDir reports whether a directory holds no code of the project: the go
+// tool ignores vendor-like, testdata, hidden and underscore directories
+func skipDir(name string) bool {
+	return name == "vendor" || name == "testdata" || name == "node_modules" ||
+		strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")
+}
+
+// packageNames returns a lookup of package names by import path that
+// remembers its answers, a project imports the same packages over and over
+func (x *aliasIndex) packageNames() func(string) string {
+	known := make(map[string]string)
+	return func(path string) string {
+		name, found := known[path]
+		if !found {
+			name = packageName(x.root, path)
+			known[path] = name
+		}
+		return name
+	}
+}
+
+// parseImports reads the imports of a file. A file that doesn't parse is
+// indexed without imports, so it is not parsed again until it changes.
+// Unnamed imports are indexed under the name of their package, which is
+// not the last path element for paths like gopkg.in/yaml.v3.
+func parseImports(path string, info fs.FileInfo, names func(string) string) *indexedFile {
+	file := &indexedFile{ModTime: info.ModTime().UnixNano(), Size: info.Size()}
+	node, err := parser.ParseFile(token.NewFileSet(), path, nil, parser.ImportsOnly)
+	if err != nil {
+		return file
+	}
+	for _, spec := range node.Imports {
+		importPath := strings.Trim(spec.Path.Value, "\"")
+		var alias string
+		if spec.Name != nil {
+			alias = spec.Name.Name
+		} else {
+			alias = names(importPath)
+		}
+		// Blank and dot imports bring no name into scope
+		if alias == "_" || alias == "." {
+			continue
+		}
+		file.Imports = append(file.Imports, indexedImport{Alias: alias, Path: importPath})
+	}
+	return file
+}
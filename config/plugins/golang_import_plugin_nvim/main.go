@@ -1,12 +1,12 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
-	"go/parser"
-	"go/token"
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/neovim/go-client/nvim"
@@ -22,157 +22,174 @@ func main() {
 		log.Fatal(err)
 	}
 
+	// The standard library index takes a while the first time, build it
+	// before it is asked for
+	go stdlibPackages()
+
 	v.RegisterHandler("addImport", addImport)
+	v.RegisterHandler("importCandidates", importCandidates)
+	v.RegisterHandler("indexFile", indexFile)
+	v.RegisterHandler("organizeImports", organizeImports)
 
 	if err := v.Serve(); err != nil {
 		log.Fatal(err)
 	}
 }
 
-func findAliases(projectRoot string) (map[string]string, error) {
-	aliases := make(map[string]string)
-
-	err := filepath.Walk(projectRoot, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if !info.IsDir() && strings.HasSuffix(path, ".go") {
-			processFile(path, aliases)
-		}
-		return nil
-	})
-	if err != nil {
-		return nil, fmt.Errorf("error walking the path %v: %v", projectRoot, err)
-	}
-
-	return aliases, nil
+// Change describes a location touched by a handler; it is loaded into the
+// quickfix list on the Lua side.
+type Change struct {
+	File   string `msgpack:"file"`
+	Line   int    `msgpack:"line"`
+	Column int    `msgpack:"column"`
+	Kind   string `msgpack:"kind"`
+	Text   string `msgpack:"text"`
 }
 
-func processFile(filePath string, aliases map[string]string) {
-	fset := token.NewFileSet()
-	node, err := parser.ParseFile(fset, filePath, nil, parser.ImportsOnly)
-	if err != nil {
-		fmt.Printf("Error parsing file %s: %v\n", filePath, err)
-		return
-	}
-
-	for _, i := range node.Imports {
-		importPath := strings.Trim(i.Path.Value, "\"")
-		if i.Name != nil {
-			// Импорт с явным псевдонимом
-			alias := i.Name.Name
-			aliases[alias] = importPath
-		} else {
-			// Импорт без псевдонима
-			parts := strings.Split(importPath, "/")
-			alias := parts[len(parts)-1]
-			aliases[alias] = importPath
-		}
-	}
-}
-
-func addImport(v *nvim.Nvim, args []string) (string, error) {
+// addImport imports the package used as word in the current buffer. It
+// takes the word, the working directory, optionally the import path the
+// user picked, which is remembered for the project, the name selected
+// from the package, which the package has to export, and the comma-separated
+// prefixes of local imports, the module path by default. Without a path the
+// best ranked candidate is imported.
+func addImport(v *nvim.Nvim, args []string) ([]Change, error) {
 	if len(args) < 2 {
-		return "", fmt.Errorf("insufficient arguments: need word and project root")
+		return nil, fmt.Errorf("insufficient arguments: need word and project root")
 	}
 	word := args[0]
 
 	// Получаем текущий буфер
 	buf, err := v.CurrentBuffer()
 	if err != nil {
-		return "", fmt.Errorf("ошибка при получении текущего буфера: %v", err)
+		return nil, fmt.Errorf("ошибка при получении текущего буфера: %v", err)
 	}
 
 	// Получаем имя файла для текущего буфера
 	filename, err := v.BufferName(buf)
 	if err != nil {
-		return "", fmt.Errorf("ошибка при получении имени файла: %v", err)
+		return nil, fmt.Errorf("ошибка при получении имени файла: %v", err)
 	}
 
 	// Определяем корень проекта
 	projectRoot := findProjectRoot(filepath.Dir(filename))
 	if projectRoot == "" {
-		return "", fmt.Errorf("не удалось определить корень проекта для файла %s", filename)
+		return nil, fmt.Errorf("не удалось определить корень проекта для файла %s", filename)
 	}
 
-	// Find all aliases in the project
-	aliases, err := findAliases(projectRoot)
+	// Look the alias up in the index of the project
+	index, err := indexFor(projectRoot)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	symbol := ""
+	if len(args) > 3 {
+		symbol = args[3]
+	}
+	var importPath string
+	if len(args) > 2 && args[2] != "" {
+		// The user picked the path among the candidates
+		importPath = args[2]
+		if exports, known := packageExports(projectRoot, importPath, symbol); symbol != "" && known && !exports {
+			return nil, fmt.Errorf("%s does not export %s", importPath, symbol)
+		}
+		if err := index.remember(word, importPath); err != nil {
+			return nil, err
+		}
+	} else {
+		candidates, err := index.rank(word, filename, symbol)
+		if err != nil {
+			return nil, err
+		}
+		if len(candidates) == 0 {
+			return nil, fmt.Errorf("no import found for alias: %s", word)
+		}
+		importPath = candidates[0].Path
 	}
 
-	// Check if the word matches any alias
-	importPath, found := aliases[word]
-	if !found {
-		return "", fmt.Errorf("no import found for alias: %s", word)
+	localSetting := ""
+	if len(args) > 4 {
+		localSetting = args[4]
+	}
+	name := ""
+	if word != packageName(projectRoot, importPath) {
+		name = word
 	}
 
-	// Get the current buffer
-	b, err := v.CurrentBuffer()
+	lines, err := v.BufferLines(buf, 0, -1, true)
 	if err != nil {
-		return "", err
-	}
+		return nil, err
+	}
+	content := append(bytes.Join(lines, []byte("\n")), '\n')
+	edit, changed, err := addImportEdit(content, name, importPath, localPrefixes(localSetting, projectRoot))
+	if err != nil || !changed {
+		return nil, err
+	}
+	if err := applyEdit(v, buf, content, edit); err != nil {
+		return nil, err
+	}
+
+	spec := strings.TrimSpace(name + " " + strconv.Quote(importPath))
+	updated := string(content[:edit.start]) + edit.text
+	before := updated[:edit.start+strings.Index(edit.text, spec)]
+	return []Change{{
+		File:   filename,
+		Line:   strings.Count(before, "\n") + 1,
+		Column: len(before) - strings.LastIndex(before, "\n"),
+		Kind:   "import",
+		Text:   "added import " + spec,
+	}}, nil
+}
 
-	// Get all lines from the buffer
-	lines, err := v.BufferLines(b, 0, -1, true)
+// importCandidates returns the import paths known for an alias, best first.
+// It takes the alias, the path of the current file and optionally the name
+// selected from the package.
+func importCandidates(v *nvim.Nvim, args []string) ([]Candidate, error) {
+	if len(args) != 2 && len(args) != 3 {
+		return nil, fmt.Errorf("expected the alias, the file path and the selected name, got %d arguments", len(args))
+	}
+	symbol := ""
+	if len(args) == 3 {
+		symbol = args[2]
+	}
+	projectRoot := findProjectRoot(filepath.Dir(args[1]))
+	if projectRoot == "" {
+		return nil, fmt.Errorf("no project root found for %s", args[1])
+	}
+	index, err := indexFor(projectRoot)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	return index.rank(args[0], args[1], symbol)
+}
 
-	importStr := fmt.Sprintf(`"%s"`, importPath)
-	aliasStr := ""
-	if word != filepath.Base(importPath) {
-		aliasStr = word + " "
+// indexFile updates the alias index after a file was written. It takes the
+// file path.
+func indexFile(v *nvim.Nvim, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("expected the file path, got %d arguments", len(args))
 	}
-	fullImportStr := fmt.Sprintf("\t%s%s", aliasStr, importStr)
-
-	importFound := false
-	importBlockStart := -1
-	importBlockEnd := -1
-
-	// Find the import block
-	for i, line := range lines {
-		if strings.HasPrefix(string(line), "import (") {
-			importBlockStart = i
-		} else if importBlockStart != -1 && strings.HasPrefix(string(line), ")") {
-			importBlockEnd = i
-			break
-		}
+	path, err := filepath.Abs(args[0])
+	if err != nil {
+		return err
 	}
-
-	// If import block found, check if our import already exists
-	if importBlockStart != -1 && importBlockEnd != -1 {
-		for i := importBlockStart + 1; i < importBlockEnd; i++ {
-			if strings.TrimSpace(string(lines[i])) == strings.TrimSpace(fullImportStr) {
-				importFound = true
-				break
-			}
-		}
+	projectRoot := findProjectRoot(filepath.Dir(path))
+	if projectRoot == "" || !strings.HasSuffix(path, ".go") {
+		return nil
 	}
-
-	// If import not found, add it
-	if !importFound {
-		if importBlockStart != -1 && importBlockEnd != -1 {
-			// Insert into existing import block
-			newLines := append(lines[:importBlockEnd], append([][]byte{[]byte(fullImportStr)}, lines[importBlockEnd:]...)...)
-			err = v.SetBufferLines(b, 0, -1, true, newLines)
-		} else {
-			// Create new import block at the top of the file
-			newImportBlock := [][]byte{
-				[]byte("import ("),
-				[]byte(fullImportStr),
-				[]byte(")"),
-				[]byte(""),
-			}
-			newLines := append(newImportBlock, lines...)
-			err = v.SetBufferLines(b, 0, -1, true, newLines)
-		}
-		if err != nil {
-			return "", err
+	rel, err := filepath.Rel(projectRoot, filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	for _, dir := range strings.Split(rel, string(filepath.Separator)) {
+		if dir != "." && skipDir(dir) {
+			return nil
 		}
 	}
-
-	return "", nil
+	index, err := indexFor(projectRoot)
+	if err != nil {
+		return err
+	}
+	return index.updateFile(path)
 }
 
 func findProjectRoot(dir string) string {
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/neovim/go-client/nvim"
+)
+
+// organizeImports adds the imports of the package selectors the current
+// buffer can't resolve and removes unused and duplicate imports, like
+// goimports but resolving through the alias index of the project. It takes
+// the comma-separated prefixes of local imports, the module path by
+// default.
+func organizeImports(v *nvim.Nvim, args []string) ([]Change, error) {
+	if len(args) > 1 {
+		return nil, fmt.Errorf("expected the local import prefixes, got %d arguments", len(args))
+	}
+	localSetting := ""
+	if len(args) == 1 {
+		localSetting = args[0]
+	}
+
+	buf, err := v.CurrentBuffer()
+	if err != nil {
+		return nil, err
+	}
+	filename, err := v.BufferName(buf)
+	if err != nil {
+		return nil, err
+	}
+	projectRoot := findProjectRoot(filepath.Dir(filename))
+	if projectRoot == "" {
+		return nil, fmt.Errorf("no project root found for %s", filename)
+	}
+	index, err := indexFor(projectRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	lines, err := v.BufferLines(buf, 0, -1, true)
+	if err != nil {
+		return nil, err
+	}
+	content := append(bytes.Join(lines, []byte("\n")), '\n')
+	updated, changes, err := organizeContent(content, filename, projectRoot, index, localPrefixes(localSetting, projectRoot))
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(content, updated) {
+		if err := applyEdit(v, buf, content, diffEdit(content, updated)); err != nil {
+			return nil, err
+		}
+	}
+	return changes, nil
+}
+
+// organizeContent returns content with its imports organized and the
+// changes made. Removals are reported at their line in content, additions
+// at their line in the result.
+func organizeContent(content []byte, file, root string, index *aliasIndex, local []string) ([]byte, []Change, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, file, content, parser.ParseComments)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse %s: %v", file, err)
+	}
+
+	// Selectors on names neither the file nor the rest of its package
+	// declare are package references
+	unresolved := make(map[*ast.Ident]bool)
+	for _, ident := range f.Unresolved {
+		unresolved[ident] = true
+	}
+	declared := packageNames(file, f.Name.Name)
+	var used []string
+	symbols := make(map[string][]string)
+	firstUse := make(map[string]token.Pos)
+	ast.Inspect(f, func(node ast.Node) bool {
+		selector, ok := node.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		ident, ok := selector.X.(*ast.Ident)
+		if !ok || !unresolved[ident] || declared[ident.Name] {
+			return true
+		}
+		if _, seen := symbols[ident.Name]; !seen {
+			used = append(used, ident.Name)
+			firstUse[ident.Name] = ident.Pos()
+		}
+		for _, symbol := range symbols[ident.Name] {
+			if symbol == selector.Sel.Name {
+				return true
+			}
+		}
+		symbols[ident.Name] = append(symbols[ident.Name], selector.Sel.Name)
+		return true
+	})
+
+	var changes []Change
+	change := func(pos token.Pos, kind, text string) {
+		position := fset.Position(pos)
+		changes = append(changes, Change{File: file, Line: position.Line, Column: position.Column, Kind: kind, Text: text})
+	}
+
+	// Unused and duplicate imports
+	offset := func(pos token.Pos) int { return fset.Position(pos).Offset }
+	provided := make(map[string]bool)
+	imported := make(map[string]bool)
+	removed := make(map[*ast.ImportSpec]bool)
+	for _, spec := range f.Imports {
+		path, _ := strconv.Unquote(spec.Path.Value)
+		name := packageName(root, path)
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+		text := string(content[offset(spec.Pos()):offset(spec.End())])
+		switch {
+		case imported[name+" "+path]:
+			removed[spec] = true
+			change(spec.Pos(), "duplicate", "removed duplicate import "+text)
+		case name != "_" && name != "." && path != "C" && symbols[name] == nil:
+			removed[spec] = true
+			change(spec.Pos(), "unused", "removed unused import "+text)
+		default:
+			provided[name] = true
+		}
+		imported[name+" "+path] = true
+	}
+
+	var removals []textEdit
+	for _, decl := range f.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.IMPORT {
+			continue
+		}
+		kept := 0
+		for _, spec := range gen.Specs {
+			if !removed[spec.(*ast.ImportSpec)] {
+				kept++
+			}
+		}
+		if kept == len(gen.Specs) {
+			continue
+		}
+		if kept > 0 {
+			for _, spec := range gen.Specs {
+				spec := spec.(*ast.ImportSpec)
+				if removed[spec] {
+					removals = append(removals, textEdit{lineStart(content, offset(specStart(spec))), lineEnd(content, offset(spec.End())) + 1, ""})
+				}
+			}
+			continue
+		}
+		// The whole declaration goes, with one of the blank lines around it
+		start := gen.Pos()
+		if gen.Doc != nil {
+			start = gen.Doc.Pos()
+		}
+		from, to := lineStart(content, offset(start)), lineEnd(content, offset(gen.End()))+1
+		if to < len(content) && content[to] == '\n' && from >= 2 && content[from-2] == '\n' {
+			to++
+		}
+		removals = append(removals, textEdit{from, to, ""})
+	}
+	updated := content
+	for i := len(removals) - 1; i >= 0; i-- {
+		r := removals[i]
+		updated = append(append(append([]byte(nil), updated[:r.start]...), r.text...), updated[r.end:]...)
+	}
+	if len(removals) > 0 {
+		updated = tidyImports(updated)
+	}
+
+	// Missing imports
+	var added []string
+	for _, name := range used {
+		if provided[name] {
+			continue
+		}
+		path, err := resolveImport(index, root, file, name, symbols[name])
+		if err != nil {
+			change(firstUse[name], "unresolved", err.Error())
+			continue
+		}
+		importName := ""
+		if packageName(root, path) != name {
+			importName = name
+		}
+		edit, ok, err := addImportEdit(updated, importName, path, local)
+		if err != nil {
+			return nil, nil, err
+		}
+		if ok {
+			updated = append(append(append([]byte(nil), updated[:edit.start]...), edit.text...), updated[edit.end:]...)
+			added = append(added, path)
+		}
+	}
+
+	if len(added) > 0 {
+		fset = token.NewFileSet()
+		result, err := parser.ParseFile(fset, file, updated, parser.ImportsOnly)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, path := range added {
+			for _, spec := range result.Imports {
+				if spec.Path.Value == strconv.Quote(path) {
+					text := string(updated[offset(spec.Pos()):offset(spec.End())])
+					change(spec.Pos(), "import", "added import "+text)
+					break
+				}
+			}
+		}
+	}
+	return updated, changes, nil
+}
+
+// resolveImport picks the import path for a package name used with the
+// selected symbols, the best ranked candidate not known to miss one of them
+func resolveImport(index *aliasIndex, root, file, name string, symbols []string) (string, error) {
+	first := ""
+	for _, symbol := range symbols {
+		if ast.IsExported(symbol) {
+			first = symbol
+			break
+		}
+	}
+	candidates, err := index.rank(name, file, first)
+	if err != nil {
+		return "", err
+	}
+	if len(candidates) == 0 {
+		return "", fmt.Errorf("no import found for alias: %s", name)
+	}
+	for _, c := range candidates {
+		complete := true
+		for _, symbol := range symbols {
+			if exports, known := packageExports(root, c.Path, symbol); ast.IsExported(symbol) && known && !exports {
+				complete = false
+				break
+			}
+		}
+		if complete {
+			return c.Path, nil
+		}
+	}
+	return "", fmt.Errorf("no package imported as %s exports %s", name, strings.Join(symbols, ", "))
+}
+
+// packageNames returns the top-level names declared by the other files of
+// the package of file, their selectors are no package references
+func packageNames(file, pkgName string) map[string]bool {
+	names := make(map[string]bool)
+	entries, err := os.ReadDir(filepath.Dir(file))
+	if err != nil {
+		return names
+	}
+	fset := token.NewFileSet()
+	for _, entry := range entries {
+		path := filepath.Join(filepath.Dir(file), entry.Name())
+		if entry.IsDir() || !strings.HasSuffix(path, ".go") || path == file {
+			continue
+		}
+		f, err := parser.ParseFile(fset, path, nil, parser.SkipObjectResolution)
+		if err != nil || f.Name.Name != pkgName {
+			continue
+		}
+		for _, name := range declaredNames(f) {
+			names[name] = true
+		}
+	}
+	return names
+}
+
+var blankLines = regexp.MustCompile(`\n([ \t]*\n)+`)
+
+// tidyImports drops the blank lines removed imports leave behind: leading
+// and trailing ones of a block and repeated ones between groups
+func tidyImports(content []byte) []byte {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "", content, parser.ImportsOnly|parser.ParseComments)
+	if err != nil {
+		return content
+	}
+	var blocks []*ast.GenDecl
+	for _, decl := range f.Decls {
+		if gen, ok := decl.(*ast.GenDecl); ok && gen.Tok == token.IMPORT && gen.Lparen.IsValid() {
+			blocks = append(blocks, gen)
+		}
+	}
+	sort.Slice(blocks, func(i, j int) bool { return blocks[i].Pos() > blocks[j].Pos() })
+	for _, block := range blocks {
+		start := fset.Position(block.Lparen).Offset + 1
+		end := lineStart(content, fset.Position(block.Rparen).Offset)
+		body := blankLines.ReplaceAll(content[start:end], []byte("\n\n"))
+		body = append([]byte("\n"), bytes.Trim(body, "\n")...)
+		if len(body) > 1 {
+			body = append(body, '\n')
+		}
+		content = append(append(append([]byte(nil), content[:start]...), body...), content[end:]...)
+	}
+	return content
+}
+
+// diffEdit returns the edit turning before into after, the lines between
+// their common beginning and end
+func diffEdit(before, after []byte) textEdit {
+	prefix := 0
+	for prefix < len(before) && prefix < len(after) && before[prefix] == after[prefix] {
+		prefix++
+	}
+	prefix = lineStart(before, prefix)
+	suffix := 0
+	for suffix < len(before)-prefix && suffix < len(after)-prefix && before[len(before)-1-suffix] == after[len(after)-1-suffix] {
+		suffix++
+	}
+	// End the edit at a line end
+	for suffix > 0 && before[len(before)-suffix] != '\n' {
+		suffix--
+	}
+	return textEdit{prefix, len(before) - suffix, string(after[prefix : len(after)-suffix])}
+}
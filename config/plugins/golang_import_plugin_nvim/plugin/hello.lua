@@ -48,18 +48,130 @@ local function ensure_job()
 	return jobid
 end
 
-vim.api.nvim_create_user_command("AddImport", function(args)
-	local word = vim.fn.expand("<cword>")
+-- Loads the change report of a handler into the quickfix list
+local function show_changes(title, changes)
+	if type(changes) ~= "table" or #changes == 0 then
+		return
+	end
+	local items = {}
+	for _, change in ipairs(changes) do
+		table.insert(items, {
+			filename = change.file,
+			lnum = change.line,
+			col = change.column,
+			text = "[" .. change.kind .. "] " .. change.text,
+		})
+	end
+	vim.fn.setqflist({}, " ", { title = title, items = items })
+end
+
+-- Returns the package and the selected name of the pkg.Name under the
+-- cursor, or the word under the cursor and ""
+local function selector_under_cursor()
+	local line = vim.api.nvim_get_current_line()
+	local col = vim.api.nvim_win_get_cursor(0)[2] + 1
+	local start = 1
+	while true do
+		local first, last, pkg, name = line:find("%f[%w_]([%a_][%w_]*)%.([%a_][%w_]*)", start)
+		if not first then
+			break
+		end
+		if col >= first and col <= last then
+			return pkg, name
+		end
+		start = first + #pkg + 1
+	end
+	return vim.fn.expand("<cword>"), ""
+end
+
+local function add_import(word, symbol, import_path)
 	log("Attempting to add import for word: " .. word)
-	local cwd = vim.fn.getcwd()
-	-- vim.notify("hello world", vim.log.levels.INFO)
-	local result, err = vim.fn.rpcrequest(ensure_job(), "addImport", { word, cwd })
-	if err then
-		log("Error adding import: " .. tostring(err))
-		print("Error adding import: " .. tostring(err))
+	-- Like goimports -local, g:golang_import_local lists the comma-separated
+	-- prefixes of imports grouped after third-party ones
+	local args = { word, vim.fn.getcwd(), import_path or "", symbol, vim.g.golang_import_local or "" }
+	local ok, result = pcall(vim.fn.rpcrequest, ensure_job(), "addImport", args)
+	if not ok then
+		log("Error adding import: " .. tostring(result))
+		print("Error adding import: " .. tostring(result))
 	else
 		log("Import added successfully")
 		print("Import added successfully")
+		show_changes("AddImport", result)
+	end
+end
+
+-- Describes a candidate in the vim.ui.select list
+local function format_candidate(candidate)
+	local notes = { candidate.count .. (candidate.count == 1 and " use" or " uses") }
+	if candidate["local"] > 0 then
+		table.insert(notes, "used here")
+	end
+	if candidate.stdlib then
+		table.insert(notes, "stdlib")
 	end
-	print(result)
-end, { nargs = "*" })
+	if candidate.remembered then
+		table.insert(notes, "chosen before")
+	end
+	if candidate.exports then
+		table.insert(notes, "exports it")
+	end
+	return candidate.path .. " (" .. table.concat(notes, ", ") .. ")"
+end
+
+-- :AddImport imports the package under the cursor. An ambiguous alias asks
+-- which package is meant and remembers the answer for the project, the bang
+-- asks again.
+vim.api.nvim_create_user_command("AddImport", function(opts)
+	local word, symbol = selector_under_cursor()
+	local args = { word, vim.fn.expand("%:p"), symbol }
+	local ok, candidates = pcall(vim.fn.rpcrequest, ensure_job(), "importCandidates", args)
+	if not ok then
+		print("Error adding import: " .. tostring(candidates))
+		return
+	end
+	if type(candidates) ~= "table" or #candidates == 0 then
+		print("No import found for alias: " .. word)
+		return
+	end
+	if #candidates == 1 or (candidates[1].remembered and not opts.bang) then
+		add_import(word, symbol)
+		return
+	end
+	vim.ui.select(candidates, {
+		prompt = "Import " .. word .. " from",
+		format_item = format_candidate,
+	}, function(choice)
+		if choice then
+			add_import(word, symbol, choice.path)
+		end
+	end)
+end, { nargs = "*", bang = true })
+
+-- :OrganizeImports adds the missing imports of the buffer and removes the
+-- unused and duplicate ones
+vim.api.nvim_create_user_command("OrganizeImports", function()
+	local args = { vim.g.golang_import_local or "" }
+	local ok, result = pcall(vim.fn.rpcrequest, ensure_job(), "organizeImports", args)
+	if not ok then
+		log("Error organizing imports: " .. tostring(result))
+		print("Error organizing imports: " .. tostring(result))
+		return
+	end
+	if type(result) ~= "table" or #result == 0 then
+		print("Imports are organized")
+		return
+	end
+	print("Organized imports: " .. #result .. (#result == 1 and " change" or " changes"))
+	show_changes("OrganizeImports", result)
+end, {})
+
+-- Keep the alias index of the project current without walking it again
+vim.api.nvim_create_autocmd("BufWritePost", {
+	pattern = "*.go",
+	callback = function(event)
+		local job = ensure_job()
+		if job then
+			vim.fn.rpcnotify(job, "indexFile", { vim.fn.fnamemodify(event.file, ":p") })
+		end
+	end,
+})
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// rank returns the candidates for alias as seen from file, best first: the
+// path chosen before, then paths imported in the same directory, standard
+// library packages, paths used more often in the project, paths known to
+// export symbol and paths closer to the package of file. With a symbol,
+// packages known not to export it are dropped; it is an error when that
+// leaves none.
+func (x *aliasIndex) rank(alias, file, symbol string) ([]Candidate, error) {
+	candidates := x.lookup(alias)
+	// Packages of the standard library and the module cache nobody in the
+	// project imports yet
+	known := make(map[string]bool)
+	for _, c := range candidates {
+		known[c.Path] = true
+	}
+	for _, pkg := range packagesNamed(x.root, alias) {
+		if !known[pkg.Path] {
+			candidates = append(candidates, Candidate{Path: pkg.Path})
+		}
+	}
+	dir := filepath.Dir(file)
+	pkgPath := packagePath(x.root, dir)
+
+	x.mu.Lock()
+	chosen := x.choice(alias)
+	for i := range candidates {
+		c := &candidates[i]
+		for path, indexed := range x.files {
+			if filepath.Dir(path) != dir {
+				continue
+			}
+			for _, imp := range indexed.Imports {
+				if imp.Alias == alias && imp.Path == c.Path {
+					c.Local++
+				}
+			}
+		}
+		c.Stdlib = isStdlib(c.Path)
+		c.Remembered = c.Path == chosen
+	}
+	x.mu.Unlock()
+
+	if symbol != "" && ast.IsExported(symbol) {
+		verified := candidates[:0]
+		for _, c := range candidates {
+			exports, known := packageExports(x.root, c.Path, symbol)
+			if known && !exports {
+				continue
+			}
+			c.Exports = exports
+			verified = append(verified, c)
+		}
+		if len(verified) == 0 && len(candidates) > 0 {
+			return nil, fmt.Errorf("no package imported as %s exports %s", alias, symbol)
+		}
+		candidates = verified
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		switch {
+		case a.Remembered != b.Remembered:
+			return a.Remembered
+		case a.Local != b.Local:
+			return a.Local > b.Local
+		case a.Stdlib != b.Stdlib:
+			return a.Stdlib
+		case a.Count != b.Count:
+			return a.Count > b.Count
+		case a.Exports != b.Exports:
+			return a.Exports
+		}
+		return sharedPrefix(a.Path, pkgPath) > sharedPrefix(b.Path, pkgPath)
+	})
+	return candidates, nil
+}
+
+// isStdlib reports whether an import path belongs to the standard library,
+// whose first path element has no dot
+func isStdlib(path string) bool {
+	first, _, _ := strings.Cut(path, "/")
+	return !strings.Contains(first, ".")
+}
+
+// packagePath returns the import path of dir from the go.mod of root, ""
+// when root has none
+func packagePath(root, dir string) string {
+	content, err := os.ReadFile(filepath.Join(root, "go.mod"))
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		if module, found := strings.CutPrefix(strings.TrimSpace(line), "module "); found {
+			rel, err := filepath.Rel(root, dir)
+			if err != nil || rel == "." {
+				return strings.Trim(module, "\" ")
+			}
+			return strings.Trim(module, "\" ") + "/" + filepath.ToSlash(rel)
+		}
+	}
+	return ""
+}
+
+// sharedPrefix counts the leading path elements a and b have in common
+func sharedPrefix(a, b string) int {
+	as, bs := strings.Split(a, "/"), strings.Split(b, "/")
+	n := 0
+	for n < len(as) && n < len(bs) && as[n] == bs[n] {
+		n++
+	}
+	return n
+}
+
+// choicesPath returns the file the choices of a project are kept in, next
+// to its index cache
+func (x *aliasIndex) choicesPath() string {
+	return strings.TrimSuffix(x.cachePath, ".json") + ".choices.json"
+}
+
+// choice returns the path chosen for alias before, x.mu must be held
+func (x *aliasIndex) choice(alias string) string {
+	if x.choices == nil {
+		x.choices = make(map[string]string)
+		if content, err := os.ReadFile(x.choicesPath()); err == nil {
+			_ = json.Unmarshal(content, &x.choices)
+		}
+	}
+	return x.choices[alias]
+}
+
+// remember records the path chosen for an ambiguous alias
+func (x *aliasIndex) remember(alias, path string) error {
+	x.mu.Lock()
+	if x.choice(alias) == path {
+		x.mu.Unlock()
+		return nil
+	}
+	x.choices[alias] = path
+	content, err := json.Marshal(x.choices)
+	x.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(x.choicesPath()), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(x.choicesPath(), content, 0o644)
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// symbolsVersion changes with the format of the package caches
+const symbolsVersion = 1
+
+// indexedPackage is an importable package of the standard library or of a
+// module in the module cache
+type indexedPackage struct {
+	Path string `json:"path"`
+	Name string `json:"name"`
+	// Exports are the exported top-level names, sorted
+	Exports []string `json:"exports"`
+}
+
+// packageCache is the content of the cache file of the standard library or
+// of one module version
+type packageCache struct {
+	Version  int               `json:"version"`
+	Packages []*indexedPackage `json:"packages"`
+}
+
+var (
+	packageSetsMu sync.Mutex
+	// packageSets holds the loaded package sets by "std" or "path@version"
+	packageSets = make(map[string][]*indexedPackage)
+)
+
+// goRoot returns the GOROOT the packages of the standard library are read
+// from
+func goRoot() string {
+	if root := os.Getenv("GOROOT"); root != "" {
+		return root
+	}
+	return build.Default.GOROOT
+}
+
+// goModCache returns the module cache directory
+func goModCache() string {
+	if dir := os.Getenv("GOMODCACHE"); dir != "" {
+		return dir
+	}
+	gopath := filepath.SplitList(build.Default.GOPATH)
+	if len(gopath) == 0 {
+		return ""
+	}
+	return filepath.Join(gopath[0], "pkg", "mod")
+}
+
+// goVersion returns the version of the Go installation in GOROOT, caches
+// of the standard library are kept per version
+func goVersion(root string) string {
+	content, err := os.ReadFile(filepath.Join(root, "VERSION"))
+	if err != nil {
+		return runtime.Version()
+	}
+	version, _, _ := strings.Cut(string(content), "\n")
+	return version
+}
+
+// projectPackages returns the packages of the standard library and of the
+// module versions the go.mod of root requires
+func projectPackages(root string) []*indexedPackage {
+	packages := stdlibPackages()
+
+	modCache := goModCache()
+	if modCache == "" {
+		return packages
+	}
+	// Copy, so appending never touches the cached standard library
+	packages = append([]*indexedPackage(nil), packages...)
+	for _, module := range requiredModules(filepath.Join(root, "go.mod")) {
+		dir := filepath.Join(modCache, escapeModulePath(module.path)+"@"+escapeModulePath(module.version))
+		if _, err := os.Stat(dir); err != nil {
+			// Not downloaded, nothing to index offline
+			continue
+		}
+		key := module.path + "@" + module.version
+		packages = append(packages, loadPackageSet(key, key, func() []*indexedPackage {
+			return scanPackages(dir, module.path, false)
+		})...)
+	}
+	return packages
+}
+
+// stdlibPackages returns the packages of the standard library
+func stdlibPackages() []*indexedPackage {
+	goroot := goRoot()
+	return loadPackageSet("std", "std@"+goVersion(goroot), func() []*indexedPackage {
+		return scanPackages(filepath.Join(goroot, "src"), "", true)
+	})
+}
+
+// loadPackageSet returns a package set from memory, from its cache file or
+// by scanning it. Standard library and module versions never change, so
+// their caches are never refreshed.
+func loadPackageSet(key, cacheKey string, scan func() []*indexedPackage) []*indexedPackage {
+	packageSetsMu.Lock()
+	defer packageSetsMu.Unlock()
+	if packages, found := packageSets[key]; found {
+		return packages
+	}
+
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	sum := sha1.Sum([]byte(cacheKey))
+	path := filepath.Join(dir, "golang_import_plugin_nvim", "packages", hex.EncodeToString(sum[:])+".json")
+
+	var cache packageCache
+	if content, err := os.ReadFile(path); err == nil && json.Unmarshal(content, &cache) == nil && cache.Version == symbolsVersion {
+		packageSets[key] = cache.Packages
+		return cache.Packages
+	}
+
+	packages := scan()
+	packageSets[key] = packages
+	content, err := json.Marshal(packageCache{Version: symbolsVersion, Packages: packages})
+	if err == nil {
+		if err = os.MkdirAll(filepath.Dir(path), 0o755); err == nil {
+			err = os.WriteFile(path, content, 0o644)
+		}
+	}
+	if err != nil {
+		log.Printf("failed to cache the packages of %s: %v", key, err)
+	}
+	return packages
+}
+
+// scanPackages reads the importable packages below dir. prefix is the
+// import path of dir, "" for GOROOT/src. Internal packages can't be
+// imported from the project and are skipped, as are nested modules.
+func scanPackages(dir, prefix string, stdlib bool) []*indexedPackage {
+	var packages []*indexedPackage
+	_ = filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.IsDir() {
+			return nil
+		}
+		rel, _ := filepath.Rel(dir, path)
+		if path != dir {
+			name := entry.Name()
+			if skipDir(name) || name == "internal" || (stdlib && (rel == "cmd" || rel == "builtin")) {
+				return filepath.SkipDir
+			}
+			if _, err := os.Stat(filepath.Join(path, "go.mod")); err == nil && !stdlib {
+				return filepath.SkipDir
+			}
+		}
+
+		importPath := prefix
+		if rel != "." {
+			importPath = strings.TrimPrefix(prefix+"/"+filepath.ToSlash(rel), "/")
+		}
+		if pkg := scanPackage(path, importPath); pkg != nil {
+			packages = append(packages, pkg)
+		}
+		return nil
+	})
+	return packages
+}
+
+// scanPackage reads the name and the exported declarations of the package
+// in dir, nil when there is none or it is a command
+func scanPackage(dir, importPath string) *indexedPackage {
+	entries, err := os.ReadDir(dir)
+	if err != nil || importPath == "" {
+		return nil
+	}
+	pkg := &indexedPackage{Path: importPath}
+	exports := make(map[string]bool)
+	fset := token.NewFileSet()
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(fset, filepath.Join(dir, name), nil, parser.SkipObjectResolution)
+		if err != nil {
+			continue
+		}
+		// Files excluded by build tags may declare other packages
+		if f.Name.Name == "main" || f.Name.Name == "documentation" {
+			continue
+		}
+		if pkg.Name == "" {
+			pkg.Name = f.Name.Name
+		} else if pkg.Name != f.Name.Name {
+			continue
+		}
+		for _, name := range exportedNames(f) {
+			exports[name] = true
+		}
+	}
+	if pkg.Name == "" {
+		return nil
+	}
+	for name := range exports {
+		pkg.Exports = append(pkg.Exports, name)
+	}
+	sort.Strings(pkg.Exports)
+	return pkg
+}
+
+// exportedNames returns the exported top-level names a file declares
+func exportedNames(f *ast.File) []string {
+	var names []string
+	for _, name := range declaredNames(f) {
+		if ast.IsExported(name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// declaredNames returns the top-level names a file declares. Methods are
+// reached through their type and are not listed.
+func declaredNames(f *ast.File) []string {
+	var names []string
+	for _, decl := range f.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			if d.Recv == nil {
+				names = append(names, d.Name.Name)
+			}
+		case *ast.GenDecl:
+			for _, spec := range d.Specs {
+				switch s := spec.(type) {
+				case *ast.TypeSpec:
+					names = append(names, s.Name.Name)
+				case *ast.ValueSpec:
+					for _, name := range s.Names {
+						names = append(names, name.Name)
+					}
+				}
+			}
+		}
+	}
+	return names
+}
+
+// exports reports whether the package declares the exported name
+func (p *indexedPackage) exports(name string) bool {
+	i := sort.SearchStrings(p.Exports, name)
+	return i < len(p.Exports) && p.Exports[i] == name
+}
+
+// packageExports reports whether the package at path exports symbol. known
+// is false when the package is neither in the standard library, the module
+// cache nor the module of root. Packages of the module are read fresh, they
+// change while the user works.
+func packageExports(root, path, symbol string) (exports, known bool) {
+	for _, pkg := range projectPackages(root) {
+		if pkg.Path == path {
+			return pkg.exports(symbol), true
+		}
+	}
+	dir, found := moduleDir(root, path)
+	if !found {
+		return false, false
+	}
+	pkg := scanPackage(dir, path)
+	if pkg == nil {
+		return false, false
+	}
+	return pkg.exports(symbol), true
+}
+
+// moduleDir returns the directory of a package of the module of root
+func moduleDir(root, path string) (string, bool) {
+	module := packagePath(root, root)
+	if module == "" || (path != module && !strings.HasPrefix(path, module+"/")) {
+		return "", false
+	}
+	return filepath.Join(root, filepath.FromSlash(strings.TrimPrefix(strings.TrimPrefix(path, module), "/"))), true
+}
+
+// packagesNamed returns the packages of the standard library and the
+// required modules whose package name is name
+func packagesNamed(root, name string) []*indexedPackage {
+	var found []*indexedPackage
+	for _, pkg := range projectPackages(root) {
+		if pkg.Name == name {
+			found = append(found, pkg)
+		}
+	}
+	return found
+}
+
+// packagesExporting returns the packages declaring the exported symbol
+func packagesExporting(root, symbol string) []*indexedPackage {
+	var found []*indexedPackage
+	for _, pkg := range projectPackages(root) {
+		if pkg.exports(symbol) {
+			found = append(found, pkg)
+		}
+	}
+	return found
+}
+
+type requiredModule struct {
+	path, version string
+}
+
+// requiredModules reads the require directives of a go.mod file
+func requiredModules(goMod string) []requiredModule {
+	content, err := os.ReadFile(goMod)
+	if err != nil {
+		return nil
+	}
+	var modules []requiredModule
+	inBlock := false
+	for _, line := range strings.Split(string(content), "\n") {
+		line, _, _ = strings.Cut(line, "//")
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 0:
+			continue
+		case inBlock && fields[0] == ")":
+			inBlock = false
+			continue
+		case fields[0] == "require" && len(fields) == 2 && fields[1] == "(":
+			inBlock = true
+			continue
+		case fields[0] == "require":
+			fields = fields[1:]
+		case !inBlock:
+			continue
+		}
+		if len(fields) >= 2 {
+			modules = append(modules, requiredModule{strings.Trim(fields[0], `"`), fields[1]})
+		}
+	}
+	return modules
+}
+
+// escapeModulePath escapes upper case letters the way the module cache
+// does: "github.com/BurntSushi" is stored as "github.com/!burnt!sushi"
+func escapeModulePath(path string) string {
+	var escaped strings.Builder
+	for _, r := range path {
+		if unicode.IsUpper(r) {
+			escaped.WriteByte('!')
+			r = unicode.ToLower(r)
+		}
+		escaped.WriteRune(r)
+	}
+	return escaped.String()
+}
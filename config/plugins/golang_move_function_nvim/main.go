@@ -1,7 +1,13 @@
 package main
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/scanner"
+	"go/token"
 	"log"
 	"os"
 	"path/filepath"
@@ -16,7 +22,17 @@ var (
 	pathMutex    sync.RWMutex
 )
 
-func moveCode(v *nvim.Nvim, args []string) error {
+// Change describes a location touched by a handler; it is loaded into the
+// quickfix list on the Lua side.
+type Change struct {
+	File   string `msgpack:"file"`
+	Line   int    `msgpack:"line"`
+	Column int    `msgpack:"column"`
+	Kind   string `msgpack:"kind"`
+	Text   string `msgpack:"text"`
+}
+
+func moveCode(v *nvim.Nvim, args []string) ([]Change, error) {
 	var destPath string
 	if len(args) == 0 || args[0] == "" {
 		// Use last destination path if no new path provided
@@ -24,7 +40,7 @@ func moveCode(v *nvim.Nvim, args []string) error {
 		destPath = lastDestPath
 		pathMutex.RUnlock()
 		if destPath == "" {
-			return fmt.Errorf("No previous destination path available")
+			return nil, fmt.Errorf("No previous destination path available")
 		}
 	} else {
 		destPath = args[0]
@@ -33,23 +49,23 @@ func moveCode(v *nvim.Nvim, args []string) error {
 	// Get current buffer and its file path
 	buffer, err := v.CurrentBuffer()
 	if err != nil {
-		return fmt.Errorf("Failed to get current buffer: %v", err)
+		return nil, fmt.Errorf("Failed to get current buffer: %v", err)
 	}
 	currentFilePath, err := v.BufferName(buffer)
 	if err != nil {
-		return fmt.Errorf("Failed to get current file path: %v", err)
+		return nil, fmt.Errorf("Failed to get current file path: %v", err)
 	}
 
 	// Find project root
 	projectRoot, err := findProjectRoot(currentFilePath)
 	if err != nil {
-		return fmt.Errorf("Failed to find project root: %v", err)
+		return nil, fmt.Errorf("Failed to find project root: %v", err)
 	}
 
 	// Process and validate the destination path
 	fullDestPath, err := processDestinationPath(destPath, currentFilePath, projectRoot)
 	if err != nil {
-		return fmt.Errorf("Invalid destination path: %v", err)
+		return nil, fmt.Errorf("Invalid destination path: %v", err)
 	}
 
 	// Update last destination path
@@ -60,23 +76,23 @@ func moveCode(v *nvim.Nvim, args []string) error {
 	// Get cursor position
 	window, err := v.CurrentWindow()
 	if err != nil {
-		return fmt.Errorf("Failed to get current window: %v", err)
+		return nil, fmt.Errorf("Failed to get current window: %v", err)
 	}
 	cursor, err := v.WindowCursor(window)
 	if err != nil {
-		return fmt.Errorf("Failed to get cursor position: %v", err)
+		return nil, fmt.Errorf("Failed to get cursor position: %v", err)
 	}
 
 	// Get buffer contents
 	lines, err := v.BufferLines(buffer, 0, -1, true)
 	if err != nil {
-		return fmt.Errorf("Failed to get buffer lines: %v", err)
+		return nil, fmt.Errorf("Failed to get buffer lines: %v", err)
 	}
 
 	// Find code boundaries
-	startLine, endLine, codeType := findCodeBoundaries(lines, cursor[0]-1)
+	startLine, endLine, codeType, syntaxErrors, guessed := findDeclBoundaries(lines, cursor[0]-1)
 	if startLine == -1 || endLine == -1 {
-		return fmt.Errorf("No movable code found at cursor position")
+		return nil, fmt.Errorf("No movable code found at cursor position")
 	}
 
 	// Extract code text
@@ -86,7 +102,7 @@ func moveCode(v *nvim.Nvim, args []string) error {
 	// Ensure destination directory exists
 	destDir := filepath.Dir(fullDestPath)
 	if err := os.MkdirAll(destDir, 0o755); err != nil {
-		return fmt.Errorf("Failed to create destination directory: %v", err)
+		return nil, fmt.Errorf("Failed to create destination directory: %v", err)
 	}
 
 	// Check if the destination file is a new .go file
@@ -95,30 +111,170 @@ func moveCode(v *nvim.Nvim, args []string) error {
 	// Open the destination file
 	f, err := os.OpenFile(fullDestPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
 	if err != nil {
-		return fmt.Errorf("Failed to open destination file: %v", err)
+		return nil, fmt.Errorf("Failed to open destination file: %v", err)
 	}
 	defer f.Close()
 
+	// Remember where the code will land in the destination file
+	destLine := 1
+	if content, err := os.ReadFile(fullDestPath); err == nil {
+		destLine = bytes.Count(content, []byte{'\n'}) + 2
+	}
+
 	// If it's a new .go file, add package declaration
 	if isNewGoFile {
 		packageName := filepath.Base(filepath.Dir(fullDestPath))
 		packageDeclaration := fmt.Sprintf("package %s\n\n", packageName)
 		if _, err := f.WriteString(packageDeclaration); err != nil {
-			return fmt.Errorf("Failed to write package declaration: %v", err)
+			return nil, fmt.Errorf("Failed to write package declaration: %v", err)
 		}
+		destLine = strings.Count(packageDeclaration, "\n") + 2
 	}
 
 	// Write the code to the file
 	if _, err := f.WriteString("\n" + codeText); err != nil {
-		return fmt.Errorf("Failed to write code to destination file: %v", err)
+		return nil, fmt.Errorf("Failed to write code to destination file: %v", err)
 	}
 
 	// Remove code from source file
 	if err := v.SetBufferLines(buffer, startLine, endLine+1, true, [][]byte{}); err != nil {
-		return fmt.Errorf("Failed to remove code from source file: %v", err)
+		return nil, fmt.Errorf("Failed to remove code from source file: %v", err)
+	}
+
+	changes := []Change{
+		{
+			File:   currentFilePath,
+			Line:   startLine + 1,
+			Column: 1,
+			Kind:   "declaration",
+			Text:   fmt.Sprintf("%s moved to %s", codeType, fullDestPath),
+		},
+		{
+			File:   fullDestPath,
+			Line:   destLine,
+			Column: 1,
+			Kind:   "declaration",
+			Text:   fmt.Sprintf("%s moved from %s", codeType, currentFilePath),
+		},
+	}
+	if guessed {
+		changes = append(changes, Change{
+			File:   fullDestPath,
+			Line:   destLine,
+			Column: 1,
+			Kind:   "tolerated",
+			Text:   fmt.Sprintf("%s has syntax errors, its lines were guessed", codeType),
+		})
 	}
+	changes = append(changes, toleratedChanges(syntaxErrors, currentFilePath, startLine, endLine, fullDestPath, destLine)...)
 
-	return v.WriteOut(fmt.Sprintf("%s moved to %s\n", strings.Title(codeType), fullDestPath))
+	return changes, v.WriteOut(fmt.Sprintf("%s moved to %s\n", strings.Title(codeType), fullDestPath))
+}
+
+// findDeclBoundaries locates the top-level declaration under the cursor,
+// including its doc comment, with the parser. Files with syntax errors are
+// parsed as far as possible; when the declaration itself is broken the line
+// based heuristic of findCodeBoundaries is used instead. The tolerated
+// syntax errors are returned, guessed tells whether the heuristic was used
+// because of them.
+func findDeclBoundaries(lines [][]byte, cursorLine int) (startLine, endLine int, codeType string, syntaxErrors scanner.ErrorList, guessed bool) {
+	src := bytes.Join(lines, []byte{'\n'})
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", src, parser.ParseComments|parser.AllErrors)
+	if file == nil || (err != nil && !errors.As(err, &syntaxErrors)) {
+		startLine, endLine, codeType = findCodeBoundaries(lines, cursorLine)
+		return startLine, endLine, codeType, nil, false
+	}
+	fallback := func() (int, int, string, scanner.ErrorList, bool) {
+		startLine, endLine, codeType := findCodeBoundaries(lines, cursorLine)
+		return startLine, endLine, codeType, syntaxErrors, len(syntaxErrors) > 0
+	}
+
+	for _, decl := range file.Decls {
+		start := decl.Pos()
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			if d.Doc != nil {
+				start = d.Doc.Pos()
+			}
+			codeType = "function"
+			if d.Recv != nil {
+				codeType = "method"
+			}
+		case *ast.GenDecl:
+			if d.Doc != nil {
+				start = d.Doc.Pos()
+			}
+			codeType = genDeclType(d)
+		default:
+			continue
+		}
+
+		startLine = fset.Position(start).Line - 1
+		if cursorLine < startLine {
+			break
+		}
+		if !decl.End().IsValid() {
+			return fallback()
+		}
+		endLine = fset.Position(decl.End()).Line - 1
+		if cursorLine > endLine {
+			continue
+		}
+
+		for _, e := range syntaxErrors {
+			if e.Pos.Line-1 >= startLine && e.Pos.Line-1 <= endLine {
+				return fallback()
+			}
+		}
+		if codeType == "" {
+			return -1, -1, "", syntaxErrors, false
+		}
+		return startLine, endLine, codeType, syntaxErrors, false
+	}
+
+	// Between declarations the previous one is meant, as before
+	startLine, endLine, codeType = findCodeBoundaries(lines, cursorLine)
+	return startLine, endLine, codeType, syntaxErrors, false
+}
+
+// toleratedChanges reports the syntax errors left in the source file at
+// their lines after the moved lines were removed. Errors inside the moved
+// code go with it to the destination.
+func toleratedChanges(syntaxErrors scanner.ErrorList, sourcePath string, startLine, endLine int, destPath string, destLine int) []Change {
+	var changes []Change
+	for _, e := range syntaxErrors {
+		change := Change{File: sourcePath, Line: e.Pos.Line, Column: e.Pos.Column, Kind: "tolerated", Text: e.Msg}
+		switch line := e.Pos.Line - 1; {
+		case line > endLine:
+			change.Line -= endLine - startLine + 1
+		case line >= startLine:
+			change.File = destPath
+			change.Line = destLine + line - startLine
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+// genDeclType names the kind of a var, const or type declaration; imports
+// can't be moved
+func genDeclType(d *ast.GenDecl) string {
+	switch d.Tok {
+	case token.VAR, token.CONST:
+		return "variable"
+	case token.TYPE:
+		if len(d.Specs) == 1 {
+			switch d.Specs[0].(*ast.TypeSpec).Type.(type) {
+			case *ast.StructType:
+				return "struct"
+			case *ast.InterfaceType:
+				return "interface"
+			}
+		}
+		return "type"
+	}
+	return ""
 }
 
 func findCodeBoundaries(lines [][]byte, cursorLine int) (int, int, string) {
@@ -10,8 +10,26 @@ local function ensure_job()
   return chan
 end
 
+-- Loads the change report of a handler into the quickfix list
+local function show_changes(title, changes)
+  if type(changes) ~= "table" or #changes == 0 then
+    return
+  end
+  local items = {}
+  for _, change in ipairs(changes) do
+    table.insert(items, {
+      filename = change.file,
+      lnum = change.line,
+      col = change.column,
+      text = "[" .. change.kind .. "] " .. change.text,
+    })
+  end
+  vim.fn.setqflist({}, " ", { title = title, items = items })
+end
+
 local function move_code(path)
-  vim.fn.rpcrequest(ensure_job(), "moveCode", { path })
+  local changes = vim.fn.rpcrequest(ensure_job(), "moveCode", { path })
+  show_changes("MoveCode", changes)
   -- Сохраняем путь в глобальную переменную
   vim.g.last_move_dest_path = path
 end
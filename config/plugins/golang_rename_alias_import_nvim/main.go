@@ -2,14 +2,17 @@ package main
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"go/ast"
 	"go/format"
 	"go/parser"
+	"go/scanner"
 	"go/token"
 	"io/ioutil"
 	"log"
 	"os"
+	"sort"
 	"strings"
 
 	"github.com/neovim/go-client/nvim"
@@ -42,11 +45,24 @@ func renameAlias(v *nvim.Nvim, args []string) (string, error) {
 	oldAlias := args[1]
 	newAlias := args[2]
 
-	if err := updateFileAlias(filePath, oldAlias, newAlias); err != nil {
+	syntaxErrors, skipped, err := updateFileAlias(filePath, oldAlias, newAlias)
+	if err != nil {
 		return "", fmt.Errorf("failed to rename alias in %s: %v", filePath, err)
 	}
+	if len(syntaxErrors) == 0 {
+		return "Alias renamed successfully", nil
+	}
 
-	return "Alias renamed successfully", nil
+	// The tolerated errors and the uses left alone go back to the user, who
+	// has to finish the rename by hand once the code parses
+	message := []string{"Alias renamed successfully", fmt.Sprintf("Tolerated %d syntax errors:", len(syntaxErrors))}
+	for _, e := range syntaxErrors {
+		message = append(message, e.Error())
+	}
+	for _, pos := range skipped {
+		message = append(message, fmt.Sprintf("%s: skipped %s next to a syntax error", pos, oldAlias))
+	}
+	return strings.Join(message, "\n"), nil
 }
 
 func getImportOrAliasUnderCursor(v *nvim.Nvim, args []string) (map[string]interface{}, error) {
@@ -74,7 +90,7 @@ func getImportOrAliasUnderCursor(v *nvim.Nvim, args []string) (map[string]interf
 	row := cursor[0]
 
 	fset := token.NewFileSet()
-	node, err := parser.ParseFile(fset, filePath, nil, parser.ParseComments)
+	node, _, err := parseTolerant(fset, filePath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse file: %v", err)
 	}
@@ -104,49 +120,162 @@ func getImportOrAliasUnderCursor(v *nvim.Nvim, args []string) (map[string]interf
 	return nil, nil
 }
 
-func updateFileAlias(filePath, oldAlias, newAlias string) error {
+// parseTolerant parses filePath and keeps the partial AST when the file has
+// syntax errors, so that aliases can be renamed in code that is mid-edit
+func parseTolerant(fset *token.FileSet, filePath string) (*ast.File, scanner.ErrorList, error) {
+	node, err := parser.ParseFile(fset, filePath, nil, parser.ParseComments|parser.AllErrors)
+	if err == nil {
+		return node, nil, nil
+	}
+	var syntaxErrors scanner.ErrorList
+	if node == nil || !errors.As(err, &syntaxErrors) {
+		return nil, nil, err
+	}
+	return node, syntaxErrors, nil
+}
+
+// updateFileAlias renames oldAlias to newAlias in the import and its uses.
+// In a file with syntax errors uses next to an error may be misparsed, they
+// are left alone and returned with the tolerated errors.
+func updateFileAlias(filePath, oldAlias, newAlias string) (scanner.ErrorList, []token.Position, error) {
 	fset := token.NewFileSet()
-	node, err := parser.ParseFile(fset, filePath, nil, parser.ParseComments)
+	node, syntaxErrors, err := parseTolerant(fset, filePath)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
 	var aliasChanged bool
+	// renamed collects every identifier that was changed, so that files with
+	// syntax errors can be edited in place instead of being printed
+	var renamed []*ast.Ident
 
 	// First, find and update the import statement
 	for _, imp := range node.Imports {
 		if imp.Name != nil && imp.Name.Name == oldAlias {
+			if nearErrors(fset, imp, imp.Name, syntaxErrors) {
+				return nil, nil, fmt.Errorf("the import of %s is next to a syntax error", oldAlias)
+			}
 			imp.Name.Name = newAlias
+			renamed = append(renamed, imp.Name)
 			aliasChanged = true
 			break
 		}
 	}
 
-	if aliasChanged {
-		// Then, update all usages of the alias in the file
-		ast.Inspect(node, func(n ast.Node) bool {
-			switch x := n.(type) {
-			case *ast.SelectorExpr:
-				if ident, ok := x.X.(*ast.Ident); ok && ident.Name == oldAlias {
-					ident.Name = newAlias
-				}
-			case *ast.Ident:
-				// Update simple identifiers that match the old alias
-				if x.Name == oldAlias {
-					x.Name = newAlias
-				}
-			}
+	if !aliasChanged {
+		return syntaxErrors, nil, nil
+	}
+
+	// Then, update all usages of the alias in the file. The stack holds the
+	// enclosing nodes, the innermost statement bounds the broken region.
+	var stack []ast.Node
+	ast.Inspect(node, func(n ast.Node) bool {
+		if n == nil {
+			stack = stack[:len(stack)-1]
+			return true
+		}
+		stack = append(stack, n)
+
+		// Selector bases are identifiers too, each use is visited once
+		ident, ok := n.(*ast.Ident)
+		if !ok || ident.Name != oldAlias {
+			return true
+		}
+		if nearErrors(fset, enclosingStatement(stack), ident, syntaxErrors) {
+			return true
+		}
+		ident.Name = newAlias
+		renamed = append(renamed, ident)
+		return true
+	})
+
+	if len(syntaxErrors) > 0 {
+		content, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return nil, nil, err
+		}
+		skipped := remainingUses(fset.File(node.Pos()), content, renamed, oldAlias)
+		return syntaxErrors, skipped, renameIdentsInPlace(fset, filePath, content, renamed, len(oldAlias), newAlias)
+	}
+
+	var buf bytes.Buffer
+	if err := format.Node(&buf, fset, node); err != nil {
+		return nil, nil, err
+	}
+	return nil, nil, ioutil.WriteFile(filePath, buf.Bytes(), 0o644)
+}
+
+// enclosingStatement returns the innermost statement, spec, field or
+// declaration of the stack of nodes, the file itself when there is none
+func enclosingStatement(stack []ast.Node) ast.Node {
+	for i := len(stack) - 1; i > 0; i-- {
+		switch stack[i].(type) {
+		case *ast.BlockStmt:
+		case ast.Stmt, ast.Spec, *ast.Field, ast.Decl:
+			return stack[i]
+		}
+	}
+	return stack[0]
+}
+
+// nearErrors reports whether a syntax error lies inside scope or on the
+// line of ident. The parser reports many errors at the token after the
+// broken one, so an error on the same line counts as well.
+func nearErrors(fset *token.FileSet, scope ast.Node, ident *ast.Ident, syntaxErrors scanner.ErrorList) bool {
+	start, end := fset.Position(scope.Pos()).Offset, fset.Position(scope.End()).Offset
+	line := fset.Position(ident.Pos()).Line
+	for _, e := range syntaxErrors {
+		if (e.Pos.Offset >= start && e.Pos.Offset < end) || e.Pos.Line == line {
 			return true
-		})
+		}
+	}
+	return false
+}
+
+// remainingUses returns the positions of the tokens of content that may
+// still use alias: those not renamed, either skipped next to an error or
+// lost by the parser in a bad expression. Field and method names are left
+// out.
+func remainingUses(file *token.File, content []byte, renamed []*ast.Ident, alias string) []token.Position {
+	done := make(map[token.Pos]bool)
+	for _, ident := range renamed {
+		done[ident.Pos()] = true
+	}
 
-		var buf bytes.Buffer
-		if err := format.Node(&buf, fset, node); err != nil {
-			return err
+	var s scanner.Scanner
+	s.Init(file, content, nil, 0)
+	var uses []token.Position
+	prev := token.ILLEGAL
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		if tok == token.IDENT && lit == alias && prev != token.PERIOD && !done[pos] {
+			uses = append(uses, file.Position(pos))
 		}
-		return ioutil.WriteFile(filePath, buf.Bytes(), 0o644)
+		prev = tok
 	}
+	return uses
+}
 
-	return nil
+// renameIdentsInPlace replaces the identifiers directly in the file content,
+// leaving everything else, including broken code, byte for byte the same
+func renameIdentsInPlace(fset *token.FileSet, filePath string, content []byte, idents []*ast.Ident, oldLen int, newName string) error {
+	offsets := make(map[int]bool)
+	for _, ident := range idents {
+		offsets[fset.Position(ident.Pos()).Offset] = true
+	}
+	sorted := make([]int, 0, len(offsets))
+	for offset := range offsets {
+		sorted = append(sorted, offset)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(sorted)))
+
+	for _, offset := range sorted {
+		content = append(content[:offset], append([]byte(newName), content[offset+oldLen:]...)...)
+	}
+	return ioutil.WriteFile(filePath, content, 0o644)
 }
 
 func init() {
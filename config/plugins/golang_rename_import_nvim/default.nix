@@ -14,7 +14,7 @@ buildGoModule rec {
   vendorHash = "sha256-O1anAHCihosdV2R6/gbRl6KrVKPI7fhVYA1q3TFVesw=";
 
   buildPhase = ''
-    go build -mod=vendor -o ${pname} main.go
+    go build -mod=vendor -o ${pname} .
   '';
 
   installPhase = ''
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines shown around a change
+const diffContext = 3
+
+// diffLine is one line of an edit script: ' ' keeps it, '-' removes it
+// and '+' adds it
+type diffLine struct {
+	op   byte
+	text string
+}
+
+// lineDiff returns the shortest edit script turning a into b, by Myers'
+// algorithm
+func lineDiff(a, b []string) []diffLine {
+	n, m := len(a), len(b)
+	max := n + m
+	v := make([]int, 2*max+2)
+	var trace [][]int
+	for d := 0; d <= max; d++ {
+		trace = append(trace, append([]int(nil), v...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[max+k-1] < v[max+k+1]) {
+				x = v[max+k+1]
+			} else {
+				x = v[max+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+			v[max+k] = x
+			if x >= n && y >= m {
+				return backtrack(a, b, trace, d, max)
+			}
+		}
+	}
+	return nil
+}
+
+// backtrack walks the trace of lineDiff back from the end to build the
+// edit script
+func backtrack(a, b []string, trace [][]int, d, max int) []diffLine {
+	x, y := len(a), len(b)
+	var script []diffLine
+	for ; d > 0; d-- {
+		v := trace[d]
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && v[max+k-1] < v[max+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[max+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x, y = x-1, y-1
+			script = append(script, diffLine{' ', a[x]})
+		}
+		if x == prevX {
+			y--
+			script = append(script, diffLine{'+', b[y]})
+		} else {
+			x--
+			script = append(script, diffLine{'-', a[x]})
+		}
+	}
+	for x > 0 {
+		x, y = x-1, y-1
+		script = append(script, diffLine{' ', a[x]})
+	}
+	for i, j := 0, len(script)-1; i < j; i, j = i+1, j-1 {
+		script[i], script[j] = script[j], script[i]
+	}
+	return script
+}
+
+// unifiedDiff returns the changes between two versions of a file in the
+// unified format, "" when there are none
+func unifiedDiff(name string, before, after []byte) string {
+	script := lineDiff(splitLines(string(before)), splitLines(string(after)))
+
+	var out strings.Builder
+	for i := 0; i < len(script); {
+		if script[i].op == ' ' {
+			i++
+			continue
+		}
+		// A hunk runs until more than twice the context of unchanged lines
+		start := i - diffContext
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for end < len(script) {
+			if script[end].op != ' ' {
+				end++
+				continue
+			}
+			unchanged := end
+			for unchanged < len(script) && script[unchanged].op == ' ' {
+				unchanged++
+			}
+			if unchanged == len(script) || unchanged-end > 2*diffContext {
+				end += min(diffContext, unchanged-end)
+				break
+			}
+			end = unchanged
+		}
+
+		if out.Len() == 0 {
+			fmt.Fprintf(&out, "--- a/%s\n+++ b/%s\n", name, name)
+		}
+		oldLine, newLine := 1, 1
+		for _, line := range script[:start] {
+			if line.op != '+' {
+				oldLine++
+			}
+			if line.op != '-' {
+				newLine++
+			}
+		}
+		oldCount, newCount := 0, 0
+		for _, line := range script[start:end] {
+			if line.op != '+' {
+				oldCount++
+			}
+			if line.op != '-' {
+				newCount++
+			}
+		}
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", oldLine, oldCount, newLine, newCount)
+		for _, line := range script[start:end] {
+			out.WriteByte(line.op)
+			out.WriteString(line.text)
+			out.WriteByte('\n')
+		}
+		i = end
+	}
+	return out.String()
+}
+
+func splitLines(content string) []string {
+	if content == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+}
@@ -29,6 +29,7 @@ func main() {
 	}
 
 	v.RegisterHandler("renameImport", renameImport)
+	v.RegisterHandler("replaceImport", replaceImport)
 
 	if err := v.Serve(); err != nil {
 		log.Fatal(err)
@@ -106,7 +107,7 @@ func renameImport(v *nvim.Nvim, args []string) (string, error) {
 	}
 
 	// Обновление объявлений пакетов
-	newPackageName := filepath.Base(newPath)
+	newPackageName := assumedName(newImport)
 	if err := updatePackageDeclarations(newPath, newPackageName); err != nil {
 		return "", fmt.Errorf("failed to update package declarations: %v", err)
 	}
@@ -182,12 +183,23 @@ func isDirEmpty(dir string) (bool, error) {
 	return false, err
 }
 
+// findAllGoFiles returns the Go files of the module at root. Vendored
+// code, testdata, hidden directories and nested modules belong to others.
 func findAllGoFiles(root string) ([]string, error) {
 	var files []string
 	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
+		if info.IsDir() && path != root {
+			name := info.Name()
+			if name == "vendor" || name == "testdata" || strings.HasPrefix(name, ".") {
+				return filepath.SkipDir
+			}
+			if _, err := os.Stat(filepath.Join(path, "go.mod")); err == nil {
+				return filepath.SkipDir
+			}
+		}
 		if !info.IsDir() && strings.HasSuffix(path, ".go") {
 			files = append(files, path)
 		}
@@ -213,52 +225,15 @@ func updateFileContent(filePath, oldImport, newImport string) error {
 		return fmt.Errorf("error reading file %s: %v", filePath, err)
 	}
 
-	// Parse file
-	fset := token.NewFileSet()
-	node, err := parser.ParseFile(fset, filePath, content, parser.ParseComments)
+	updated, importChanged, _, err := rewriteImport(filePath, content, oldImport, newImport, nil)
 	if err != nil {
 		log.Printf("Error parsing file %s: %v\nFile contents:\n%s", filePath, err, string(content))
-		return fmt.Errorf("error parsing file %s: %v", filePath, err)
-	}
-
-	var importChanged bool
-	var oldPackageName, newPackageName string
-	var hasAlias bool
-
-	// Update imports and check for alias
-	for _, imp := range node.Imports {
-		if imp.Path != nil && imp.Path.Value == `"`+oldImport+`"` {
-			imp.Path.Value = `"` + newImport + `"`
-			importChanged = true
-			oldPackageName = filepath.Base(oldImport)
-			newPackageName = filepath.Base(newImport)
-			hasAlias = imp.Name != nil
-			break
-		}
-	}
-
-	// Update package usage if import was changed and there's no alias
-	if importChanged && !hasAlias {
-		ast.Inspect(node, func(n ast.Node) bool {
-			switch x := n.(type) {
-			case *ast.SelectorExpr:
-				if ident, ok := x.X.(*ast.Ident); ok {
-					if ident.Name == oldPackageName {
-						ident.Name = newPackageName
-					}
-				}
-			}
-			return true
-		})
+		return err
 	}
 
 	// Write changes back to file if imports or usage were changed
 	if importChanged {
-		var buf bytes.Buffer
-		if err := format.Node(&buf, fset, node); err != nil {
-			return fmt.Errorf("error formatting updated AST for %s: %v", filePath, err)
-		}
-		if err := ioutil.WriteFile(filePath, buf.Bytes(), 0o644); err != nil {
+		if err := ioutil.WriteFile(filePath, updated, 0o644); err != nil {
 			return fmt.Errorf("error writing updated content to %s: %v", filePath, err)
 		}
 	}
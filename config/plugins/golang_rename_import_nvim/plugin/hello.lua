@@ -91,3 +91,62 @@ local function rename_import()
 end
 
 vim.api.nvim_create_user_command("RenameImport", rename_import, {})
+
+-- Shows the diff of a replacement in a scratch buffer and applies it when
+-- confirmed
+local function preview_replacement(args)
+	local ok, diff = pcall(vim.fn.rpcrequest, ensure_job(), "replaceImport", vim.list_extend(vim.deepcopy(args), { "preview" }))
+	if not ok then
+		print("Error replacing import: " .. tostring(diff))
+		return
+	end
+	if diff == "" then
+		print("No file imports " .. args[2])
+		return
+	end
+
+	vim.cmd("vnew")
+	local buf = vim.api.nvim_get_current_buf()
+	vim.bo[buf].buftype = "nofile"
+	vim.bo[buf].bufhidden = "wipe"
+	vim.bo[buf].filetype = "diff"
+	vim.api.nvim_buf_set_lines(buf, 0, -1, false, vim.split(diff, "\n", { trimempty = true }))
+	vim.bo[buf].modifiable = false
+
+	vim.ui.select({ "Apply", "Cancel" }, { prompt = "Replace " .. args[2] .. " with " .. args[3] }, function(choice)
+		if choice ~= "Apply" then
+			return
+		end
+		local applied, result = pcall(vim.fn.rpcrequest, ensure_job(), "replaceImport", vim.list_extend(vim.deepcopy(args), { "apply" }))
+		if not applied then
+			print("Error replacing import: " .. tostring(result))
+			return
+		end
+		if vim.api.nvim_buf_is_valid(buf) then
+			vim.api.nvim_buf_delete(buf, { force = true })
+		end
+		vim.cmd("checktime")
+		print(result)
+	end)
+end
+
+-- :ReplaceImport [mapping] replaces the import path under the cursor in the
+-- whole module. The optional mapping file translates the selectors of the
+-- old package, one "pattern => replacement" rule per line.
+vim.api.nvim_create_user_command("ReplaceImport", function(opts)
+	local current_import = get_import_under_cursor() or ""
+	local mapping = opts.args ~= "" and vim.fn.fnamemodify(opts.args, ":p") or ""
+
+	vim.ui.input({ prompt = "Import path to replace: ", default = current_import }, function(old_import)
+		if not old_import or old_import == "" then
+			return
+		end
+		vim.ui.input({ prompt = "Replace " .. old_import .. " with: " }, function(new_import)
+			if not new_import or new_import == "" or new_import == old_import then
+				print("New import path must be different and non-empty")
+				return
+			end
+			preview_replacement({ vim.fn.getcwd(), old_import, new_import, mapping })
+		end)
+	end)
+end, { nargs = "?", complete = "file" })
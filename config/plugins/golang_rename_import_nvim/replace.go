@@ -0,0 +1,752 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/neovim/go-client/nvim"
+)
+
+// importMapping translates the selectors of a replaced package into
+// expressions of the new one. Its file holds one rule per line:
+//
+//	# comments and blank lines are skipped
+//	import "fmt"
+//	errors.Wrap(err, msg) => fmt.Errorf("%s: %w", msg, err)
+//	errors.Wrapf(err, format, args...) => fmt.Errorf(format+": %w", args, err)
+//	errors.Cause => errors.Unwrap
+//
+// Patterns name the old package by its default name, replacements the new
+// package and the packages of import lines. Other identifiers of a pattern
+// are placeholders for any expression and are substituted in the
+// replacement. A placeholder passed with ... as the last argument of a call
+// stands for the remaining arguments. The replacement passes them on as
+// arguments of a call, a call that spreads a slice only matches when they
+// are passed on with ... as the last argument too.
+type importMapping struct {
+	fset    *token.FileSet
+	imports []mappedImport
+	rules   []*mappingRule
+}
+
+// mappedImport is a package the replacements use besides the new one
+type mappedImport struct {
+	name, path string
+}
+
+type mappingRule struct {
+	pattern  ast.Expr
+	template string
+	// replacement is template parsed, its positions are in importMapping.fset
+	replacement  ast.Expr
+	placeholders map[string]bool
+	// variadic are the placeholders standing for the remaining arguments,
+	// spliced those the replacement passes on without ...
+	variadic map[string]bool
+	spliced  map[string]bool
+}
+
+// loadMapping reads a mapping file, oldName is the default name of the
+// replaced package
+func loadMapping(path, oldName string) (*importMapping, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	mapping := &importMapping{fset: token.NewFileSet()}
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "//") {
+			continue
+		}
+		if strings.HasPrefix(line, "import ") {
+			fields := strings.Fields(strings.TrimPrefix(line, "import "))
+			importPath, err := strconv.Unquote(fields[len(fields)-1])
+			if err != nil || len(fields) > 2 {
+				return nil, fmt.Errorf("%s:%d: expected import [name] \"path\"", path, lineNumber)
+			}
+			name := assumedName(importPath)
+			if len(fields) == 2 {
+				name = fields[0]
+			}
+			mapping.imports = append(mapping.imports, mappedImport{name, importPath})
+			continue
+		}
+
+		pattern, template, found := strings.Cut(line, "=>")
+		if !found {
+			return nil, fmt.Errorf("%s:%d: expected pattern => replacement", path, lineNumber)
+		}
+		rule := &mappingRule{
+			template:     strings.TrimSpace(template),
+			placeholders: make(map[string]bool),
+			variadic:     make(map[string]bool),
+			spliced:      make(map[string]bool),
+		}
+		if rule.pattern, err = parser.ParseExpr(strings.TrimSpace(pattern)); err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid pattern: %v", path, lineNumber, err)
+		}
+		if rule.replacement, err = parser.ParseExprFrom(mapping.fset, "", rule.template, 0); err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid replacement: %v", path, lineNumber, err)
+		}
+		collectPlaceholders(rule.pattern, oldName, rule.placeholders)
+		if err := rule.checkVariadic(); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, lineNumber, err)
+		}
+		mapping.rules = append(mapping.rules, rule)
+	}
+	return mapping, scanner.Err()
+}
+
+// collectPlaceholders adds the identifiers of a pattern that are neither
+// the package, a selected name nor predeclared
+func collectPlaceholders(pattern ast.Node, pkgName string, placeholders map[string]bool) {
+	ast.Inspect(pattern, func(n ast.Node) bool {
+		switch x := n.(type) {
+		case *ast.SelectorExpr:
+			if ident, ok := x.X.(*ast.Ident); !ok || ident.Name != pkgName {
+				collectPlaceholders(x.X, pkgName, placeholders)
+			}
+			return false
+		case *ast.Ident:
+			if types.Universe.Lookup(x.Name) == nil {
+				placeholders[x.Name] = true
+			}
+		}
+		return true
+	})
+}
+
+// checkVariadic collects the variadic placeholders of the pattern and checks
+// that the replacement uses them as arguments only
+func (rule *mappingRule) checkVariadic() error {
+	ast.Inspect(rule.pattern, func(n ast.Node) bool {
+		if name, ok := rule.variadicArg(n); ok {
+			rule.variadic[name] = true
+		}
+		return true
+	})
+	if len(rule.variadic) == 0 {
+		return nil
+	}
+
+	arguments := make(map[*ast.Ident]bool)
+	var err error
+	var visit func(n ast.Node) bool
+	visit = func(n ast.Node) bool {
+		switch x := n.(type) {
+		case *ast.CallExpr:
+			for i, arg := range x.Args {
+				if ident, ok := arg.(*ast.Ident); ok && rule.variadic[ident.Name] {
+					arguments[ident] = true
+					if i < len(x.Args)-1 || !x.Ellipsis.IsValid() {
+						rule.spliced[ident.Name] = true
+					}
+				}
+			}
+		case *ast.SelectorExpr:
+			ast.Inspect(x.X, visit)
+			return false
+		case *ast.Ident:
+			if rule.variadic[x.Name] && !arguments[x] && err == nil {
+				err = fmt.Errorf("%s stands for arguments and can only be passed on as arguments", x.Name)
+			}
+		}
+		return true
+	}
+	ast.Inspect(rule.replacement, visit)
+	return err
+}
+
+// variadicArg returns the placeholder passed with ... as the last argument
+// of a call of the pattern
+func (rule *mappingRule) variadicArg(n ast.Node) (string, bool) {
+	call, ok := n.(*ast.CallExpr)
+	if !ok || !call.Ellipsis.IsValid() {
+		return "", false
+	}
+	ident, ok := call.Args[len(call.Args)-1].(*ast.Ident)
+	if !ok || !rule.placeholders[ident.Name] {
+		return "", false
+	}
+	return ident.Name, true
+}
+
+// bindings holds what the placeholders of a matched pattern stand for
+type bindings struct {
+	exprs map[string]ast.Expr
+	lists map[string]argList
+}
+
+// argList holds the arguments a variadic placeholder stands for, spread
+// when the call passed a slice with ...
+type argList struct {
+	args   []ast.Expr
+	spread bool
+}
+
+// importRewriter replaces an import path in one file. The selectors of the
+// old package are translated by the rules of the mapping, the others only
+// get the name of the new package.
+type importRewriter struct {
+	fset       *token.FileSet
+	content    []byte
+	mapping    *importMapping
+	unresolved map[*ast.Ident]bool
+
+	// oldName and newName are the default names of the packages, local and
+	// newLocal the names they have in the file
+	oldName, newName string
+	local, newLocal  string
+
+	// uses counts the references to the package left in the result, needed
+	// are the mapped imports the result uses
+	uses   int
+	needed map[string]mappedImport
+	// unmapped are the selected names of the old package no rule
+	// translated, only tracked with a mapping
+	unmapped map[string]bool
+}
+
+// rewriteImport returns content with oldImport replaced by newImport. It
+// reports false when the file doesn't import oldImport. With a mapping it
+// also returns the sorted names of the old package that no rule translated
+// and that only moved to the new package.
+func rewriteImport(filePath string, content []byte, oldImport, newImport string, mapping *importMapping) ([]byte, bool, []string, error) {
+	fset := token.NewFileSet()
+	node, err := parser.ParseFile(fset, filePath, content, parser.ParseComments)
+	if err != nil {
+		return nil, false, nil, fmt.Errorf("error parsing file %s: %v", filePath, err)
+	}
+
+	var spec *ast.ImportSpec
+	for _, imp := range node.Imports {
+		if imp.Path != nil && imp.Path.Value == `"`+oldImport+`"` {
+			spec = imp
+			break
+		}
+	}
+	if spec == nil {
+		return content, false, nil, nil
+	}
+
+	r := &importRewriter{
+		fset:       fset,
+		content:    content,
+		mapping:    mapping,
+		unresolved: make(map[*ast.Ident]bool),
+		oldName:    assumedName(oldImport),
+		newName:    assumedName(newImport),
+		needed:     make(map[string]mappedImport),
+	}
+	if mapping == nil {
+		r.mapping = &importMapping{}
+	} else {
+		r.unmapped = make(map[string]bool)
+	}
+	for _, ident := range node.Unresolved {
+		r.unresolved[ident] = true
+	}
+	r.local, r.newLocal = r.oldName, r.newName
+	if spec.Name != nil {
+		r.local, r.newLocal = spec.Name.Name, spec.Name.Name
+	}
+
+	var edits []textEdit
+	for _, decl := range node.Decls {
+		if gen, ok := decl.(*ast.GenDecl); ok && gen.Tok == token.IMPORT {
+			continue
+		}
+		edits = append(edits, r.edits(decl)...)
+	}
+	// Without a reference under the assumed name the package has another
+	// name, nothing can be told about its uses
+	r.uses = 1
+	for _, ident := range node.Unresolved {
+		if ident.Name == r.local {
+			r.uses = r.references(filePath, edits)
+			break
+		}
+	}
+	edits = append(edits, r.importEdit(node, spec, newImport))
+	sort.Slice(edits, func(i, j int) bool { return edits[i].start < edits[j].start })
+
+	formatted, err := format.Source(applyEdits(content, edits))
+	if err != nil {
+		return nil, false, nil, fmt.Errorf("error formatting updated content for %s: %v", filePath, err)
+	}
+	var unmapped []string
+	for name := range r.unmapped {
+		unmapped = append(unmapped, name)
+	}
+	sort.Strings(unmapped)
+	return formatted, true, unmapped, nil
+}
+
+// references counts the references to the package, under its old or its
+// new name, left once the edits are applied. A result that doesn't parse
+// counts as one, so the import is never dropped on a guess.
+func (r *importRewriter) references(filePath string, edits []textEdit) int {
+	node, err := parser.ParseFile(token.NewFileSet(), filePath, applyEdits(r.content, edits), 0)
+	if err != nil {
+		return 1
+	}
+	count := 0
+	for _, ident := range node.Unresolved {
+		if ident.Name == r.local || ident.Name == r.newLocal {
+			count++
+		}
+	}
+	return count
+}
+
+// applyEdits returns content with the sorted, disjoint edits applied
+func applyEdits(content []byte, edits []textEdit) []byte {
+	var updated []byte
+	last := 0
+	for _, edit := range edits {
+		updated = append(append(updated, content[last:edit.start]...), edit.text...)
+		last = edit.end
+	}
+	return append(updated, content[last:]...)
+}
+
+// assumedName returns the name a package is assumed to have from its import
+// path, the way goimports guesses it: major version elements and ".vN"
+// suffixes are no names, "github.com/foo/bar/v2" is bar and
+// "gopkg.in/yaml.v2" is yaml
+func assumedName(importPath string) string {
+	elements := strings.Split(importPath, "/")
+	name := elements[len(elements)-1]
+	if len(elements) > 1 && isMajorVersion(name) {
+		name = elements[len(elements)-2]
+	}
+	name = strings.TrimPrefix(name, "go-")
+	if i := strings.IndexAny(name, ".-"); i >= 0 {
+		name = name[:i]
+	}
+	return name
+}
+
+// isMajorVersion reports whether a path element is a major version like v2
+func isMajorVersion(element string) bool {
+	if len(element) < 2 || element[0] != 'v' {
+		return false
+	}
+	_, err := strconv.Atoi(element[1:])
+	return err == nil
+}
+
+// textEdit replaces the bytes between two offsets of the content
+type textEdit struct {
+	start, end int
+	text       string
+}
+
+func (r *importRewriter) offset(pos token.Pos) int {
+	return r.fset.Position(pos).Offset
+}
+
+// isOldPackage reports whether x refers to the replaced package
+func (r *importRewriter) isOldPackage(x ast.Expr) bool {
+	ident, ok := x.(*ast.Ident)
+	return ok && ident.Name == r.local && r.unresolved[ident]
+}
+
+// edits returns the edits translating the selectors of the old package
+// below node, outermost first
+func (r *importRewriter) edits(node ast.Node) []textEdit {
+	var edits []textEdit
+	ast.Inspect(node, func(n ast.Node) bool {
+		expr, ok := n.(ast.Expr)
+		if !ok {
+			return true
+		}
+		for _, rule := range r.mapping.rules {
+			if bindings, ok := r.match(rule, expr); ok {
+				edits = append(edits, textEdit{r.offset(expr.Pos()), r.offset(expr.End()), r.render(rule, bindings)})
+				return false
+			}
+		}
+		if sel, ok := expr.(*ast.SelectorExpr); ok && r.isOldPackage(sel.X) {
+			if r.unmapped != nil {
+				r.unmapped[sel.Sel.Name] = true
+			}
+			if r.local != r.newLocal {
+				edits = append(edits, textEdit{r.offset(sel.X.Pos()), r.offset(sel.X.End()), r.newLocal})
+			}
+			return false
+		}
+		return true
+	})
+	return edits
+}
+
+// rewrite returns the source of node with its selectors translated
+func (r *importRewriter) rewrite(node ast.Node) string {
+	start, end := r.offset(node.Pos()), r.offset(node.End())
+	var out strings.Builder
+	last := start
+	for _, edit := range r.edits(node) {
+		out.Write(r.content[last:edit.start])
+		out.WriteString(edit.text)
+		last = edit.end
+	}
+	out.Write(r.content[last:end])
+	return out.String()
+}
+
+// match reports whether expr has the form of the pattern of rule and
+// returns what the placeholders stand for
+func (r *importRewriter) match(rule *mappingRule, expr ast.Expr) (bindings, bool) {
+	bound := bindings{exprs: make(map[string]ast.Expr), lists: make(map[string]argList)}
+	var match func(pattern, expr ast.Expr) bool
+	match = func(pattern, expr ast.Expr) bool {
+		switch p := pattern.(type) {
+		case *ast.Ident:
+			if !rule.placeholders[p.Name] {
+				ident, ok := expr.(*ast.Ident)
+				return ok && ident.Name == p.Name
+			}
+			// A placeholder used twice has to stand for the same expression
+			if previous, found := bound.exprs[p.Name]; found {
+				return types.ExprString(previous) == types.ExprString(expr)
+			}
+			bound.exprs[p.Name] = expr
+			return true
+		case *ast.SelectorExpr:
+			sel, ok := expr.(*ast.SelectorExpr)
+			if !ok || sel.Sel.Name != p.Sel.Name {
+				return false
+			}
+			if ident, ok := p.X.(*ast.Ident); ok && ident.Name == r.oldName {
+				return r.isOldPackage(sel.X)
+			}
+			return match(p.X, sel.X)
+		case *ast.CallExpr:
+			call, ok := expr.(*ast.CallExpr)
+			if !ok || !match(p.Fun, call.Fun) {
+				return false
+			}
+			fixed := p.Args
+			if name, variadic := rule.variadicArg(p); variadic {
+				fixed = p.Args[:len(p.Args)-1]
+				// A spread slice can't be spliced among other arguments
+				spread := call.Ellipsis.IsValid()
+				if len(call.Args) < len(fixed) || spread && (len(call.Args) == len(fixed) || rule.spliced[name]) {
+					return false
+				}
+				if _, found := bound.lists[name]; found {
+					return false
+				}
+				bound.lists[name] = argList{call.Args[len(fixed):], spread}
+			} else if len(call.Args) != len(p.Args) || call.Ellipsis.IsValid() != p.Ellipsis.IsValid() {
+				return false
+			}
+			for i := range fixed {
+				if !match(fixed[i], call.Args[i]) {
+					return false
+				}
+			}
+			return true
+		case *ast.ParenExpr:
+			paren, ok := expr.(*ast.ParenExpr)
+			return ok && match(p.X, paren.X)
+		case *ast.StarExpr:
+			star, ok := expr.(*ast.StarExpr)
+			return ok && match(p.X, star.X)
+		case *ast.UnaryExpr:
+			unary, ok := expr.(*ast.UnaryExpr)
+			return ok && unary.Op == p.Op && match(p.X, unary.X)
+		case *ast.BinaryExpr:
+			binary, ok := expr.(*ast.BinaryExpr)
+			return ok && binary.Op == p.Op && match(p.X, binary.X) && match(p.Y, binary.Y)
+		case *ast.IndexExpr:
+			index, ok := expr.(*ast.IndexExpr)
+			return ok && match(p.X, index.X) && match(p.Index, index.Index)
+		case *ast.BasicLit:
+			lit, ok := expr.(*ast.BasicLit)
+			return ok && lit.Kind == p.Kind && lit.Value == p.Value
+		default:
+			return types.ExprString(pattern) == types.ExprString(expr)
+		}
+	}
+	if !match(rule.pattern, expr) {
+		return bindings{}, false
+	}
+	return bound, true
+}
+
+// render returns the replacement of rule with the placeholders substituted
+// and the packages named as in the file
+func (r *importRewriter) render(rule *mappingRule, bound bindings) string {
+	offset := func(pos token.Pos) int { return r.mapping.fset.Position(pos).Offset }
+	var edits []textEdit
+	arguments := make(map[ast.Expr]bool)
+	// splices are the edits passing on the arguments of variadic
+	// placeholders, their text is filled in when the placeholder is visited
+	splices := make(map[*ast.Ident]textEdit)
+	var visit func(n ast.Node) bool
+	visit = func(n ast.Node) bool {
+		switch x := n.(type) {
+		case *ast.CallExpr:
+			for i, arg := range x.Args {
+				arguments[arg] = true
+				if ident, ok := arg.(*ast.Ident); ok && rule.variadic[ident.Name] {
+					splices[ident] = r.splice(x, i, bound.lists[ident.Name], offset)
+				}
+			}
+		case *ast.SelectorExpr:
+			// The selected name is never a placeholder
+			ident, ok := x.X.(*ast.Ident)
+			if !ok || rule.placeholders[ident.Name] {
+				ast.Inspect(x.X, visit)
+				return false
+			}
+			if ident.Name == r.newName {
+				if r.newLocal != r.newName {
+					edits = append(edits, textEdit{offset(ident.Pos()), offset(ident.End()), r.newLocal})
+				}
+			}
+			for _, imp := range r.mapping.imports {
+				if imp.name == ident.Name {
+					r.needed[imp.path] = imp
+				}
+			}
+			return false
+		case *ast.Ident:
+			if edit, found := splices[x]; found {
+				edits = append(edits, edit)
+			} else if expr, found := bound.exprs[x.Name]; found {
+				text := r.rewrite(expr)
+				if _, binary := expr.(*ast.BinaryExpr); binary && !arguments[x] {
+					text = "(" + text + ")"
+				}
+				edits = append(edits, textEdit{offset(x.Pos()), offset(x.End()), text})
+			}
+		}
+		return true
+	}
+	ast.Inspect(rule.replacement, visit)
+
+	var out strings.Builder
+	last := 0
+	for _, edit := range edits {
+		out.WriteString(rule.template[last:edit.start])
+		out.WriteString(edit.text)
+		last = edit.end
+	}
+	out.WriteString(rule.template[last:])
+	if _, binary := rule.replacement.(*ast.BinaryExpr); binary {
+		return "(" + out.String() + ")"
+	}
+	return out.String()
+}
+
+// splice returns the edit of the template replacing argument i of call, a
+// variadic placeholder, with the arguments it stands for. The comma next to
+// the placeholder goes when it stands for no arguments.
+func (r *importRewriter) splice(call *ast.CallExpr, i int, list argList, offset func(token.Pos) int) textEdit {
+	arg := call.Args[i]
+	end := offset(arg.End())
+	if i == len(call.Args)-1 && call.Ellipsis.IsValid() {
+		end = offset(call.Ellipsis) + len("...")
+	}
+	var texts []string
+	for _, expr := range list.args {
+		texts = append(texts, r.rewrite(expr))
+	}
+	text := strings.Join(texts, ", ")
+	if list.spread {
+		text += "..."
+	}
+
+	switch {
+	case i < len(call.Args)-1:
+		if len(texts) > 0 {
+			text += ", "
+		}
+		return textEdit{offset(arg.Pos()), offset(call.Args[i+1].Pos()), text}
+	case i > 0:
+		if len(texts) > 0 {
+			text = ", " + text
+		}
+		return textEdit{offset(call.Args[i-1].End()), end, text}
+	default:
+		return textEdit{offset(arg.Pos()), end, text}
+	}
+}
+
+// importEdit returns the edit of the import of the old package: its path
+// changes, the mapped imports the translations use are added next to it
+// and it is dropped only when no reference to the package is left. It has
+// to run after the other edits counted the references.
+func (r *importRewriter) importEdit(node *ast.File, spec *ast.ImportSpec, newImport string) textEdit {
+	var specs []string
+	if r.uses > 0 || spec.Name != nil && (spec.Name.Name == "_" || spec.Name.Name == ".") {
+		specs = append(specs, string(r.content[r.offset(spec.Pos()):r.offset(spec.Path.Pos())])+strconv.Quote(newImport))
+	}
+	var paths []string
+	for path := range r.needed {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		imported := false
+		for _, imp := range node.Imports {
+			imported = imported || imp.Path.Value == strconv.Quote(path)
+		}
+		if imported {
+			continue
+		}
+		if imp := r.needed[path]; imp.name != assumedName(path) {
+			specs = append(specs, imp.name+" "+strconv.Quote(path))
+		} else {
+			specs = append(specs, strconv.Quote(path))
+		}
+	}
+
+	var decl *ast.GenDecl
+	for _, d := range node.Decls {
+		if gen, ok := d.(*ast.GenDecl); ok && gen.Tok == token.IMPORT && gen.Pos() <= spec.Pos() && spec.End() <= gen.End() {
+			decl = gen
+		}
+	}
+	if decl.Lparen.IsValid() && len(decl.Specs) > 1 {
+		start, end := r.offset(spec.Pos()), r.offset(spec.End())
+		if len(specs) == 0 {
+			// Drop the line of the spec
+			for start > 0 && r.content[start-1] != '\n' {
+				start--
+			}
+			for end < len(r.content) && r.content[end] != '\n' {
+				end++
+			}
+			return textEdit{start, min(end+1, len(r.content)), ""}
+		}
+		return textEdit{start, end, strings.Join(specs, "\n")}
+	}
+
+	start, end := r.offset(decl.Pos()), r.offset(decl.End())
+	switch len(specs) {
+	case 0:
+		return textEdit{start, end, ""}
+	case 1:
+		return textEdit{start, end, "import " + specs[0]}
+	default:
+		return textEdit{start, end, "import (\n" + strings.Join(specs, "\n") + "\n)"}
+	}
+}
+
+// replaceImport replaces an import path in every Go file of the module,
+// translating the selectors of the old package with a mapping file. It
+// takes the current directory, the old and the new import path, the
+// mapping file or "" and "preview" for a unified diff of the changes or
+// "apply" to write them.
+func replaceImport(v *nvim.Nvim, args []string) (string, error) {
+	if len(args) != 5 {
+		return "", fmt.Errorf("expected 5 arguments: currentDir, oldImport, newImport, mappingFile, mode")
+	}
+	currentDir, oldImport, newImport, mappingFile, mode := args[0], args[1], args[2], args[3], args[4]
+	if mode != "preview" && mode != "apply" {
+		return "", fmt.Errorf("unknown mode %q, expected preview or apply", mode)
+	}
+
+	projectRoot := currentDir
+	for {
+		if _, err := os.Stat(filepath.Join(projectRoot, "go.mod")); err == nil {
+			break
+		}
+		parent := filepath.Dir(projectRoot)
+		if parent == projectRoot {
+			return "", fmt.Errorf("go.mod not found in project hierarchy")
+		}
+		projectRoot = parent
+	}
+
+	var mapping *importMapping
+	if mappingFile != "" {
+		var err error
+		if mapping, err = loadMapping(mappingFile, assumedName(oldImport)); err != nil {
+			return "", fmt.Errorf("failed to load mapping: %v", err)
+		}
+	}
+
+	goFiles, err := findAllGoFiles(projectRoot)
+	if err != nil {
+		return "", fmt.Errorf("failed to find Go files: %v", err)
+	}
+
+	var preview strings.Builder
+	var errors []string
+	// unmapped lists the files using each name of the old package that no
+	// rule translated
+	unmapped := make(map[string][]string)
+	changed := 0
+	for _, file := range goFiles {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			errors = append(errors, fmt.Sprintf("Failed to read %s: %v", file, err))
+			continue
+		}
+		updated, ok, names, err := rewriteImport(file, content, oldImport, newImport, mapping)
+		if err != nil {
+			errors = append(errors, fmt.Sprintf("Failed to update %s: %v", file, err))
+			continue
+		}
+		rel, _ := filepath.Rel(projectRoot, file)
+		for _, name := range names {
+			unmapped[name] = append(unmapped[name], filepath.ToSlash(rel))
+		}
+		if !ok || string(updated) == string(content) {
+			continue
+		}
+		changed++
+		if mode == "preview" {
+			preview.WriteString(unifiedDiff(filepath.ToSlash(rel), content, updated))
+			continue
+		}
+		if err := os.WriteFile(file, updated, 0o644); err != nil {
+			errors = append(errors, fmt.Sprintf("Failed to write %s: %v", file, err))
+		}
+	}
+
+	// Names without a rule only moved to the new package, which may not
+	// have them
+	var names, notes []string
+	for name := range unmapped {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		notes = append(notes, fmt.Sprintf("No rule for %s.%s in %s", assumedName(oldImport), name, strings.Join(unmapped[name], ", ")))
+	}
+
+	if mode == "preview" {
+		for _, message := range append(errors, notes...) {
+			fmt.Fprintf(&preview, "# %s\n", message)
+		}
+		return preview.String(), nil
+	}
+	result := fmt.Sprintf("Import replaced in %d files, run go mod tidy to update go.mod", changed)
+	if len(errors) > 0 {
+		result = fmt.Sprintf("Import replaced in %d files with some errors:\n%s", changed, strings.Join(errors, "\n"))
+	}
+	if len(notes) > 0 {
+		result += "\n" + strings.Join(notes, "\n")
+	}
+	return result, nil
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRewriteImport(t *testing.T) {
+	tests := []struct {
+		name      string
+		code      string
+		oldImport string
+		newImport string
+		// mapping is the content of a mapping file, unmapped the names it
+		// leaves untranslated
+		mapping  string
+		expected string
+		unmapped []string
+	}{
+		{
+			name: "Major version path",
+			code: `package a
+
+import "github.com/foo/bar/v2"
+
+func f() { bar.New() }
+`,
+			oldImport: "github.com/foo/bar/v2",
+			newImport: "github.com/foo/bar/v3",
+			expected: `package a
+
+import "github.com/foo/bar/v3"
+
+func f() { bar.New() }
+`,
+		},
+		{
+			name: "gopkg.in version suffix",
+			code: `package a
+
+import "gopkg.in/yaml.v2"
+
+func f() { yaml.Marshal(nil) }
+`,
+			oldImport: "gopkg.in/yaml.v2",
+			newImport: "gopkg.in/yaml.v3",
+			expected: `package a
+
+import "gopkg.in/yaml.v3"
+
+func f() { yaml.Marshal(nil) }
+`,
+		},
+		{
+			name: "Renamed package",
+			code: `package a
+
+import (
+	"fmt"
+
+	"example.com/m/old/util"
+)
+
+func f() {
+	util.Do()
+	fmt.Println()
+}
+`,
+			oldImport: "example.com/m/old/util",
+			newImport: "example.com/m/lib/helpers/v2",
+			expected: `package a
+
+import (
+	"fmt"
+
+	"example.com/m/lib/helpers/v2"
+)
+
+func f() {
+	helpers.Do()
+	fmt.Println()
+}
+`,
+		},
+		{
+			name: "Package named unlike its path",
+			code: `package a
+
+import "example.com/m/go-thing.git"
+
+func f() { other.Do() }
+`,
+			oldImport: "example.com/m/go-thing.git",
+			newImport: "example.com/m/thing",
+			expected: `package a
+
+import "example.com/m/thing"
+
+func f() { other.Do() }
+`,
+		},
+		{
+			name: "Mapping with placeholders",
+			code: `package a
+
+import "github.com/pkg/errors"
+
+func f(err error, msg string) error {
+	return errors.Wrap(err, msg)
+}
+`,
+			oldImport: "github.com/pkg/errors",
+			newImport: "errors",
+			mapping: `import "fmt"
+errors.Wrap(err, msg) => fmt.Errorf("%s: %w", msg, err)
+`,
+			expected: `package a
+
+import "fmt"
+
+func f(err error, msg string) error {
+	return fmt.Errorf("%s: %w", msg, err)
+}
+`,
+		},
+		{
+			name: "Nested matches",
+			code: `package a
+
+import "github.com/pkg/errors"
+
+func f(err error) error {
+	return errors.Wrap(errors.Wrap(err, "inner"), "outer")
+}
+`,
+			oldImport: "github.com/pkg/errors",
+			newImport: "errors",
+			mapping: `import "fmt"
+errors.Wrap(err, msg) => fmt.Errorf("%s: %w", msg, err)
+`,
+			expected: `package a
+
+import "fmt"
+
+func f(err error) error {
+	return fmt.Errorf("%s: %w", "outer", fmt.Errorf("%s: %w", "inner", err))
+}
+`,
+		},
+		{
+			name: "Variadic placeholder",
+			code: `package a
+
+import "github.com/pkg/errors"
+
+func f(err error, name string) error {
+	if name == "" {
+		return errors.Wrapf(err, "failed")
+	}
+	return errors.Wrapf(err, "read %s", name)
+}
+`,
+			oldImport: "github.com/pkg/errors",
+			newImport: "errors",
+			mapping: `import "fmt"
+errors.Wrapf(err, format, args...) => fmt.Errorf(format+": %w", args, err)
+`,
+			expected: `package a
+
+import "fmt"
+
+func f(err error, name string) error {
+	if name == "" {
+		return fmt.Errorf("failed"+": %w", err)
+	}
+	return fmt.Errorf("read %s"+": %w", name, err)
+}
+`,
+		},
+		{
+			name: "File already imports fmt",
+			code: `package a
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+func f(err error, values []any) error {
+	fmt.Println("failed")
+	if err == nil {
+		return errors.New("no error")
+	}
+	if values != nil {
+		return errors.Wrapf(err, "%v", values...)
+	}
+	return errors.Wrap(err, "failed")
+}
+`,
+			oldImport: "github.com/pkg/errors",
+			newImport: "errors",
+			mapping: `import "fmt"
+errors.Wrap(err, msg) => fmt.Errorf("%s: %w", msg, err)
+errors.Wrapf(err, format, args...) => fmt.Errorf(format+": %w", args, err)
+`,
+			expected: `package a
+
+import (
+	"fmt"
+
+	"errors"
+)
+
+func f(err error, values []any) error {
+	fmt.Println("failed")
+	if err == nil {
+		return errors.New("no error")
+	}
+	if values != nil {
+		return errors.Wrapf(err, "%v", values...)
+	}
+	return fmt.Errorf("%s: %w", "failed", err)
+}
+`,
+			unmapped: []string{"New", "Wrapf"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mapping *importMapping
+			if tt.mapping != "" {
+				path := filepath.Join(t.TempDir(), "mapping.txt")
+				if err := os.WriteFile(path, []byte(tt.mapping), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				var err error
+				if mapping, err = loadMapping(path, assumedName(tt.oldImport)); err != nil {
+					t.Fatalf("loadMapping failed: %v", err)
+				}
+			}
+			got, changed, unmapped, err := rewriteImport("a.go", []byte(tt.code), tt.oldImport, tt.newImport, mapping)
+			if err != nil {
+				t.Fatalf("rewriteImport failed: %v", err)
+			}
+			if !changed {
+				t.Fatalf("rewriteImport reported no change")
+			}
+			if string(got) != tt.expected {
+				t.Errorf("Unexpected result:\n%s\nwant:\n%s", got, tt.expected)
+			}
+			if !reflect.DeepEqual(unmapped, tt.unmapped) {
+				t.Errorf("Unexpected unmapped names %v, want %v", unmapped, tt.unmapped)
+			}
+		})
+	}
+}
+
+func TestAssumedName(t *testing.T) {
+	tests := map[string]string{
+		"errors":                       "errors",
+		"github.com/pkg/errors":        "errors",
+		"github.com/foo/bar/v2":        "bar",
+		"gopkg.in/yaml.v2":             "yaml",
+		"github.com/mattn/go-sqlite3":  "sqlite3",
+		"github.com/go-chi/chi/v5":     "chi",
+		"github.com/foo/go-bar.v1/baz": "baz",
+	}
+	for path, want := range tests {
+		if got := assumedName(path); got != want {
+			t.Errorf("assumedName(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Backend turns the inferred rules, written in go-playground/validator
+// syntax, into what a validation library reads. The project config picks the
+// backend with "backend: validate|binding|ozzo".
+type Backend interface {
+	// Field applies the rules of one field
+	Field(field *ast.Field, structName, validate string, policy MergePolicy) error
+	// Finish writes what the backend collected and returns a message for
+	// the user, "" when there is nothing to say
+	Finish() (string, error)
+	// WholeFile reports whether the backend always works on every struct
+	// of the file, e.g. because it regenerates a file of its own
+	WholeFile() bool
+}
+
+// newBackend returns the backend called name, "" is the validate tag
+func newBackend(name, filePath string, f *ast.File, resolver *typeResolver) (Backend, error) {
+	switch name {
+	case "", "validate":
+		return &tagBackend{key: "validate"}, nil
+	case "binding":
+		// gin reads the validator syntax from its own key
+		return &tagBackend{key: "binding"}, nil
+	case "ozzo":
+		return &ozzoBackend{filePath: filePath, file: f, resolver: resolver}, nil
+	}
+	return nil, fmt.Errorf("unknown backend %q, expected validate, binding or ozzo", name)
+}
+
+// tagBackend writes the rules into a struct tag key
+type tagBackend struct {
+	key string
+}
+
+func (b *tagBackend) Field(field *ast.Field, structName, validate string, policy MergePolicy) error {
+	tag, err := parseTagLiteral(field.Tag)
+	if err != nil {
+		return err
+	}
+	if existing, found := tag.Get(b.key); found {
+		validate = mergeValidate(existing, validate, policy)
+	}
+	tag.Set(b.key, validate)
+	field.Tag.Value = tag.Literal()
+	return nil
+}
+
+func (b *tagBackend) Finish() (string, error) {
+	return "", nil
+}
+
+func (b *tagBackend) WholeFile() bool {
+	return false
+}
+
+// ozzoBackend generates Validate() methods calling ozzo-validation into
+// <file>_ozzo.go. Tags are left alone; a validate tag written by hand is
+// merged with the inferred rules like in the tag backends.
+type ozzoBackend struct {
+	filePath string
+	file     *ast.File
+	resolver *typeResolver
+	// structs holds the struct names in order of appearance
+	structs []string
+	fields  map[string][]ozzoField
+}
+
+type ozzoField struct {
+	names []string
+	rules []string
+}
+
+func (b *ozzoBackend) Field(field *ast.Field, structName, validate string, policy MergePolicy) error {
+	// Anonymous structs and embedded fields get no rules of their own
+	if structName == "" || len(field.Names) == 0 {
+		return nil
+	}
+	tag, err := parseTagLiteral(field.Tag)
+	if err != nil {
+		return err
+	}
+	if existing, found := tag.Get("validate"); found {
+		validate = mergeValidate(existing, validate, policy)
+	}
+
+	if b.fields == nil {
+		b.fields = make(map[string][]ozzoField)
+	}
+	if _, seen := b.fields[structName]; !seen {
+		b.structs = append(b.structs, structName)
+	}
+	var names []string
+	for _, name := range field.Names {
+		if name.Name != "_" {
+			names = append(names, name.Name)
+		}
+	}
+	rules := ozzoRules(splitValidate(validate), b.resolver.describe(field.Type))
+	b.fields[structName] = append(b.fields[structName], ozzoField{names, rules})
+	return nil
+}
+
+func (b *ozzoBackend) WholeFile() bool {
+	return true
+}
+
+func (b *ozzoBackend) Finish() (string, error) {
+	outPath := strings.TrimSuffix(b.filePath, ".go") + "_ozzo.go"
+	existing, err := os.ReadFile(outPath)
+	if err == nil && !bytes.HasPrefix(existing, []byte(generatedHeader)) {
+		return "", fmt.Errorf("%s exists and was not generated", outPath)
+	}
+
+	handWritten := handWrittenValidate(b.resolver, outPath)
+	var body bytes.Buffer
+	usesIs := false
+	for _, name := range b.structs {
+		if handWritten[name] {
+			continue
+		}
+		receiver := strings.ToLower(name[:1])
+		fmt.Fprintf(&body, "// Validate checks %s with ozzo-validation\n", name)
+		fmt.Fprintf(&body, "func (%s %s) Validate() error {\n", receiver, name)
+		fmt.Fprintf(&body, "return validation.ValidateStruct(&%s,\n", receiver)
+		for _, field := range b.fields[name] {
+			for _, fieldName := range field.names {
+				call := []string{"&" + receiver + "." + fieldName}
+				for _, rule := range field.rules {
+					if strings.HasPrefix(rule, "//") {
+						fmt.Fprintf(&body, "%s\n", rule)
+						continue
+					}
+					usesIs = usesIs || strings.Contains(rule, "is.")
+					call = append(call, rule)
+				}
+				fmt.Fprintf(&body, "validation.Field(%s),\n", strings.Join(call, ", "))
+			}
+		}
+		fmt.Fprintf(&body, ")\n}\n\n")
+	}
+
+	if body.Len() == 0 {
+		if existing != nil {
+			return fmt.Sprintf("No structs left, removed %s", outPath), os.Remove(outPath)
+		}
+		return "", nil
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "%s\n\npackage %s\n\nimport (\n", generatedHeader, b.file.Name.Name)
+	fmt.Fprintf(&out, "validation %q\n", "github.com/go-ozzo/ozzo-validation/v4")
+	if usesIs {
+		fmt.Fprintf(&out, "%q\n", "github.com/go-ozzo/ozzo-validation/v4/is")
+	}
+	fmt.Fprintf(&out, ")\n\n")
+	out.Write(body.Bytes())
+
+	content, err := format.Source(out.Bytes())
+	if err != nil {
+		return "", fmt.Errorf("generated code does not compile: %v", err)
+	}
+	if bytes.Equal(content, existing) {
+		return fmt.Sprintf("%s is up to date", outPath), nil
+	}
+	if err := os.WriteFile(outPath, content, 0o644); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Wrote %s", outPath), nil
+}
+
+// ozzoStringRules are the validators with an equivalent in the is package
+var ozzoStringRules = map[string]string{
+	"email":       "is.Email",
+	"url":         "is.URL",
+	"uri":         "is.RequestURI",
+	"http_url":    "is.URL",
+	"uuid":        "is.UUID",
+	"uuid3":       "is.UUIDv3",
+	"uuid4":       "is.UUIDv4",
+	"uuid5":       "is.UUIDv5",
+	"alpha":       "is.Alpha",
+	"alphanum":    "is.Alphanumeric",
+	"numeric":     "is.Float",
+	"number":      "is.Digit",
+	"ip":          "is.IP",
+	"ipv4":        "is.IPv4",
+	"ipv6":        "is.IPv6",
+	"mac":         "is.MAC",
+	"json":        "is.JSON",
+	"hexadecimal": "is.Hexadecimal",
+	"hexcolor":    "is.HexColor",
+	"lowercase":   "is.LowerCase",
+	"uppercase":   "is.UpperCase",
+	"base64":      "is.Base64",
+	"e164":        "is.E164",
+	"semver":      "is.Semver",
+	"latitude":    "is.Latitude",
+	"longitude":   "is.Longitude",
+	"ascii":       "is.ASCII",
+	"printascii":  "is.PrintableASCII",
+	"multibyte":   "is.Multibyte",
+	"fqdn":        "is.DNSName",
+	"hostname":    "is.Host",
+	"credit_card": "is.CreditCard",
+	"isbn":        "is.ISBN",
+	"isbn10":      "is.ISBN10",
+	"isbn13":      "is.ISBN13",
+	"ssn":         "is.SSN",
+	"datauri":     "is.DataURI",
+}
+
+// ozzoRules translates validator items into ozzo-validation rules. Items
+// without an equivalent become comments, so they are not lost silently.
+func ozzoRules(items []string, desc *typeDesc) []string {
+	for desc != nil && desc.Kind == "pointer" && desc.Elem != nil {
+		desc = desc.Elem
+	}
+
+	var rules []string
+	minLength, maxLength := "", ""
+	for i, item := range items {
+		name, param, _ := strings.Cut(item, "=")
+		switch {
+		case name == "omitempty":
+			// ozzo rules skip empty values, only Required checks them
+		case name == "required":
+			if desc == nil || desc.Kind != "struct" {
+				rules = append(rules, "validation.Required")
+			}
+		case name == "dive":
+			elem := (*typeDesc)(nil)
+			if desc != nil {
+				elem = desc.Elem
+			}
+			if len(items) > i+1 && items[i+1] == "keys" {
+				return append(rules, "// map keys have no ozzo equivalent: "+strings.Join(items[i:], ","))
+			}
+			var each []string
+			for _, rule := range ozzoRules(items[i+1:], elem) {
+				if strings.HasPrefix(rule, "//") {
+					rules = append(rules, rule)
+				} else {
+					each = append(each, rule)
+				}
+			}
+			if len(each) > 0 {
+				rules = append(rules, "validation.Each("+strings.Join(each, ", ")+")")
+			}
+			return finishLength(rules, minLength, maxLength)
+		case isNumber(desc) && desc.Kind == "time.Duration" && name != "oneof":
+			rules = append(rules, "// "+item+" has no ozzo equivalent for durations")
+		case (name == "gte" || name == "min" || name == "gt") && param != "" && isNumber(desc):
+			rule := "validation.Min(" + ozzoNumber(param, desc) + ")"
+			if name == "gt" {
+				rule += ".Exclusive()"
+			}
+			rules = append(rules, rule)
+		case (name == "lte" || name == "max" || name == "lt") && param != "" && isNumber(desc):
+			rule := "validation.Max(" + ozzoNumber(param, desc) + ")"
+			if name == "lt" {
+				rule += ".Exclusive()"
+			}
+			rules = append(rules, rule)
+		case (name == "gte" || name == "min") && isInt(param):
+			minLength = param
+		case name == "gt" && isInt(param):
+			n, _ := strconv.Atoi(param)
+			minLength = strconv.Itoa(n + 1)
+		case (name == "lte" || name == "max") && isInt(param):
+			maxLength = param
+		case name == "lt" && isInt(param):
+			n, _ := strconv.Atoi(param)
+			maxLength = strconv.Itoa(n - 1)
+		case name == "len" && isInt(param):
+			minLength, maxLength = param, param
+		case name == "oneof" && param != "":
+			var values []string
+			for _, value := range oneofValues.FindAllString(param, -1) {
+				value = strings.Trim(value, "'")
+				if !isNumber(desc) {
+					value = strconv.Quote(value)
+				}
+				values = append(values, value)
+			}
+			rules = append(rules, "validation.In("+strings.Join(values, ", ")+")")
+		case ozzoStringRules[name] != "" && param == "" && (desc == nil || isString(desc)):
+			rules = append(rules, ozzoStringRules[name])
+		default:
+			rules = append(rules, "// "+item+" has no ozzo equivalent")
+		}
+	}
+	return finishLength(rules, minLength, maxLength)
+}
+
+// finishLength adds the Length rule collected from min and max items
+func finishLength(rules []string, minLength, maxLength string) []string {
+	if minLength == "" && maxLength == "" {
+		return rules
+	}
+	if minLength == "" {
+		minLength = "0"
+	}
+	if maxLength == "" {
+		maxLength = "0"
+	}
+	return append(rules, "validation.Length("+minLength+", "+maxLength+")")
+}
+
+// ozzoNumber writes a threshold of the field's kind, ozzo compares floats
+// and unsigned numbers only with thresholds of the same kind
+func ozzoNumber(param string, desc *typeDesc) string {
+	switch {
+	case strings.HasPrefix(desc.Kind, "float"):
+		if !strings.ContainsAny(param, ".eE") {
+			param += ".0"
+		}
+		return param
+	case strings.HasPrefix(desc.Kind, "uint"):
+		return "uint64(" + param + ")"
+	}
+	return param
+}
+
+func isInt(param string) bool {
+	_, err := strconv.Atoi(param)
+	return err == nil
+}
@@ -11,10 +11,10 @@ buildGoModule rec {
   # vendorSha256 = lib.fakeSha256;
 
   # vendorHash = lib.fakeHash;
-  vendorHash = "sha256-iXksfx4VlVfX+Q8/A3lqWgcltcaW8oHaQxsYM9kfTmo=";
+  vendorHash = "sha256-eYzloS3Njnqa3GmO7M/4baJjvWB5iArEMLr0dk1Akgw=";
 
   buildPhase = ''
-    go build -mod=vendor -o ${pname} main.go
+    go build -mod=vendor -o ${pname} .
   '';
 
   installPhase = ''
@@ -0,0 +1,563 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/neovim/go-client/nvim"
+)
+
+// generatedHeader marks files written by generateValidate, other files are
+// never overwritten
+const generatedHeader = "// Code generated by golang_validator_plugin_nvim. DO NOT EDIT."
+
+// generateValidate writes Validate() methods for the structs of the current
+// buffer into <file>_validate.go. The methods check the validate tags with
+// plain Go code, so they need no reflection at run time.
+func generateValidate(v *nvim.Nvim, args []string) (string, error) {
+	if len(args) != 1 {
+		return "", fmt.Errorf("expected the file path, got %d arguments", len(args))
+	}
+	filePath := args[0]
+	if strings.HasSuffix(filePath, "_validate.go") {
+		return "", fmt.Errorf("%s is a generated file", filePath)
+	}
+
+	buffer, err := v.CurrentBuffer()
+	if err != nil {
+		return "", fmt.Errorf("failed to get current buffer: %v", err)
+	}
+	lines, err := v.BufferLines(buffer, 0, -1, true)
+	if err != nil {
+		return "", fmt.Errorf("failed to get buffer lines: %v", err)
+	}
+
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, filePath, bytes.Join(lines, []byte{'\n'}), parser.ParseComments)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse file: %v", err)
+	}
+
+	outPath := strings.TrimSuffix(filePath, ".go") + "_validate.go"
+	existing, err := os.ReadFile(outPath)
+	if err == nil && !bytes.HasPrefix(existing, []byte(generatedHeader)) {
+		return "", fmt.Errorf("%s exists and was not generated", outPath)
+	}
+
+	content, err := generateValidateFile(fset, f, newTypeResolver(fset, f, filePath), outPath)
+	if err != nil {
+		return "", err
+	}
+	if content == nil {
+		if existing != nil {
+			if err := os.Remove(outPath); err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("No validate tags left, removed %s", outPath), nil
+		}
+		return "No validate tags found", nil
+	}
+	if bytes.Equal(content, existing) {
+		return fmt.Sprintf("%s is up to date", outPath), nil
+	}
+	if err := os.WriteFile(outPath, content, 0o644); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Wrote %s", outPath), nil
+}
+
+// generateValidateFile returns the generated file for f, or nil when no
+// struct has validate tags. outPath is where it goes, the Validate methods
+// it holds now are replaced.
+func generateValidateFile(fset *token.FileSet, f *ast.File, resolver *typeResolver, outPath string) ([]byte, error) {
+	specs := validatedStructs(f, handWrittenValidate(resolver, outPath))
+	if len(specs) == 0 {
+		return nil, nil
+	}
+
+	gen := &validateGenerator{
+		resolver: resolver,
+		imports:  make(map[string]bool),
+		structs:  make(map[string]bool),
+	}
+	for _, spec := range specs {
+		gen.structs[spec.Name.Name] = true
+	}
+	var body bytes.Buffer
+	for _, spec := range specs {
+		gen.out = &body
+		gen.writeStruct(spec)
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "%s\n\npackage %s\n\n", generatedHeader, f.Name.Name)
+	if len(gen.imports) > 0 {
+		var paths []string
+		for path := range gen.imports {
+			paths = append(paths, strconv.Quote(path))
+		}
+		sort.Strings(paths)
+		fmt.Fprintf(&out, "import (\n%s\n)\n\n", strings.Join(paths, "\n"))
+	}
+	out.Write(body.Bytes())
+
+	formatted, err := format.Source(out.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("generated code does not compile: %v", err)
+	}
+	return formatted, nil
+}
+
+// validatedStructs returns the non-generic struct types with validate tags
+// that have no hand-written Validate method, in declaration order
+func validatedStructs(f *ast.File, handWritten map[string]bool) []*ast.TypeSpec {
+	var specs []*ast.TypeSpec
+	for _, decl := range f.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			typeSpec := spec.(*ast.TypeSpec)
+			structType, ok := typeSpec.Type.(*ast.StructType)
+			if !ok || typeSpec.TypeParams != nil || handWritten[typeSpec.Name.Name] {
+				continue
+			}
+			if hasValidateTags(structType) {
+				specs = append(specs, typeSpec)
+			}
+		}
+	}
+	return specs
+}
+
+// handWrittenValidate returns the types with a Validate method in any file
+// of the package but outPath, the generated file about to be replaced
+func handWrittenValidate(resolver *typeResolver, outPath string) map[string]bool {
+	handWritten := make(map[string]bool)
+	for _, file := range resolver.files {
+		if sameFile(resolver.fset.File(file.Pos()).Name(), outPath) {
+			continue
+		}
+		for _, decl := range file.Decls {
+			if fn, ok := decl.(*ast.FuncDecl); ok && fn.Recv != nil && fn.Name.Name == "Validate" {
+				handWritten[embeddedName(fn.Recv.List[0].Type)] = true
+			}
+		}
+	}
+	return handWritten
+}
+
+func hasValidateTags(structType *ast.StructType) bool {
+	found := false
+	ast.Inspect(structType, func(n ast.Node) bool {
+		if field, ok := n.(*ast.Field); ok && field.Tag != nil {
+			if tag, err := parseTagLiteral(field.Tag); err == nil {
+				if validate, ok := tag.Get("validate"); ok && validate != "" && validate != "-" {
+					found = true
+				}
+			}
+		}
+		return !found
+	})
+	return found
+}
+
+// validateGenerator writes the checks of one file
+type validateGenerator struct {
+	out      *bytes.Buffer
+	resolver *typeResolver
+	imports  map[string]bool
+	// structs are the types getting a Validate method
+	structs map[string]bool
+	// depth numbers the variables of nested loops
+	depth int
+}
+
+// fieldPath is an error prefix like "Items[%v].Name" with the loop
+// variables it refers to
+type fieldPath struct {
+	format string
+	args   []string
+}
+
+func (p fieldPath) child(name string) fieldPath {
+	if p.format == "" {
+		return fieldPath{name, p.args}
+	}
+	return fieldPath{p.format + "." + name, p.args}
+}
+
+func (p fieldPath) index(variable string) fieldPath {
+	return fieldPath{p.format + "[%v]", append(append([]string(nil), p.args...), variable)}
+}
+
+func (g *validateGenerator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(g.out, format, args...)
+}
+
+func (g *validateGenerator) writeStruct(spec *ast.TypeSpec) {
+	name := spec.Name.Name
+	receiver := strings.ToLower(name[:1])
+	g.printf("// Validate checks the validate tags of %s\n", name)
+	g.printf("func (%s *%s) Validate() error {\n", receiver, name)
+	g.writeFields(spec.Type.(*ast.StructType), receiver, fieldPath{})
+	g.printf("return nil\n}\n\n")
+}
+
+func (g *validateGenerator) writeFields(structType *ast.StructType, receiver string, path fieldPath) {
+	for _, field := range structType.Fields.List {
+		validate := ""
+		if field.Tag != nil {
+			if tag, err := parseTagLiteral(field.Tag); err == nil {
+				validate, _ = tag.Get("validate")
+			}
+		}
+		if validate == "-" {
+			continue
+		}
+		for _, name := range fieldNames(field) {
+			if name == "_" || name == "" {
+				continue
+			}
+			expr := receiver + "." + name
+			if inline, ok := field.Type.(*ast.StructType); ok {
+				// Inline structs have no method of their own, their fields
+				// are checked in place
+				g.writeFields(inline, expr, path.child(name))
+				continue
+			}
+			g.writeChecks(expr, g.resolver.describe(field.Type), path.child(name), splitValidate(validate))
+		}
+	}
+}
+
+// splitValidate splits a validate tag into its items
+func splitValidate(validate string) []string {
+	var items []string
+	for _, item := range strings.Split(validate, ",") {
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// writeChecks writes the checks of items for the value expr. Items after
+// dive apply to the elements, keys...endkeys right after it to map keys.
+func (g *validateGenerator) writeChecks(expr string, desc *typeDesc, path fieldPath, items []string) {
+	for i, item := range items {
+		if item != "omitempty" && item != "required" && desc != nil && desc.Kind == "pointer" && desc.Elem != nil {
+			// Other checks apply to the value a pointer points to
+			g.printf("if %s != nil {\n", expr)
+			g.writeChecks("(*"+expr+")", desc.Elem, path, items[i:])
+			g.printf("}\n")
+			return
+		}
+
+		switch item {
+		case "omitempty":
+			zero := g.zeroCheck(expr, desc)
+			if zero == "" {
+				g.printf("// omitempty is not checked for %s\n", typeName(desc))
+				continue
+			}
+			g.printf("if !(%s) {\n", zero)
+			if desc.Kind == "pointer" && desc.Elem != nil {
+				g.writeChecks("(*"+expr+")", desc.Elem, path, items[i+1:])
+			} else {
+				g.writeChecks(expr, desc, path, items[i+1:])
+			}
+			g.printf("}\n")
+			return
+		case "dive":
+			g.writeDive(expr, desc, path, items[i+1:])
+			g.writeNested(expr, desc, path)
+			return
+		case "required":
+			// Struct values always pass required, as in the validator
+			if desc == nil || desc.Kind != "struct" {
+				g.writeCheck(expr, desc, path, item)
+			}
+		default:
+			g.writeCheck(expr, desc, path, item)
+		}
+	}
+	g.writeNested(expr, desc, path)
+}
+
+// writeNested calls Validate of struct values with a generated method
+func (g *validateGenerator) writeNested(expr string, desc *typeDesc, path fieldPath) {
+	if desc == nil {
+		return
+	}
+	if desc.Kind == "pointer" && g.isGenerated(desc.Elem) {
+		g.printf("if %s != nil {\n", expr)
+		g.writeValidateCall(expr, path)
+		g.printf("}\n")
+	} else if g.isGenerated(desc) {
+		g.writeValidateCall(expr, path)
+	}
+}
+
+// isGenerated reports whether the type gets a generated Validate method.
+// Types of the package are unresolved when it doesn't type-check, their
+// kind is unknown then.
+func (g *validateGenerator) isGenerated(desc *typeDesc) bool {
+	return desc != nil && (desc.Kind == "struct" || desc.Kind == "") && g.structs[desc.Type]
+}
+
+func (g *validateGenerator) writeValidateCall(expr string, path fieldPath) {
+	g.imports["fmt"] = true
+	g.printf("if err := %s.Validate(); err != nil {\n", expr)
+	g.printf("return fmt.Errorf(%s, %s)\n", strconv.Quote(path.format+".%w"), strings.Join(append(append([]string(nil), path.args...), "err"), ", "))
+	g.printf("}\n")
+}
+
+func (g *validateGenerator) writeDive(expr string, desc *typeDesc, path fieldPath, items []string) {
+	if desc == nil || (desc.Kind != "slice" && desc.Kind != "array" && desc.Kind != "map") {
+		g.printf("// dive is not checked for %s\n", typeName(desc))
+		return
+	}
+
+	var keyItems []string
+	if desc.Kind == "map" && len(items) > 0 && items[0] == "keys" {
+		for i, item := range items {
+			if item == "endkeys" {
+				keyItems, items = items[1:i], items[i+1:]
+				break
+			}
+		}
+	}
+
+	g.depth++
+	defer func() { g.depth-- }()
+	key, value := fmt.Sprintf("i%d", g.depth), fmt.Sprintf("v%d", g.depth)
+	if desc.Kind == "map" {
+		key = fmt.Sprintf("k%d", g.depth)
+	}
+	elemPath := path.index(key)
+
+	// The body is written first to find out which loop variables it uses
+	out := g.out
+	var body bytes.Buffer
+	g.out = &body
+	if len(keyItems) > 0 {
+		g.writeChecks(key, desc.Key, elemPath, keyItems)
+	}
+	g.writeChecks(value, desc.Elem, elemPath, items)
+	g.out = out
+	if !usesVariable(body.String(), key) && !usesVariable(body.String(), value) {
+		g.out.Write(body.Bytes())
+		return
+	}
+	if !usesVariable(body.String(), key) {
+		key = "_"
+	}
+	if !usesVariable(body.String(), value) {
+		value = "_"
+	}
+	g.printf("for %s, %s := range %s {\n", key, value, expr)
+	g.out.Write(body.Bytes())
+	g.printf("}\n")
+}
+
+// usesVariable reports whether generated code refers to a loop variable
+func usesVariable(code, variable string) bool {
+	return regexp.MustCompile(`\b` + variable + `\b`).MatchString(code)
+}
+
+// writeCheck writes the check of a single tag item
+func (g *validateGenerator) writeCheck(expr string, desc *typeDesc, path fieldPath, item string) {
+	name, param, _ := strings.Cut(item, "=")
+	var failed string
+	switch name {
+	case "required":
+		failed = g.zeroCheck(expr, desc)
+	case "gte", "lte", "gt", "lt", "len", "min", "max", "eq", "ne":
+		failed = g.compareCheck(expr, desc, name, param)
+	case "oneof":
+		failed = g.oneofCheck(expr, desc, param)
+	case "uri", "url":
+		if isString(desc) {
+			g.imports["net/url"] = true
+			failed = fmt.Sprintf("func() bool { _, err := url.ParseRequestURI(%s); return err != nil }()", expr)
+		}
+	case "email":
+		if isString(desc) {
+			g.imports["net/mail"] = true
+			failed = fmt.Sprintf("func() bool { addr, err := mail.ParseAddress(%s); return err != nil || addr.Address != %s }()", expr, expr)
+		}
+	case "numeric":
+		if isNumber(desc) {
+			return
+		}
+		if isString(desc) {
+			g.imports["strconv"] = true
+			failed = fmt.Sprintf("func() bool { _, err := strconv.ParseFloat(%s, 64); return err != nil }()", expr)
+		}
+	}
+	if failed == "" {
+		g.printf("// %s is not checked for %s\n", item, typeName(desc))
+		return
+	}
+
+	g.imports["fmt"] = true
+	// The message is a format string, the tag item may contain a %
+	message := strings.ReplaceAll(fmt.Sprintf("failed on the '%s' tag", item), "%", "%%")
+	if path.format != "" {
+		message = path.format + ": " + message
+	}
+	g.printf("if %s {\n", failed)
+	if len(path.args) == 0 {
+		g.printf("return fmt.Errorf(%s)\n", strconv.Quote(message))
+	} else {
+		g.printf("return fmt.Errorf(%s, %s)\n", strconv.Quote(message), strings.Join(path.args, ", "))
+	}
+	g.printf("}\n")
+}
+
+// zeroCheck returns a condition true for the zero value of the type, or ""
+// when it can't be written without reflection
+func (g *validateGenerator) zeroCheck(expr string, desc *typeDesc) string {
+	switch {
+	case desc == nil:
+		return ""
+	case isString(desc):
+		return expr + ` == ""`
+	case isNumber(desc):
+		return expr + " == 0"
+	case desc.Kind == "bool":
+		return "!" + expr
+	case desc.Kind == "time.Time":
+		return expr + ".IsZero()"
+	case desc.Kind == "uuid.UUID":
+		return expr + " == [16]byte{}"
+	case desc.Kind == "net.IP":
+		return expr + " == nil"
+	}
+	switch desc.Kind {
+	case "pointer", "slice", "map", "interface", "chan", "func":
+		return expr + " == nil"
+	}
+	return ""
+}
+
+// compareCheck compares numbers by value and strings, slices and maps by
+// length, the way the validator does
+func (g *validateGenerator) compareCheck(expr string, desc *typeDesc, name, param string) string {
+	operators := map[string]string{
+		"gte": "<", "min": "<", "lte": ">", "max": ">", "gt": "<=", "lt": ">=",
+		"len": "!=", "eq": "!=", "ne": "==",
+	}
+	operator := operators[name]
+	if param == "" || desc == nil {
+		return ""
+	}
+
+	switch {
+	case desc.Kind == "time.Duration":
+		duration, err := time.ParseDuration(param)
+		if err != nil {
+			return ""
+		}
+		g.imports["time"] = true
+		return fmt.Sprintf("%s %s time.Duration(%d)", expr, operator, duration)
+	case isNumber(desc):
+		if !isNumberConstant(desc, param) {
+			return ""
+		}
+		return fmt.Sprintf("%s %s %s", expr, operator, param)
+	case isString(desc):
+		if _, err := strconv.Atoi(param); err != nil {
+			return ""
+		}
+		if name == "eq" || name == "ne" {
+			return fmt.Sprintf("%s %s %s", expr, operator, strconv.Quote(param))
+		}
+		g.imports["unicode/utf8"] = true
+		return fmt.Sprintf("utf8.RuneCountInString(%s) %s %s", expr, operator, param)
+	case desc.Kind == "slice" || desc.Kind == "array" || desc.Kind == "map" || desc.Kind == "net.IP":
+		if _, err := strconv.Atoi(param); err != nil {
+			return ""
+		}
+		return fmt.Sprintf("len(%s) %s %s", expr, operator, param)
+	}
+	return ""
+}
+
+// oneofValues splits the parameter of oneof, values with spaces are quoted
+// with single quotes
+var oneofValues = regexp.MustCompile(`'[^']*'|\S+`)
+
+func (g *validateGenerator) oneofCheck(expr string, desc *typeDesc, param string) string {
+	var conditions []string
+	for _, value := range oneofValues.FindAllString(param, -1) {
+		value = strings.Trim(value, "'")
+		switch {
+		case isString(desc):
+			conditions = append(conditions, fmt.Sprintf("%s != %s", expr, strconv.Quote(value)))
+		case isNumber(desc):
+			if !isNumberConstant(desc, value) {
+				return ""
+			}
+			conditions = append(conditions, fmt.Sprintf("%s != %s", expr, value))
+		default:
+			return ""
+		}
+	}
+	return strings.Join(conditions, " && ")
+}
+
+func isString(desc *typeDesc) bool {
+	return desc != nil && desc.Kind == "string"
+}
+
+// isNumberConstant reports whether value is a constant of the number type,
+// comparing an int with 1.5 or a uint8 with 300 doesn't compile
+func isNumberConstant(desc *typeDesc, value string) bool {
+	bits := 64
+	if size := strings.TrimLeft(desc.Kind, "intufloa"); size != "" && size != "ptr" {
+		bits, _ = strconv.Atoi(size)
+	}
+	var err error
+	switch {
+	case strings.HasPrefix(desc.Kind, "float"):
+		_, err = strconv.ParseFloat(value, bits)
+	case strings.HasPrefix(desc.Kind, "uint"):
+		_, err = strconv.ParseUint(value, 10, bits)
+	default:
+		_, err = strconv.ParseInt(value, 10, bits)
+	}
+	return err == nil
+}
+
+func isNumber(desc *typeDesc) bool {
+	if desc == nil {
+		return false
+	}
+	switch desc.Kind {
+	case "int", "int8", "int16", "int32", "int64",
+		"uint", "uint8", "uint16", "uint32", "uint64", "uintptr",
+		"float32", "float64", "time.Duration":
+		return true
+	}
+	return false
+}
+
+func typeName(desc *typeDesc) string {
+	if desc == nil || desc.Type == "" {
+		return "this type"
+	}
+	return desc.Type
+}
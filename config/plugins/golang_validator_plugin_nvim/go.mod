@@ -3,9 +3,10 @@ module myplugin
 go 1.21.11
 
 require (
+	github.com/BurntSushi/toml v1.4.0
 	github.com/dave/dst v0.27.3
-	github.com/fatih/structtag v1.2.0
 	github.com/neovim/go-client v1.2.1
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
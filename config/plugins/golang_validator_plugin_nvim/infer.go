@@ -0,0 +1,208 @@
+package main
+
+import (
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// specialTypes are named types with a kind of their own instead of the kind
+// of their underlying type
+var specialTypes = map[string]string{
+	"time.Time":                      "time.Time",
+	"time.Duration":                  "time.Duration",
+	"net.IP":                         "net.IP",
+	"github.com/google/uuid.UUID":    "uuid.UUID",
+	"github.com/gofrs/uuid.UUID":     "uuid.UUID",
+	"github.com/satori/go.uuid.UUID": "uuid.UUID",
+}
+
+// maxTypeDepth stops describing element types of recursive types like
+// type L []L
+const maxTypeDepth = 5
+
+// typeDesc is what inference knows about a type. Kind is the name of a
+// basic type ("int8", "string"), a special type ("time.Time") or one of
+// pointer, slice, array, map, struct, interface, func and chan. It is empty
+// when the type can't be resolved.
+type typeDesc struct {
+	Type string
+	Kind string
+	Key  *typeDesc
+	Elem *typeDesc
+}
+
+// typeResolver describes field types, through go/types when the package
+// type-checks far enough and through the syntax otherwise
+type typeResolver struct {
+	info    *types.Info
+	pkg     *types.Package
+	imports map[string]string
+	// files are the files of the package, the buffer's file first, their
+	// positions are in fset
+	fset  *token.FileSet
+	files []*ast.File
+}
+
+// newTypeResolver type-checks f together with the other files of its
+// package. Errors are ignored, unresolved types fall back to the syntax.
+func newTypeResolver(fset *token.FileSet, f *ast.File, filePath string) *typeResolver {
+	resolver := &typeResolver{
+		info:    &types.Info{Types: make(map[ast.Expr]types.TypeAndValue)},
+		imports: fileImports(f),
+	}
+
+	files := []*ast.File{f}
+	matches, _ := filepath.Glob(filepath.Join(filepath.Dir(filePath), "*.go"))
+	for _, path := range matches {
+		if sameFile(path, filePath) || strings.HasSuffix(path, "_test.go") {
+			continue
+		}
+		other, err := parser.ParseFile(fset, path, nil, parser.ParseComments)
+		if err == nil && other.Name.Name == f.Name.Name {
+			files = append(files, other)
+		}
+	}
+
+	config := types.Config{
+		Importer: importer.ForCompiler(fset, "source", nil),
+		Error:    func(error) {},
+	}
+	resolver.pkg, _ = config.Check(f.Name.Name, fset, files, resolver.info)
+	resolver.fset, resolver.files = fset, files
+	return resolver
+}
+
+func sameFile(a, b string) bool {
+	infoA, errA := os.Stat(a)
+	infoB, errB := os.Stat(b)
+	if errA != nil || errB != nil {
+		return filepath.Clean(a) == filepath.Clean(b)
+	}
+	return os.SameFile(infoA, infoB)
+}
+
+// fileImports maps the names imports are used by to their paths
+func fileImports(f *ast.File) map[string]string {
+	imports := make(map[string]string)
+	for _, spec := range f.Imports {
+		path, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			continue
+		}
+		name := filepath.Base(path)
+		if spec.Name != nil {
+			name = spec.Name.Name
+		} else if strings.HasPrefix(name, "go.") || strings.HasPrefix(name, "go-") {
+			// github.com/satori/go.uuid is package uuid
+			name = name[3:]
+		}
+		imports[name] = path
+	}
+	return imports
+}
+
+// describe returns the description of a field type expression
+func (r *typeResolver) describe(expr ast.Expr) *typeDesc {
+	if r != nil && r.info != nil {
+		if tv, ok := r.info.Types[expr]; ok && tv.Type != nil && tv.Type != types.Typ[types.Invalid] {
+			desc := r.describeType(tv.Type, 0)
+			desc.Type = typeString(expr)
+			return desc
+		}
+	}
+	return r.describeExpr(expr)
+}
+
+// describeType resolves named types to their underlying type
+func (r *typeResolver) describeType(t types.Type, depth int) *typeDesc {
+	desc := &typeDesc{Type: types.TypeString(t, r.qualifier)}
+	if named, ok := t.(*types.Named); ok && named.Obj().Pkg() != nil {
+		if kind, special := specialTypes[named.Obj().Pkg().Path()+"."+named.Obj().Name()]; special {
+			desc.Kind = kind
+			return desc
+		}
+	}
+	if depth >= maxTypeDepth {
+		return desc
+	}
+
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		if u.Kind() != types.Invalid {
+			desc.Kind = u.Name()
+		}
+	case *types.Pointer:
+		desc.Kind, desc.Elem = "pointer", r.describeType(u.Elem(), depth+1)
+	case *types.Slice:
+		desc.Kind, desc.Elem = "slice", r.describeType(u.Elem(), depth+1)
+	case *types.Array:
+		desc.Kind, desc.Elem = "array", r.describeType(u.Elem(), depth+1)
+	case *types.Map:
+		desc.Kind = "map"
+		desc.Key, desc.Elem = r.describeType(u.Key(), depth+1), r.describeType(u.Elem(), depth+1)
+	case *types.Struct:
+		desc.Kind = "struct"
+	case *types.Interface:
+		desc.Kind = "interface"
+	case *types.Signature:
+		desc.Kind = "func"
+	case *types.Chan:
+		desc.Kind = "chan"
+	}
+	return desc
+}
+
+// qualifier prints types of other packages with their package name and the
+// types of the checked package unqualified
+func (r *typeResolver) qualifier(pkg *types.Package) string {
+	if pkg == r.pkg {
+		return ""
+	}
+	return pkg.Name()
+}
+
+// describeExpr describes a type from its syntax alone, named types of the
+// package can't be resolved this way and get no kind
+func (r *typeResolver) describeExpr(expr ast.Expr) *typeDesc {
+	desc := &typeDesc{Type: typeString(expr)}
+	switch x := expr.(type) {
+	case *ast.ParenExpr:
+		return r.describeExpr(x.X)
+	case *ast.Ident:
+		if obj, ok := types.Universe.Lookup(x.Name).(*types.TypeName); ok {
+			return r.describeType(obj.Type(), 0)
+		}
+	case *ast.SelectorExpr:
+		if pkg, ok := x.X.(*ast.Ident); ok && r != nil {
+			if path, known := r.imports[pkg.Name]; known {
+				desc.Kind = specialTypes[path+"."+x.Sel.Name]
+			}
+		}
+	case *ast.StarExpr:
+		desc.Kind, desc.Elem = "pointer", r.describeExpr(x.X)
+	case *ast.ArrayType:
+		desc.Kind, desc.Elem = "slice", r.describeExpr(x.Elt)
+		if x.Len != nil {
+			desc.Kind = "array"
+		}
+	case *ast.MapType:
+		desc.Kind = "map"
+		desc.Key, desc.Elem = r.describeExpr(x.Key), r.describeExpr(x.Value)
+	case *ast.StructType:
+		desc.Kind = "struct"
+	case *ast.InterfaceType:
+		desc.Kind = "interface"
+	case *ast.FuncType:
+		desc.Kind = "func"
+	case *ast.ChanType:
+		desc.Kind = "chan"
+	}
+	return desc
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/neovim/go-client/nvim"
+)
+
+// validatorParams tells how the built-in validators of go-playground/validator
+// take a parameter
+type validatorParams int
+
+const (
+	paramNone validatorParams = iota
+	paramOptional
+	paramRequired
+	// paramNumber is a number, or a duration on time.Duration fields
+	paramNumber
+)
+
+// validatorTarget is the kind of field a validator makes sense on
+type validatorTarget int
+
+const (
+	targetAny validatorTarget = iota
+	targetString
+	// targetScalar is a string or a number
+	targetScalar
+	// targetSized is anything with a length or a value to compare
+	targetSized
+)
+
+type validatorSpec struct {
+	params validatorParams
+	target validatorTarget
+}
+
+// knownValidators are the baked-in validators, custom ones are listed in the
+// project config
+var knownValidators = map[string]validatorSpec{}
+
+func init() {
+	add := func(params validatorParams, target validatorTarget, names ...string) {
+		for _, name := range names {
+			knownValidators[name] = validatorSpec{params, target}
+		}
+	}
+	add(paramNone, targetAny, "required", "omitempty", "omitnil", "isdefault", "dive", "keys", "endkeys",
+		"structonly", "nostructlevel")
+	add(paramOptional, targetAny, "unique")
+	add(paramNumber, targetSized, "len", "min", "max", "lt", "lte", "gt", "gte")
+	// eq= compares with the empty string
+	add(paramOptional, targetAny, "eq", "ne", "eq_ignore_case", "ne_ignore_case")
+	add(paramRequired, targetAny, "eqfield", "nefield", "gtfield", "gtefield", "ltfield", "ltefield",
+		"eqcsfield", "necsfield", "gtcsfield", "gtecsfield", "ltcsfield", "ltecsfield",
+		"fieldcontains", "fieldexcludes",
+		"required_if", "required_unless", "required_with", "required_with_all", "required_without",
+		"required_without_all", "excluded_if", "excluded_unless", "excluded_with", "excluded_with_all",
+		"excluded_without", "excluded_without_all")
+	add(paramRequired, targetScalar, "oneof", "oneofci")
+	add(paramNone, targetScalar, "numeric", "number", "boolean")
+	add(paramRequired, targetString, "contains", "containsany", "containsrune", "excludes", "excludesall",
+		"excludesrune", "startswith", "startsnotwith", "endswith", "endsnotwith", "datetime",
+		"postcode_iso3166_alpha2", "postcode_iso3166_alpha2_field")
+	add(paramNone, targetString, "alpha", "alphanum", "alphaunicode", "alphanumunicode", "ascii",
+		"lowercase", "uppercase", "multibyte", "printascii",
+		"email", "url", "uri", "http_url", "url_encoded", "urn_rfc2141", "hostname", "hostname_rfc1123",
+		"hostname_port", "fqdn", "ip", "ipv4", "ipv6", "ip_addr", "ip4_addr", "ip6_addr", "cidr",
+		"cidrv4", "cidrv6", "mac", "tcp_addr", "tcp4_addr", "tcp6_addr", "udp_addr", "udp4_addr",
+		"udp6_addr", "unix_addr", "base64", "base64url", "base64rawurl", "btc_addr", "btc_addr_bech32",
+		"datauri", "e164", "eth_addr", "hexadecimal", "hexcolor", "hsl", "hsla", "html", "html_encoded",
+		"isbn", "isbn10", "isbn13", "issn", "json", "jwt", "latitude", "longitude", "rgb", "rgba", "ssn",
+		"uuid", "uuid3", "uuid4", "uuid5", "uuid_rfc4122", "uuid3_rfc4122", "uuid4_rfc4122",
+		"uuid5_rfc4122", "ulid", "md4", "md5", "sha256", "sha384", "sha512", "ripemd128", "ripemd160",
+		"tiger128", "tiger160", "tiger192", "semver", "cron", "timezone", "iso3166_1_alpha2",
+		"iso3166_1_alpha3", "iso3166_1_alpha_numeric", "iso3166_2", "iso4217", "iso4217_numeric",
+		"bcp47_language_tag", "credit_card", "luhn_checksum", "mongodb", "mongodb_connection_string",
+		"file", "filepath", "dir", "dirpath", "image", "country_code", "spicedb", "cve", "bic", "dns_rfc1035_label")
+}
+
+// lintDiagnostic is a vim.diagnostic item, lines and columns are 0-based
+type lintDiagnostic struct {
+	Line, Col, EndCol int
+	Severity          int
+	Message           string
+}
+
+// Severities of vim.diagnostic.severity
+const (
+	severityError = 1
+	severityWarn  = 2
+)
+
+// lintValidatorTags checks the validate tags of the current buffer and
+// pushes the problems to vim.diagnostic. The tags are not changed.
+func lintValidatorTags(v *nvim.Nvim, args []string) (string, error) {
+	if len(args) != 1 {
+		return "", fmt.Errorf("expected the file path, got %d arguments", len(args))
+	}
+	filePath := args[0]
+
+	buffer, err := v.CurrentBuffer()
+	if err != nil {
+		return "", fmt.Errorf("failed to get current buffer: %v", err)
+	}
+	lines, err := v.BufferLines(buffer, 0, -1, true)
+	if err != nil {
+		return "", fmt.Errorf("failed to get buffer lines: %v", err)
+	}
+
+	fset := token.NewFileSet()
+	f, _ := parser.ParseFile(fset, filePath, bytes.Join(lines, []byte{'\n'}), parser.ParseComments|parser.AllErrors)
+	if f == nil {
+		return "", fmt.Errorf("failed to parse file")
+	}
+	rules, err := loadRules(filePath)
+	if err != nil {
+		return "", err
+	}
+
+	linter := &tagLinter{fset: fset, resolver: newTypeResolver(fset, f, filePath), custom: make(map[string]bool)}
+	for _, name := range rules.Validators {
+		linter.custom[name] = true
+	}
+	ast.Inspect(f, func(n ast.Node) bool {
+		if field, ok := n.(*ast.Field); ok && field.Tag != nil {
+			linter.lintField(field)
+		}
+		return true
+	})
+
+	items := make([]map[string]interface{}, len(linter.diagnostics))
+	for i, d := range linter.diagnostics {
+		items[i] = map[string]interface{}{
+			"lnum":     d.Line,
+			"col":      d.Col,
+			"end_col":  d.EndCol,
+			"severity": d.Severity,
+			"message":  d.Message,
+			"source":   "validate",
+		}
+	}
+	err = v.ExecLua(`
+		local bufnr, items = ...
+		local ns = vim.api.nvim_create_namespace("golang_validator_lint")
+		vim.diagnostic.set(ns, bufnr, items)
+	`, nil, buffer, items)
+	if err != nil {
+		return "", fmt.Errorf("failed to set diagnostics: %v", err)
+	}
+	return fmt.Sprintf("%d problems in validate tags", len(items)), nil
+}
+
+// tagLinter collects the problems of the tags of a file
+type tagLinter struct {
+	fset        *token.FileSet
+	resolver    *typeResolver
+	custom      map[string]bool
+	diagnostics []lintDiagnostic
+}
+
+// tagSpan locates text of a tag literal in the buffer. Offsets can only be
+// mapped into single-line raw literals, otherwise the whole literal is used.
+type tagSpan struct {
+	line, col, end int
+	precise        bool
+}
+
+func (l *tagLinter) spanOf(lit *ast.BasicLit) tagSpan {
+	start := l.fset.Position(lit.Pos())
+	return tagSpan{
+		line:    start.Line - 1,
+		col:     start.Column - 1,
+		end:     start.Column - 1 + len(lit.Value),
+		precise: strings.HasPrefix(lit.Value, "`") && !strings.Contains(lit.Value, "\n"),
+	}
+}
+
+// report adds a diagnostic for the content offsets [from, to) of the tag
+func (l *tagLinter) report(span tagSpan, from, to, severity int, format string, args ...interface{}) {
+	d := lintDiagnostic{Line: span.line, Col: span.col, EndCol: span.end, Severity: severity, Message: fmt.Sprintf(format, args...)}
+	if span.precise && from >= 0 {
+		// The content starts after the backquote
+		d.Col, d.EndCol = span.col+1+from, span.col+1+to
+	}
+	l.diagnostics = append(l.diagnostics, d)
+}
+
+func (l *tagLinter) lintField(field *ast.Field) {
+	span := l.spanOf(field.Tag)
+	tag, err := parseTagLiteral(field.Tag)
+	if err != nil {
+		l.report(span, -1, -1, severityError, "%v", err)
+		return
+	}
+
+	seen := make(map[string]bool)
+	for i := range tag.pairs {
+		pair := &tag.pairs[i]
+		if seen[pair.key] {
+			l.report(span, pair.offset, pair.offset+len(pair.key), severityError, "duplicate tag key %q", pair.key)
+			continue
+		}
+		seen[pair.key] = true
+		// gin's binding key uses the validator syntax as well
+		if (pair.key != "validate" && pair.key != "binding") || pair.value == "-" {
+			continue
+		}
+
+		// Offsets inside the value only line up when it has no escapes
+		valueOffset := -1
+		if !strings.Contains(pair.quoted, `\`) {
+			valueOffset = pair.offset + len(pair.key) + 2
+		}
+		l.lintValidate(span, valueOffset, pair.value, l.resolver.describe(field.Type))
+	}
+}
+
+// lintValidate walks the items of a validate tag, following dive into the
+// element and keys into the key type
+func (l *tagLinter) lintValidate(span tagSpan, valueOffset int, validate string, desc *typeDesc) {
+	var container *typeDesc
+	dived, inKeys := false, false
+	items := make(map[string]bool)
+	offset := 0
+	for _, item := range strings.Split(validate, ",") {
+		from := -1
+		if valueOffset >= 0 {
+			from = valueOffset + offset
+		}
+		offset += len(item) + 1
+		to := from + len(item)
+		if item == "" {
+			l.report(span, from, to+1, severityError, "empty validator")
+			continue
+		}
+
+		// Checks on pointers apply to the value
+		for desc != nil && desc.Kind == "pointer" && item != "required" && item != "omitempty" && item != "omitnil" {
+			desc = desc.Elem
+		}
+
+		switch item {
+		case "dive":
+			if desc != nil && desc.Kind != "" && desc.Kind != "slice" && desc.Kind != "array" && desc.Kind != "map" {
+				l.report(span, from, to, severityError, "dive on %s, which is not a slice, array or map", desc.Type)
+				return
+			}
+			container, dived, items = desc, true, make(map[string]bool)
+			if desc != nil {
+				desc = desc.Elem
+			}
+			continue
+		case "keys":
+			if !dived || inKeys || (container != nil && container.Kind != "" && container.Kind != "map") {
+				l.report(span, from, to, severityError, "keys must follow dive on a map")
+				return
+			}
+			inKeys, desc, items = true, nil, make(map[string]bool)
+			if container != nil {
+				desc = container.Key
+			}
+			continue
+		case "endkeys":
+			if !inKeys {
+				l.report(span, from, to, severityError, "endkeys without keys")
+				return
+			}
+			inKeys, desc, items = false, nil, make(map[string]bool)
+			if container != nil {
+				desc = container.Elem
+			}
+			continue
+		}
+
+		if items[item] {
+			l.report(span, from, to, severityWarn, "duplicate validator %q", item)
+		}
+		items[item] = true
+		// Alternatives like "rgb|rgba" are checked one by one
+		position := from
+		for _, alternative := range strings.Split(item, "|") {
+			alternativeFrom, alternativeTo := -1, -1
+			if from >= 0 {
+				alternativeFrom, alternativeTo = position, position+len(alternative)
+				position = alternativeTo + 1
+			}
+			l.lintValidator(span, alternativeFrom, alternativeTo, alternative, desc)
+		}
+	}
+	if inKeys {
+		l.report(span, valueOffset, valueOffset+len(validate), severityError, "keys without endkeys")
+	}
+}
+
+// lintValidator checks a single validator against the field type
+func (l *tagLinter) lintValidator(span tagSpan, from, to int, item string, desc *typeDesc) {
+	name, param, hasParam := strings.Cut(item, "=")
+	spec, known := knownValidators[name]
+	if !known {
+		if !l.custom[name] {
+			l.report(span, from, to, severityWarn, "unknown validator %q, custom ones go into the validators list of the project config", name)
+		}
+		return
+	}
+
+	switch spec.params {
+	case paramNone:
+		if hasParam {
+			l.report(span, from, to, severityError, "%s takes no parameter", name)
+		}
+	case paramRequired:
+		if param == "" {
+			l.report(span, from, to, severityError, "%s needs a parameter", name)
+		}
+	case paramNumber:
+		if param == "" {
+			// gt, lt and friends compare time.Time fields to now
+			if desc == nil || desc.Kind != "time.Time" {
+				l.report(span, from, to, severityError, "%s needs a parameter", name)
+			}
+		} else if !validNumber(param, desc) {
+			l.report(span, from, to, severityError, "malformed parameter %q of %s", param, name)
+		}
+	}
+
+	// Without a resolved type there is nothing to compare with
+	if desc == nil || desc.Kind == "" {
+		return
+	}
+	sensible := true
+	switch spec.target {
+	case targetString:
+		sensible = isString(desc)
+	case targetScalar:
+		sensible = isString(desc) || isNumber(desc)
+	case targetSized:
+		switch desc.Kind {
+		case "bool", "struct", "interface", "func", "chan":
+			sensible = false
+		}
+	}
+	if !sensible {
+		l.report(span, from, to, severityError, "%s makes no sense on %s", name, desc.Type)
+	}
+}
+
+// validNumber reports whether param is a number, or a duration on
+// time.Duration fields
+func validNumber(param string, desc *typeDesc) bool {
+	if desc != nil && desc.Kind == "time.Duration" {
+		_, err := time.ParseDuration(param)
+		return err == nil
+	}
+	_, err := strconv.ParseFloat(param, 64)
+	return err == nil
+}
@@ -2,13 +2,16 @@ package main
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"go/ast"
-	"go/format"
 	"go/parser"
+	"go/scanner"
 	"go/token"
 	"log"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/neovim/go-client/nvim"
@@ -25,14 +28,24 @@ func main() {
 	}
 
 	v.RegisterHandler("addValidatorTags", addValidatorTags)
+	v.RegisterHandler("manageTags", manageTags)
+	v.RegisterHandler("generateValidate", generateValidate)
+	v.RegisterHandler("lintValidatorTags", lintValidatorTags)
+	v.RegisterHandler("exportSchema", exportSchema)
+	v.RegisterHandler("generateStruct", generateStruct)
 
 	if err := v.Serve(); err != nil {
 		log.Fatal(err)
 	}
 }
 
+// addValidatorTags adds validate tags to the current buffer. It takes the
+// file path, optionally followed by a scope, the first and last line and a
+// merge policy; without them every struct is tagged and existing validate
+// tags are overwritten.
 func addValidatorTags(v *nvim.Nvim, args []string) (string, error) {
 	var filePath string
+	scope, startLine, endLine, policy := ScopeFile, 1, 1, MergeOverwrite
 
 	// Проверяем количество аргументов
 	switch len(args) {
@@ -42,8 +55,20 @@ func addValidatorTags(v *nvim.Nvim, args []string) (string, error) {
 		// Если передано два аргумента, используем второй как путь к файлу
 		// Первый аргумент может быть идентификатором буфера или чем-то еще, игнорируем его
 		filePath = args[1]
+	case 5:
+		filePath, scope, policy = args[0], TagScope(args[1]), MergePolicy(args[4])
+		var err error
+		if startLine, err = strconv.Atoi(args[2]); err != nil {
+			return "", fmt.Errorf("invalid start line %q", args[2])
+		}
+		if endLine, err = strconv.Atoi(args[3]); err != nil {
+			return "", fmt.Errorf("invalid end line %q", args[3])
+		}
+		if policy != MergeKeep && policy != MergeOverwrite && policy != MergeAppend {
+			return "", fmt.Errorf("unknown merge policy %q, expected keep, overwrite or append", policy)
+		}
 	default:
-		return "", fmt.Errorf("expected 1 or 2 arguments, got %d", len(args))
+		return "", fmt.Errorf("expected 1, 2 or 5 arguments, got %d", len(args))
 	}
 
 	// Получаем текущий буфер
@@ -62,100 +87,266 @@ func addValidatorTags(v *nvim.Nvim, args []string) (string, error) {
 
 	// Парсим содержимое файла
 	fset := token.NewFileSet()
-	f, err := parser.ParseFile(fset, filePath, fileContent, parser.ParseComments)
-	if err != nil {
+	f, err := parser.ParseFile(fset, filePath, fileContent, parser.ParseComments|parser.AllErrors)
+	var syntaxErrors scanner.ErrorList
+	if err != nil && (f == nil || !errors.As(err, &syntaxErrors)) {
 		return "", fmt.Errorf("failed to parse file: %v", err)
 	}
+	rules, err := loadRules(filePath)
+	if err != nil {
+		return "", err
+	}
 
-	// Проходим по AST и модифицируем поля структур
-	ast.Inspect(f, func(n ast.Node) bool {
-		switch x := n.(type) {
-		case *ast.StructType:
-			for _, field := range x.Fields.List {
-				if field.Tag == nil {
-					field.Tag = &ast.BasicLit{
-						Kind:  token.STRING,
-						Value: "",
-					}
-				}
-				addValidatorTag(field)
-			}
+	resolver := newTypeResolver(fset, f, filePath)
+	backend, err := newBackend(rules.Backend, filePath, f, resolver)
+	if err != nil {
+		return "", err
+	}
+	if backend.WholeFile() {
+		scope = ScopeFile
+	}
+
+	fields := selectFields(fset, f, scope, startLine, endLine, syntaxErrors)
+	if len(fields) == 0 {
+		return "", fmt.Errorf("no struct fields in %s scope", scope)
+	}
+	structNames := fieldStructNames(f)
+
+	return updateValidatorTags(v, buffer, fset, fields, structNames, fileContent, syntaxErrors, rules, policy, resolver, backend)
+}
+
+// tagEdit replaces the source between two offsets with text
+type tagEdit struct {
+	start, end int
+	text       string
+}
+
+// updateValidatorTags edits the tag literals of fields in place, the rest of
+// the buffer is left alone. In a file with syntax errors structs overlapping
+// an error are skipped; the tolerated errors are listed in the returned
+// message.
+func updateValidatorTags(v *nvim.Nvim, buffer nvim.Buffer, fset *token.FileSet, fields []*ast.Field, structNames map[*ast.Field]string, fileContent string, syntaxErrors scanner.ErrorList, rules *RuleSet, policy MergePolicy, resolver *typeResolver, backend Backend) (string, error) {
+	var edits []tagEdit
+	for _, field := range fields {
+		start, end := fset.Position(field.Type.End()).Offset, fset.Position(field.Type.End()).Offset
+		prefix := " "
+		if field.Tag != nil {
+			start, end = fset.Position(field.Tag.Pos()).Offset, fset.Position(field.Tag.End()).Offset
+			prefix = ""
+		} else {
+			field.Tag = &ast.BasicLit{Kind: token.STRING, Value: ""}
 		}
-		return true
-	})
+		original := field.Tag.Value
+		if err := addValidatorTag(field, structNames[field], rules, policy, resolver, backend); err != nil {
+			return "", fmt.Errorf("line %d: %v", fset.Position(field.Pos()).Line, err)
+		}
+		if field.Tag.Value != original {
+			edits = append(edits, tagEdit{start, end, prefix + field.Tag.Value})
+		}
+	}
 
-	// Форматируем измененный AST обратно в исходный код
-	var buf bytes.Buffer
-	err = format.Node(&buf, fset, f)
+	message, err := backend.Finish()
 	if err != nil {
-		return "", fmt.Errorf("failed to format modified AST: %v", err)
+		return "", err
+	}
+	if err := setBufferEdits(v, buffer, fileContent, edits); err != nil {
+		return "", err
+	}
+	if len(syntaxErrors) == 0 {
+		return message, nil
 	}
 
-	// Записываем измененное содержимое обратно в буфер
-	newContent := strings.Split(buf.String(), "\n")
-	replacement := make([][]byte, len(newContent))
-	for i, line := range newContent {
-		replacement[i] = []byte(line)
+	tolerated := []string{fmt.Sprintf("Tolerated %d syntax errors:", len(syntaxErrors))}
+	for _, e := range syntaxErrors {
+		tolerated = append(tolerated, e.Error())
 	}
-	err = v.SetBufferLines(buffer, 0, -1, true, replacement)
-	if err != nil {
-		return "", fmt.Errorf("failed to set buffer lines: %v", err)
+	if message != "" {
+		tolerated = append(tolerated, message)
 	}
+	return strings.Join(tolerated, "\n"), nil
+}
+
+// setBufferEdits sends non-overlapping edits of content, given in any
+// order, to buffer with nvim_buf_set_text. Only the edited ranges change, so
+// marks, folds and extmarks elsewhere survive, and the edits are sent in one
+// batch to become a single undo step.
+func setBufferEdits(v *nvim.Nvim, buffer nvim.Buffer, content string, edits []tagEdit) error {
+	if len(edits) == 0 {
+		return nil
+	}
+	// Nested structs are visited after their parent, so order by position
+	sort.Slice(edits, func(i, j int) bool { return edits[i].start < edits[j].start })
 
-	return "", nil
+	// Later edits go first, so the positions of earlier ones stay valid
+	batch := v.NewBatch()
+	for i := len(edits) - 1; i >= 0; i-- {
+		e := edits[i]
+		startRow, startCol := bufferPosition(content, e.start)
+		endRow, endCol := bufferPosition(content, e.end)
+		var replacement [][]byte
+		for _, line := range strings.Split(e.text, "\n") {
+			replacement = append(replacement, []byte(line))
+		}
+		batch.SetBufferText(buffer, startRow, startCol, endRow, endCol, replacement)
+	}
+	if err := batch.Execute(); err != nil {
+		return fmt.Errorf("failed to set buffer text: %v", err)
+	}
+	return nil
 }
 
-func addValidatorTag(field *ast.Field) {
-	tagValue := strings.Trim(field.Tag.Value, "`")
-	tags := make(map[string]string)
+// bufferPosition turns a byte offset into the zero-based row and byte
+// column Neovim expects
+func bufferPosition(content string, offset int) (int, int) {
+	row := strings.Count(content[:offset], "\n")
+	return row, offset - (strings.LastIndex(content[:offset], "\n") + 1)
+}
 
-	// Parse existing tags
-	if tagValue != "" {
-		for _, tag := range strings.Split(tagValue, " ") {
-			parts := strings.SplitN(tag, ":", 2)
-			if len(parts) == 2 {
-				tags[parts[0]] = parts[1]
-			}
+// overlapsErrors reports whether any syntax error lies inside node
+func overlapsErrors(fset *token.FileSet, node ast.Node, syntaxErrors scanner.ErrorList) bool {
+	start, end := fset.Position(node.Pos()).Offset, fset.Position(node.End()).Offset
+	for _, e := range syntaxErrors {
+		if e.Pos.Offset >= start && e.Pos.Offset < end {
+			return true
 		}
 	}
+	return false
+}
 
-	// Add or update validator tag based on field type
-	switch t := field.Type.(type) {
-	case *ast.Ident:
-		switch t.Name {
-		case "string":
-			tags["validate"] = `"required"`
-		case "int", "int64":
-			tags["validate"] = `"required,gte=0"`
-		case "float64":
-			tags["validate"] = `"required,numeric"`
+// fieldStructNames maps struct fields to the name of the type they are
+// declared in; fields of anonymous structs are left out
+func fieldStructNames(f *ast.File) map[*ast.Field]string {
+	names := make(map[*ast.Field]string)
+	ast.Inspect(f, func(n ast.Node) bool {
+		if spec, ok := n.(*ast.TypeSpec); ok {
+			if structType, ok := spec.Type.(*ast.StructType); ok {
+				for _, field := range structType.Fields.List {
+					names[field] = spec.Name.Name
+				}
+			}
 		}
-	case *ast.SelectorExpr:
-		if ident, ok := t.X.(*ast.Ident); ok {
-			if ident.Name == "types" && t.Sel.Name == "Float" {
-				tags["validate"] = `"required,gte=0"`
+		return true
+	})
+	return names
+}
+
+// MergePolicy decides what happens to a validate tag that is already there
+type MergePolicy string
+
+const (
+	// MergeKeep leaves existing validate tags untouched
+	MergeKeep MergePolicy = "keep"
+	// MergeOverwrite replaces them with the matching rule
+	MergeOverwrite MergePolicy = "overwrite"
+	// MergeAppend adds the rule's validations that are missing
+	MergeAppend MergePolicy = "append"
+)
+
+// mergeValidate combines an existing validate tag with the one from a rule
+func mergeValidate(existing, rule string, policy MergePolicy) string {
+	switch policy {
+	case MergeKeep:
+		return existing
+	case MergeAppend:
+		// Items after a dive apply to the elements, so each level is merged
+		// with the same level of the rule
+		levels := diveLevels(existing)
+		for i, ruleItems := range diveLevels(rule) {
+			if i == len(levels) {
+				levels = append(levels, nil)
 			}
+			present := make(map[string]bool, len(levels[i]))
+			for _, item := range levels[i] {
+				present[item] = true
+			}
+			for _, item := range ruleItems {
+				if !present[item] {
+					levels[i] = append(levels[i], item)
+					present[item] = true
+				}
+			}
+		}
+		var items []string
+		for i, level := range levels {
+			if i > 0 {
+				items = append(items, "dive")
+			}
+			items = append(items, level...)
 		}
-	case *ast.ArrayType:
-		tags["validate"] = `"omitempty,dive,required"`
-	case *ast.StructType:
-		tags["validate"] = `"required"`
+		return strings.Join(items, ",")
 	}
+	return rule
+}
 
-	// Special cases based on field name
-	switch field.Names[0].Name {
-	case "URL":
-		tags["validate"] = `"uri"`
-	case "Name":
-		tags["validate"] = `"gt=0"`
+// diveLevels splits the items of a validate tag at its dives: the field's
+// own items first, then those of its elements and so on. Empty items are
+// dropped.
+func diveLevels(validate string) [][]string {
+	levels := [][]string{nil}
+	for _, item := range splitValidate(validate) {
+		if item == "dive" {
+			levels = append(levels, nil)
+			continue
+		}
+		levels[len(levels)-1] = append(levels[len(levels)-1], item)
+	}
+	return levels
+}
+
+// addValidatorTag hands the rules of the best matching rule to the backend.
+// Fields declaring several names share one tag, so they are only applied
+// when the rules agree on all of them.
+func addValidatorTag(field *ast.Field, structName string, rules *RuleSet, policy MergePolicy, resolver *typeResolver, backend Backend) error {
+	tag, err := parseTagLiteral(field.Tag)
+	if err != nil {
+		return err
+	}
+	// Fields left out of encoding are not validated either
+	if json, _ := tag.Get("json"); json == "-" {
+		return nil
+	}
+
+	validate := ""
+	for i, name := range fieldNames(field) {
+		match, ok := rules.Match(fieldInfo(field, name, structName, tag, resolver))
+		if !ok || (i > 0 && match != validate) {
+			return nil
+		}
+		validate = match
 	}
 
-	// Reconstruct tag string
-	var newTags []string
-	for key, value := range tags {
-		newTags = append(newTags, fmt.Sprintf(`%s:%s`, key, value))
+	return backend.Field(field, structName, validate, policy)
+}
+
+// fieldNames returns the names a field declares; an embedded field is named
+// after its type
+func fieldNames(field *ast.Field) []string {
+	if len(field.Names) == 0 {
+		return []string{embeddedName(field.Type)}
+	}
+	names := make([]string, len(field.Names))
+	for i, name := range field.Names {
+		names[i] = name.Name
+	}
+	return names
+}
+
+// embeddedName returns the field name of an embedded type: T, *T, pkg.T or
+// a generic T[P]
+func embeddedName(expr ast.Expr) string {
+	switch x := expr.(type) {
+	case *ast.StarExpr:
+		return embeddedName(x.X)
+	case *ast.SelectorExpr:
+		return x.Sel.Name
+	case *ast.IndexExpr:
+		return embeddedName(x.X)
+	case *ast.IndexListExpr:
+		return embeddedName(x.X)
+	case *ast.Ident:
+		return x.Name
 	}
-	field.Tag.Value = "`" + strings.Join(newTags, " ") + "`"
+	return ""
 }
 
 func init() {
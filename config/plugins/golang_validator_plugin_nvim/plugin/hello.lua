@@ -69,13 +69,185 @@ local function setup()
 	ensure_job() -- Start the RPC server during setup
 end
 
-vim.api.nvim_create_user_command("AddValidatorTags", function()
-	local current_file = vim.fn.expand("%:p")
-	local buffer_content = table.concat(vim.api.nvim_buf_get_lines(0, 0, -1, false), "\n")
-	local result, err = vim.fn.rpcrequest(ensure_job(), "addValidatorTags", { current_file, buffer_content })
-	if err then
-		print("Error: " .. err)
+local merge_policies = { "keep", "overwrite", "append" }
+
+-- :AddValidatorTags [keep|overwrite|append]
+-- Tags the struct under the cursor, the selected fields or, with a bang, the
+-- whole file. Validate tags written by hand are kept unless a policy says
+-- otherwise.
+vim.api.nvim_create_user_command("AddValidatorTags", function(opts)
+	local scope = "struct"
+	if opts.bang then
+		scope = "file"
+	elseif opts.range > 0 then
+		scope = "range"
+	end
+	local policy = opts.args ~= "" and opts.args or "keep"
+	local args = { vim.fn.expand("%:p"), scope, tostring(opts.line1), tostring(opts.line2), policy }
+	local ok, result = pcall(vim.fn.rpcrequest, ensure_job(), "addValidatorTags", args)
+	if not ok then
+		vim.notify("AddValidatorTags: " .. tostring(result), vim.log.levels.ERROR)
+	elseif result and result ~= "" then
+		vim.notify(result, vim.log.levels.WARN)
+	end
+end, {
+	nargs = "?",
+	range = true,
+	bang = true,
+	complete = function(arg_lead)
+		return vim.tbl_filter(function(item)
+			return vim.startswith(item, arg_lead)
+		end, merge_policies)
+	end,
+})
+
+local tag_operations = { "add", "remove", "clear", "transform" }
+local tag_keys = { "json", "yaml", "db", "mapstructure", "xml", "bson", "case=snake", "case=camel", "case=kebab", "case=pascal" }
+
+-- :StructTags add json,omitempty yaml case=camel
+-- Works on the struct under the cursor, the selected fields or, with a bang,
+-- the whole file
+vim.api.nvim_create_user_command("StructTags", function(opts)
+	local scope, line1, line2 = "struct", opts.line1, opts.line2
+	if opts.bang then
+		scope = "file"
+	elseif opts.range > 0 then
+		scope = "range"
+	end
+	local args = { vim.fn.expand("%:p"), scope, tostring(line1), tostring(line2) }
+	vim.list_extend(args, opts.fargs)
+	local ok, result = pcall(vim.fn.rpcrequest, ensure_job(), "manageTags", args)
+	if not ok then
+		vim.notify("StructTags: " .. tostring(result), vim.log.levels.ERROR)
+	elseif result and result ~= "" then
+		vim.notify(result, vim.log.levels.WARN)
 	end
+end, {
+	nargs = "+",
+	range = true,
+	bang = true,
+	complete = function(arg_lead, cmd_line)
+		-- The first argument is the operation, the rest are keys and settings
+		local words = #vim.split(cmd_line, "%s+", { trimempty = true })
+		local candidates = tag_keys
+		if words == 1 or (words == 2 and arg_lead ~= "") then
+			candidates = tag_operations
+		end
+		return vim.tbl_filter(function(item)
+			return vim.startswith(item, arg_lead)
+		end, candidates)
+	end,
+})
+
+-- :GenerateValidate writes Validate() methods for the structs of the buffer
+-- into <file>_validate.go
+vim.api.nvim_create_user_command("GenerateValidate", function()
+	local ok, result = pcall(vim.fn.rpcrequest, ensure_job(), "generateValidate", { vim.fn.expand("%:p") })
+	if not ok then
+		vim.notify("GenerateValidate: " .. tostring(result), vim.log.levels.ERROR)
+	else
+		vim.notify(result, vim.log.levels.INFO)
+	end
+end, {})
+
+-- :LintValidatorTags reports problems of the validate tags as diagnostics,
+-- set vim.g.golang_validator_lint_on_save to run it on every write
+local function lint_validator_tags(quiet)
+	local ok, result = pcall(vim.fn.rpcrequest, ensure_job(), "lintValidatorTags", { vim.fn.expand("%:p") })
+	if not ok then
+		vim.notify("LintValidatorTags: " .. tostring(result), vim.log.levels.ERROR)
+	elseif not quiet then
+		vim.notify(result, vim.log.levels.INFO)
+	end
+end
+
+vim.api.nvim_create_user_command("LintValidatorTags", function()
+	lint_validator_tags(false)
 end, {})
 
+vim.api.nvim_create_autocmd("BufWritePost", {
+	pattern = "*.go",
+	callback = function()
+		if vim.g.golang_validator_lint_on_save then
+			lint_validator_tags(true)
+		end
+	end,
+})
+
+local schema_formats = { "jsonschema", "openapi" }
+
+-- :StructSchema [jsonschema|openapi]
+-- Opens the schema of the struct under the cursor, or with a bang of every
+-- exported struct of the package, in a scratch buffer
+vim.api.nvim_create_user_command("StructSchema", function(opts)
+	local format = opts.args ~= "" and opts.args or "jsonschema"
+	local scope = opts.bang and "package" or "struct"
+	local args = { vim.fn.expand("%:p"), scope, tostring(vim.fn.line(".")), format }
+	local ok, result = pcall(vim.fn.rpcrequest, ensure_job(), "exportSchema", args)
+	if not ok then
+		vim.notify("StructSchema: " .. tostring(result), vim.log.levels.ERROR)
+		return
+	end
+	vim.cmd("vnew")
+	vim.bo.buftype = "nofile"
+	vim.bo.bufhidden = "wipe"
+	vim.bo.filetype = format == "openapi" and "yaml" or "json"
+	vim.api.nvim_buf_set_lines(0, 0, -1, false, vim.split(result, "\n", { trimempty = true }))
+end, {
+	nargs = "?",
+	bang = true,
+	complete = function(arg_lead)
+		return vim.tbl_filter(function(item)
+			return vim.startswith(item, arg_lead)
+		end, schema_formats)
+	end,
+})
+
+-- :GenerateStruct [Name] [nullable=pointer|sql] [tags=json,db,validate] [buffer=N]
+-- Turns the selected JSON sample or CREATE TABLE statements into Go structs
+-- in place. Without a range the source is another buffer, the alternate one
+-- by default, and the structs are inserted below the cursor.
+vim.api.nvim_create_user_command("GenerateStruct", function(opts)
+	local options, source_buffer = {}, vim.fn.bufnr("#")
+	for _, arg in ipairs(opts.fargs) do
+		local buffer = arg:match("^buffer=(.+)$")
+		if buffer then
+			source_buffer = vim.fn.bufnr(tonumber(buffer) or buffer)
+		else
+			table.insert(options, arg)
+		end
+	end
+
+	local source, line1, line2
+	if opts.range > 0 then
+		source = vim.api.nvim_buf_get_lines(0, opts.line1 - 1, opts.line2, false)
+		line1, line2 = opts.line1, opts.line2
+	else
+		if source_buffer < 0 then
+			vim.notify("GenerateStruct: no source buffer", vim.log.levels.ERROR)
+			return
+		end
+		source = vim.api.nvim_buf_get_lines(source_buffer, 0, -1, false)
+		line1 = vim.fn.line(".") + 1
+		line2 = line1 - 1
+	end
+
+	local args = { vim.fn.expand("%:p"), tostring(line1), tostring(line2), table.concat(source, "\n") }
+	vim.list_extend(args, options)
+	local ok, result = pcall(vim.fn.rpcrequest, ensure_job(), "generateStruct", args)
+	if not ok then
+		vim.notify("GenerateStruct: " .. tostring(result), vim.log.levels.ERROR)
+	else
+		vim.notify(result, vim.log.levels.INFO)
+	end
+end, {
+	nargs = "*",
+	range = true,
+	complete = function(arg_lead)
+		return vim.tbl_filter(function(item)
+			return vim.startswith(item, arg_lead)
+		end, { "nullable=pointer", "nullable=sql", "tags=json,validate", "tags=db,json,validate", "buffer=" })
+	end,
+})
+
 log("golang_validator_plugin_nvim loaded successfully")
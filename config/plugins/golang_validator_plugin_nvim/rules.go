@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/printer"
+	"go/token"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// configNames are the project config files looked up next to go.mod, in order
+var configNames = []string{".govalidator.json", ".govalidator.yaml", ".govalidator.yml", ".govalidator.toml"}
+
+// Rule sets the validate tag of every field it matches. Empty conditions
+// match anything; all given conditions have to match.
+type Rule struct {
+	// Type is a glob on the type expression as written, e.g. "int*" or "[]*"
+	Type string `json:"type" yaml:"type" toml:"type"`
+	// Kind is a glob on the inferred kind of the type: a basic type like
+	// "int8", "time.Time", "time.Duration", "uuid.UUID", "net.IP" or one of
+	// pointer, slice, array, map, struct, interface, func and chan
+	Kind string `json:"kind" yaml:"kind" toml:"kind"`
+	// Field is a regular expression on the field name
+	Field string `json:"field" yaml:"field" toml:"field"`
+	// JSON is a regular expression on the name in the existing json tag
+	JSON string `json:"json" yaml:"json" toml:"json"`
+	// Struct is a regular expression on the name of the struct type
+	Struct string `json:"struct" yaml:"struct" toml:"struct"`
+	// Priority decides between matching rules, the highest wins and the
+	// later rule wins a tie
+	Priority int `json:"priority" yaml:"priority" toml:"priority"`
+	// Validate is the tag value, an empty value leaves the field untouched.
+	// {elem} and {key} are replaced by the rules of the element and key type
+	// of pointers, slices, arrays and maps.
+	Validate string `json:"validate" yaml:"validate" toml:"validate"`
+
+	typeRe, kindRe, fieldRe, jsonRe, structRe *regexp.Regexp
+}
+
+// RuleSet is the content of a project config file, e.g. .govalidator.yaml:
+//
+//	rules:
+//	  - field: "Email$"
+//	    priority: 20
+//	    validate: "required,email"
+//	  - kind: "map"
+//	    validate: "omitempty"
+type RuleSet struct {
+	// Replace drops the default rules instead of extending them
+	Replace bool   `json:"replace" yaml:"replace" toml:"replace"`
+	Rules   []Rule `json:"rules" yaml:"rules" toml:"rules"`
+	// Backend is validate (the default), binding or ozzo, see newBackend
+	Backend string `json:"backend" yaml:"backend" toml:"backend"`
+	// Validators are custom validators registered by the project, the tag
+	// linter accepts them
+	Validators []string `json:"validators" yaml:"validators" toml:"validators"`
+}
+
+// defaultRules reproduce the built-in mapping. Field name rules have a
+// higher priority than type rules, so URL string still becomes uri.
+// Pointers are optional, so their element loses required.
+func defaultRules() []Rule {
+	return []Rule{
+		{Kind: "string", Validate: "required"},
+		{Kind: "int", Validate: "required,gte=0"},
+		{Kind: "int8", Validate: "required,gte=0"},
+		{Kind: "int16", Validate: "required,gte=0"},
+		{Kind: "int32", Validate: "required,gte=0"},
+		{Kind: "int64", Validate: "required,gte=0"},
+		{Kind: "uint*", Validate: "required"},
+		{Kind: "float*", Validate: "required,numeric"},
+		{Kind: "time.Time", Validate: "required"},
+		{Kind: "time.Duration", Validate: "required"},
+		{Kind: "uuid.UUID", Validate: "required"},
+		{Kind: "net.IP", Validate: "required"},
+		{Kind: "pointer", Validate: "omitempty,{elem}"},
+		{Kind: "slice", Validate: "omitempty,dive,{elem}"},
+		{Kind: "array", Validate: "dive,{elem}"},
+		{Kind: "map", Validate: "omitempty,dive,keys,{key},endkeys,{elem}"},
+		{Kind: "struct", Validate: "required"},
+		{Type: "types.Float", Validate: "required,gte=0"},
+		{Field: "^URL$", Priority: 10, Validate: "uri"},
+		{Field: "^Name$", Priority: 10, Validate: "gt=0"},
+	}
+}
+
+// FieldInfo is what rules are matched against. Element and key types of a
+// field are matched with an empty Name, JSON and Struct.
+type FieldInfo struct {
+	Name   string
+	Type   string
+	Kind   string
+	JSON   string
+	Struct string
+
+	desc *typeDesc
+}
+
+// loadRules returns the default rules extended or replaced by the config
+// file of the project containing filePath
+func loadRules(filePath string) (*RuleSet, error) {
+	rules := &RuleSet{Rules: defaultRules()}
+
+	path := findConfig(filepath.Dir(filePath))
+	if path != "" {
+		config, err := readConfig(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", path, err)
+		}
+		if config.Replace {
+			rules.Rules = config.Rules
+		} else {
+			rules.Rules = append(rules.Rules, config.Rules...)
+		}
+		rules.Backend, rules.Validators = config.Backend, config.Validators
+	}
+
+	if err := rules.compile(); err != nil {
+		return nil, fmt.Errorf("invalid rule in %s: %v", path, err)
+	}
+	return rules, nil
+}
+
+// findConfig walks up to the directory with go.mod and returns the config
+// file there, or "" when there is none
+func findConfig(dir string) string {
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			for _, name := range configNames {
+				if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+					return filepath.Join(dir, name)
+				}
+			}
+			return ""
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+func readConfig(path string) (*RuleSet, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &RuleSet{}
+	switch filepath.Ext(path) {
+	case ".json":
+		err = json.Unmarshal(content, config)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, config)
+	case ".toml":
+		err = toml.Unmarshal(content, config)
+	default:
+		err = fmt.Errorf("unsupported config format")
+	}
+	return config, err
+}
+
+func (r *RuleSet) compile() error {
+	for i := range r.Rules {
+		rule := &r.Rules[i]
+		var err error
+		if rule.Type != "" {
+			rule.typeRe = globToRegexp(rule.Type)
+		}
+		if rule.Kind != "" {
+			rule.kindRe = globToRegexp(rule.Kind)
+		}
+		if rule.fieldRe, err = compileOptional(rule.Field); err != nil {
+			return err
+		}
+		if rule.jsonRe, err = compileOptional(rule.JSON); err != nil {
+			return err
+		}
+		if rule.structRe, err = compileOptional(rule.Struct); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func compileOptional(expr string) (*regexp.Regexp, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	return regexp.Compile(expr)
+}
+
+// globToRegexp turns a glob with * and ? into an anchored regexp. Other
+// characters, including the brackets of slice types, match literally.
+func globToRegexp(glob string) *regexp.Regexp {
+	var expr strings.Builder
+	expr.WriteString("^")
+	for _, r := range glob {
+		switch r {
+		case '*':
+			expr.WriteString(".*")
+		case '?':
+			expr.WriteString(".")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	expr.WriteString("$")
+	return regexp.MustCompile(expr.String())
+}
+
+// Match returns the validate value of the best rule matching field
+func (r *RuleSet) Match(field FieldInfo) (string, bool) {
+	var best *Rule
+	for i := range r.Rules {
+		rule := &r.Rules[i]
+		if !rule.matches(field) {
+			continue
+		}
+		if best == nil || rule.Priority >= best.Priority {
+			best = rule
+		}
+	}
+	if best == nil || best.Validate == "" {
+		return "", false
+	}
+
+	validate := best.Validate
+	if field.desc != nil {
+		validate = strings.ReplaceAll(validate, "{elem}", r.matchType(field.desc.Elem))
+		validate = strings.ReplaceAll(validate, "{key}", r.matchType(field.desc.Key))
+	}
+	validate = cleanValidate(validate)
+	return validate, validate != ""
+}
+
+// matchType returns the validations of an element or key type
+func (r *RuleSet) matchType(desc *typeDesc) string {
+	if desc == nil {
+		return ""
+	}
+	validate, _ := r.Match(FieldInfo{Type: desc.Type, Kind: desc.Kind, desc: desc})
+	return validate
+}
+
+// cleanValidate tidies a validate tag after placeholders were replaced. It
+// drops empty items, empty keys...endkeys pairs and a trailing dive, and
+// within each level removes duplicates and required after omitempty.
+func cleanValidate(validate string) string {
+	var items []string
+	for _, item := range strings.Split(validate, ",") {
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+
+	var result []string
+	seen := make(map[string]bool)
+	optional := false
+	for i, item := range items {
+		switch item {
+		case "keys":
+			if i+1 < len(items) && items[i+1] == "endkeys" {
+				continue
+			}
+		case "endkeys":
+			if i > 0 && items[i-1] == "keys" {
+				continue
+			}
+		}
+		if item == "dive" || item == "keys" || item == "endkeys" {
+			seen, optional = make(map[string]bool), false
+		} else if seen[item] || (optional && item == "required") {
+			continue
+		}
+		seen[item] = true
+		if item == "omitempty" {
+			optional = true
+		}
+		result = append(result, item)
+	}
+	for len(result) > 0 && result[len(result)-1] == "dive" {
+		result = result[:len(result)-1]
+	}
+	return strings.Join(result, ",")
+}
+
+func (rule *Rule) matches(field FieldInfo) bool {
+	return matchOptional(rule.typeRe, field.Type) &&
+		matchOptional(rule.kindRe, field.Kind) &&
+		matchOptional(rule.fieldRe, field.Name) &&
+		matchOptional(rule.jsonRe, field.JSON) &&
+		matchOptional(rule.structRe, field.Struct)
+}
+
+func matchOptional(re *regexp.Regexp, value string) bool {
+	return re == nil || re.MatchString(value)
+}
+
+// fieldInfo describes one name of a field for rule matching
+func fieldInfo(field *ast.Field, name, structName string, tag *StructTag, resolver *typeResolver) FieldInfo {
+	desc := resolver.describe(field.Type)
+	info := FieldInfo{Name: name, Type: desc.Type, Kind: desc.Kind, Struct: structName, desc: desc}
+	if json, ok := tag.Get("json"); ok {
+		info.JSON, _, _ = strings.Cut(json, ",")
+	}
+	return info
+}
+
+// typeString prints a type expression the way Rule.Type sees it, e.g.
+// "map[string]int"; anonymous structs become "struct{...}"
+func typeString(expr ast.Expr) string {
+	if _, ok := expr.(*ast.StructType); ok {
+		return "struct{...}"
+	}
+	var buf bytes.Buffer
+	if err := printer.Fprint(&buf, token.NewFileSet(), expr); err != nil {
+		return ""
+	}
+	return buf.String()
+}
@@ -0,0 +1,586 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/neovim/go-client/nvim"
+	"gopkg.in/yaml.v3"
+)
+
+// Schema is a JSON Schema object. The same keywords are valid in OpenAPI
+// 3.1, whose schema objects are JSON Schema 2020-12.
+type Schema struct {
+	Ref                  string          `json:"$ref,omitempty" yaml:"$ref,omitempty"`
+	Description          string          `json:"description,omitempty" yaml:"description,omitempty"`
+	Type                 string          `json:"type,omitempty" yaml:"type,omitempty"`
+	Format               string          `json:"format,omitempty" yaml:"format,omitempty"`
+	Enum                 []interface{}   `json:"enum,omitempty" yaml:"enum,omitempty"`
+	Minimum              *schemaNumber   `json:"minimum,omitempty" yaml:"minimum,omitempty"`
+	ExclusiveMinimum     *schemaNumber   `json:"exclusiveMinimum,omitempty" yaml:"exclusiveMinimum,omitempty"`
+	Maximum              *schemaNumber   `json:"maximum,omitempty" yaml:"maximum,omitempty"`
+	ExclusiveMaximum     *schemaNumber   `json:"exclusiveMaximum,omitempty" yaml:"exclusiveMaximum,omitempty"`
+	MinLength            *int            `json:"minLength,omitempty" yaml:"minLength,omitempty"`
+	MaxLength            *int            `json:"maxLength,omitempty" yaml:"maxLength,omitempty"`
+	MinItems             *int            `json:"minItems,omitempty" yaml:"minItems,omitempty"`
+	MaxItems             *int            `json:"maxItems,omitempty" yaml:"maxItems,omitempty"`
+	MinProperties        *int            `json:"minProperties,omitempty" yaml:"minProperties,omitempty"`
+	MaxProperties        *int            `json:"maxProperties,omitempty" yaml:"maxProperties,omitempty"`
+	Items                *Schema         `json:"items,omitempty" yaml:"items,omitempty"`
+	Properties           *orderedSchemas `json:"properties,omitempty" yaml:"properties,omitempty"`
+	PropertyNames        *Schema         `json:"propertyNames,omitempty" yaml:"propertyNames,omitempty"`
+	AdditionalProperties *Schema         `json:"additionalProperties,omitempty" yaml:"additionalProperties,omitempty"`
+	Required             []string        `json:"required,omitempty" yaml:"required,omitempty"`
+}
+
+// schemaNumber is a numeric keyword, YAML writes whole numbers without an
+// exponent
+type schemaNumber float64
+
+func (n schemaNumber) MarshalYAML() (interface{}, error) {
+	if float64(n) == math.Trunc(float64(n)) && math.Abs(float64(n)) < 1<<53 {
+		return int64(n), nil
+	}
+	return float64(n), nil
+}
+
+// orderedSchemas keeps schemas in the order they were added, so properties
+// follow the struct fields and definitions the order of the source
+type orderedSchemas struct {
+	names   []string
+	schemas map[string]*Schema
+}
+
+func (o *orderedSchemas) Set(name string, schema *Schema) {
+	if o.schemas == nil {
+		o.schemas = make(map[string]*Schema)
+	}
+	if _, exists := o.schemas[name]; !exists {
+		o.names = append(o.names, name)
+	}
+	o.schemas[name] = schema
+}
+
+func (o *orderedSchemas) Len() int {
+	return len(o.names)
+}
+
+func (o *orderedSchemas) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, name := range o.names {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(name)
+		value, err := json.Marshal(o.schemas[name])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func (o *orderedSchemas) MarshalYAML() (interface{}, error) {
+	node := &yaml.Node{Kind: yaml.MappingNode}
+	for _, name := range o.names {
+		value := &yaml.Node{}
+		if err := value.Encode(o.schemas[name]); err != nil {
+			return nil, err
+		}
+		node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: name}, value)
+	}
+	return node, nil
+}
+
+// schemaFormats are the string formats of validators, the keys are
+// validator names and the values JSON Schema formats
+var schemaFormats = map[string]string{
+	"email":    "email",
+	"uri":      "uri",
+	"url":      "uri",
+	"http_url": "uri",
+	"uuid":     "uuid",
+	"uuid3":    "uuid",
+	"uuid4":    "uuid",
+	"uuid5":    "uuid",
+	"ipv4":     "ipv4",
+	"ipv6":     "ipv6",
+	"hostname": "hostname",
+	"fqdn":     "hostname",
+}
+
+// exportSchema returns JSON Schema or OpenAPI component definitions of
+// tagged structs. It takes the file path, the scope (struct for the struct
+// at the given line, package for every exported struct of the package), the
+// line and the format (jsonschema or openapi).
+func exportSchema(v *nvim.Nvim, args []string) (string, error) {
+	if len(args) != 4 {
+		return "", fmt.Errorf("expected file, scope, line and format, got %d arguments", len(args))
+	}
+	filePath, scope, format := args[0], args[1], args[3]
+	line, err := strconv.Atoi(args[2])
+	if err != nil {
+		return "", fmt.Errorf("invalid line %q", args[2])
+	}
+	if format != "jsonschema" && format != "openapi" {
+		return "", fmt.Errorf("unknown format %q, expected jsonschema or openapi", format)
+	}
+
+	buffer, err := v.CurrentBuffer()
+	if err != nil {
+		return "", fmt.Errorf("failed to get current buffer: %v", err)
+	}
+	lines, err := v.BufferLines(buffer, 0, -1, true)
+	if err != nil {
+		return "", fmt.Errorf("failed to get buffer lines: %v", err)
+	}
+
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, filePath, bytes.Join(lines, []byte{'\n'}), parser.ParseComments)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse file: %v", err)
+	}
+	rules, err := loadRules(filePath)
+	if err != nil {
+		return "", err
+	}
+	key := "validate"
+	if rules.Backend == "binding" {
+		key = "binding"
+	}
+
+	builder := newSchemaBuilder(newTypeResolver(fset, f, filePath), key, format)
+	var roots []string
+	switch scope {
+	case "struct":
+		spec := structAtLine(fset, f, line)
+		if spec == nil {
+			return "", fmt.Errorf("no top-level struct type at line %d", line)
+		}
+		roots = []string{spec.Name.Name}
+	case "package":
+		for _, name := range builder.order {
+			if ast.IsExported(name) {
+				roots = append(roots, name)
+			}
+		}
+		if len(roots) == 0 {
+			return "", fmt.Errorf("no exported structs in package %s", f.Name.Name)
+		}
+	default:
+		return "", fmt.Errorf("unknown scope %q, expected struct or package", scope)
+	}
+	definitions, err := builder.build(roots)
+	if err != nil {
+		return "", err
+	}
+
+	if format == "openapi" {
+		document := map[string]interface{}{
+			"components": map[string]interface{}{"schemas": definitions},
+		}
+		var out bytes.Buffer
+		encoder := yaml.NewEncoder(&out)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(document); err != nil {
+			return "", err
+		}
+		return out.String(), nil
+	}
+
+	document := struct {
+		Schema string          `json:"$schema"`
+		Ref    string          `json:"$ref,omitempty"`
+		Defs   *orderedSchemas `json:"$defs"`
+	}{Schema: "https://json-schema.org/draft/2020-12/schema", Defs: definitions}
+	if scope == "struct" {
+		document.Ref = builder.ref(roots[0])
+	}
+	content, err := json.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(content) + "\n", nil
+}
+
+// structAtLine returns the top-level struct type declared around line, the
+// outer type when the line is inside an anonymous struct. Types declared in
+// function bodies are no definitions of the package and are not found.
+func structAtLine(fset *token.FileSet, f *ast.File, line int) *ast.TypeSpec {
+	for _, decl := range f.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.TYPE {
+			continue
+		}
+		for _, s := range gen.Specs {
+			spec := s.(*ast.TypeSpec)
+			if _, isStruct := spec.Type.(*ast.StructType); isStruct &&
+				fset.Position(spec.Pos()).Line <= line && fset.Position(spec.End()).Line >= line {
+				return spec
+			}
+		}
+	}
+	return nil
+}
+
+// schemaBuilder turns the struct types of a package into schemas. Named
+// structs of the package become definitions referenced with $ref.
+type schemaBuilder struct {
+	resolver *typeResolver
+	key      string
+	format   string
+	// specs are the struct types of the package, order their names in
+	// order of appearance
+	specs map[string]*ast.TypeSpec
+	docs  map[string]*ast.CommentGroup
+	order []string
+	// pending are referenced definitions not built yet
+	pending []string
+}
+
+func newSchemaBuilder(resolver *typeResolver, key, format string) *schemaBuilder {
+	b := &schemaBuilder{
+		resolver: resolver,
+		key:      key,
+		format:   format,
+		specs:    make(map[string]*ast.TypeSpec),
+		docs:     make(map[string]*ast.CommentGroup),
+	}
+	for _, file := range resolver.files {
+		for _, decl := range file.Decls {
+			gen, ok := decl.(*ast.GenDecl)
+			if !ok || gen.Tok != token.TYPE {
+				continue
+			}
+			for _, s := range gen.Specs {
+				spec := s.(*ast.TypeSpec)
+				if _, isStruct := spec.Type.(*ast.StructType); !isStruct {
+					continue
+				}
+				if _, seen := b.specs[spec.Name.Name]; !seen {
+					b.order = append(b.order, spec.Name.Name)
+				}
+				b.specs[spec.Name.Name] = spec
+				b.docs[spec.Name.Name] = spec.Doc
+				if spec.Doc == nil && len(gen.Specs) == 1 {
+					b.docs[spec.Name.Name] = gen.Doc
+				}
+			}
+		}
+	}
+	return b
+}
+
+// build returns the definitions of roots and of every struct they reference
+func (b *schemaBuilder) build(roots []string) (*orderedSchemas, error) {
+	definitions := &orderedSchemas{}
+	b.pending = append(b.pending, roots...)
+	for len(b.pending) > 0 {
+		name := b.pending[0]
+		b.pending = b.pending[1:]
+		if _, done := definitions.schemas[name]; done {
+			continue
+		}
+		spec := b.specs[name]
+		if spec == nil {
+			return nil, fmt.Errorf("%s is not a struct type of the package", name)
+		}
+		schema := b.structSchema(spec.Type.(*ast.StructType))
+		schema.Description = docText(b.docs[name])
+		definitions.Set(name, schema)
+	}
+	return definitions, nil
+}
+
+func (b *schemaBuilder) ref(name string) string {
+	if b.format == "openapi" {
+		return "#/components/schemas/" + name
+	}
+	return "#/$defs/" + name
+}
+
+// structSchema describes the JSON encoding of a struct: exported fields by
+// their json name, embedded structs without a name flattened into it
+func (b *schemaBuilder) structSchema(structType *ast.StructType) *Schema {
+	schema := &Schema{Type: "object", Properties: &orderedSchemas{}}
+	b.addFields(schema, structType, 0)
+	if schema.Properties.Len() == 0 {
+		schema.Properties = nil
+	}
+	return schema
+}
+
+func (b *schemaBuilder) addFields(schema *Schema, structType *ast.StructType, depth int) {
+	for _, field := range structType.Fields.List {
+		tag, err := parseTagLiteral(field.Tag)
+		if err != nil {
+			continue
+		}
+		jsonName, jsonOptions := "", ""
+		if value, found := tag.Get("json"); found {
+			jsonName, jsonOptions, _ = strings.Cut(value, ",")
+		}
+		if jsonName == "-" && jsonOptions == "" {
+			continue
+		}
+
+		names := fieldNames(field)
+		if len(field.Names) == 0 {
+			if embedded := b.localStruct(field.Type); embedded != nil && jsonName == "" && depth < maxTypeDepth {
+				b.addFields(schema, embedded, depth+1)
+				continue
+			}
+		}
+
+		validate, _ := tag.Get(b.key)
+		for _, name := range names {
+			if !ast.IsExported(name) {
+				continue
+			}
+			property := b.typeSchema(field.Type, b.resolver.describe(field.Type))
+			required := b.applyValidate(property, splitValidate(validate), b.resolver.describe(field.Type))
+			if text := docText(field.Doc); text != "" {
+				property.Description = text
+			} else if text := docText(field.Comment); text != "" {
+				property.Description = text
+			}
+			if property.Ref != "" && property.Description != "" {
+				// $ref siblings are ignored by older readers, keep the
+				// reference alone
+				property.Description = ""
+			}
+			propertyName := name
+			if jsonName != "" && len(names) == 1 {
+				propertyName = jsonName
+			}
+			schema.Properties.Set(propertyName, property)
+			if required {
+				schema.Required = append(schema.Required, propertyName)
+			}
+		}
+	}
+}
+
+// localStruct returns the struct type of a package struct named by expr,
+// through a pointer
+func (b *schemaBuilder) localStruct(expr ast.Expr) *ast.StructType {
+	if star, ok := expr.(*ast.StarExpr); ok {
+		expr = star.X
+	}
+	ident, ok := expr.(*ast.Ident)
+	if !ok || b.specs[ident.Name] == nil {
+		return nil
+	}
+	return b.specs[ident.Name].Type.(*ast.StructType)
+}
+
+// typeSchema describes a type. expr is the type expression when there is
+// one, element types are described from desc alone.
+func (b *schemaBuilder) typeSchema(expr ast.Expr, desc *typeDesc) *Schema {
+	if structType, ok := expr.(*ast.StructType); ok {
+		return b.structSchema(structType)
+	}
+	if desc == nil {
+		return &Schema{}
+	}
+	if spec := b.specs[desc.Type]; spec != nil {
+		b.pending = append(b.pending, desc.Type)
+		return &Schema{Ref: b.ref(desc.Type)}
+	}
+
+	switch kind := desc.Kind; {
+	case kind == "string":
+		return &Schema{Type: "string"}
+	case kind == "bool":
+		return &Schema{Type: "boolean"}
+	case kind == "float32" || kind == "float64":
+		return &Schema{Type: "number"}
+	case kind == "int8" || kind == "int16" || kind == "int32" || kind == "uint8" || kind == "byte" || kind == "uint16":
+		return &Schema{Type: "integer", Format: "int32"}
+	case kind == "int" || kind == "int64" || kind == "time.Duration" || strings.HasPrefix(kind, "uint"):
+		return &Schema{Type: "integer", Format: "int64"}
+	case kind == "time.Time":
+		return &Schema{Type: "string", Format: "date-time"}
+	case kind == "uuid.UUID":
+		return &Schema{Type: "string", Format: "uuid"}
+	case kind == "net.IP":
+		return &Schema{Type: "string"}
+	case kind == "pointer":
+		var elem ast.Expr
+		if star, ok := expr.(*ast.StarExpr); ok {
+			elem = star.X
+		}
+		return b.typeSchema(elem, desc.Elem)
+	case kind == "slice" || kind == "array":
+		if desc.Elem != nil && (desc.Elem.Kind == "uint8" || desc.Elem.Kind == "byte") && kind == "slice" {
+			// encoding/json writes byte slices as base64
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		var elem ast.Expr
+		if array, ok := expr.(*ast.ArrayType); ok {
+			elem = array.Elt
+		}
+		return &Schema{Type: "array", Items: b.typeSchema(elem, desc.Elem)}
+	case kind == "map":
+		var elem ast.Expr
+		if m, ok := expr.(*ast.MapType); ok {
+			elem = m.Value
+		}
+		return &Schema{Type: "object", AdditionalProperties: b.typeSchema(elem, desc.Elem)}
+	case kind == "struct":
+		return &Schema{Type: "object"}
+	}
+	return &Schema{}
+}
+
+// applyValidate adds the keywords of validator items to schema and reports
+// whether the items make the value required. Items after dive apply to
+// the elements, items between keys and endkeys to the map keys.
+func (b *schemaBuilder) applyValidate(schema *Schema, items []string, desc *typeDesc) bool {
+	for desc != nil && desc.Kind == "pointer" {
+		desc = desc.Elem
+	}
+	required := false
+	for i := 0; i < len(items); i++ {
+		name, param, _ := strings.Cut(items[i], "=")
+		switch {
+		case strings.Contains(items[i], "|"):
+			// Alternatives have no single keyword
+		case name == "required":
+			required = true
+		case name == "dive":
+			var elem *typeDesc
+			if desc != nil {
+				elem = desc.Elem
+			}
+			rest := items[i+1:]
+			if len(rest) > 0 && rest[0] == "keys" {
+				end := len(rest)
+				for j, item := range rest {
+					if item == "endkeys" {
+						end = j
+						break
+					}
+				}
+				if schema.AdditionalProperties != nil && desc != nil {
+					keys := &Schema{}
+					b.applyValidate(keys, rest[1:end], desc.Key)
+					if !isEmptySchema(keys) {
+						schema.PropertyNames = keys
+					}
+				}
+				if end < len(rest) {
+					end++
+				}
+				rest = rest[end:]
+			}
+			switch {
+			case schema.Items != nil:
+				b.applyValidate(schema.Items, rest, elem)
+			case schema.AdditionalProperties != nil:
+				b.applyValidate(schema.AdditionalProperties, rest, elem)
+			}
+			return required
+		case name == "oneof":
+			for _, value := range oneofValues.FindAllString(param, -1) {
+				value = strings.Trim(value, "'")
+				if number, err := strconv.ParseFloat(value, 64); err == nil && isNumber(desc) {
+					schema.Enum = append(schema.Enum, schemaNumber(number))
+				} else {
+					schema.Enum = append(schema.Enum, value)
+				}
+			}
+		case name == "min" || name == "gte" || name == "gt" || name == "max" || name == "lte" || name == "lt" || name == "len":
+			b.applyBound(schema, name, param, desc)
+		case schemaFormats[name] != "" && param == "":
+			schema.Format = schemaFormats[name]
+		}
+	}
+	return required
+}
+
+// applyBound adds a size bound: a length for strings, a count for slices
+// and maps and a value for numbers
+func (b *schemaBuilder) applyBound(schema *Schema, name, param string, desc *typeDesc) {
+	if isNumber(desc) {
+		number, err := strconv.ParseFloat(param, 64)
+		if desc.Kind == "time.Duration" {
+			var duration time.Duration
+			duration, err = time.ParseDuration(param)
+			number = float64(duration)
+		}
+		value := schemaNumber(number)
+		if err != nil {
+			return
+		}
+		switch name {
+		case "min", "gte":
+			schema.Minimum = &value
+		case "gt":
+			schema.ExclusiveMinimum = &value
+		case "max", "lte":
+			schema.Maximum = &value
+		case "lt":
+			schema.ExclusiveMaximum = &value
+		case "len":
+			schema.Minimum, schema.Maximum = &value, &value
+		}
+		return
+	}
+
+	count, err := strconv.Atoi(param)
+	if err != nil || desc == nil {
+		return
+	}
+	var minimum, maximum **int
+	switch desc.Kind {
+	case "string":
+		minimum, maximum = &schema.MinLength, &schema.MaxLength
+	case "slice", "array":
+		minimum, maximum = &schema.MinItems, &schema.MaxItems
+	case "map":
+		minimum, maximum = &schema.MinProperties, &schema.MaxProperties
+	default:
+		return
+	}
+	switch name {
+	case "min", "gte":
+		*minimum = &count
+	case "gt":
+		count++
+		*minimum = &count
+	case "max", "lte":
+		*maximum = &count
+	case "lt":
+		count--
+		*maximum = &count
+	case "len":
+		*minimum, *maximum = &count, &count
+	}
+}
+
+func isEmptySchema(schema *Schema) bool {
+	content, _ := json.Marshal(schema)
+	return string(content) == "{}"
+}
+
+// docText returns a comment as a single line description
+func docText(doc *ast.CommentGroup) string {
+	if doc == nil {
+		return ""
+	}
+	return strings.Join(strings.Fields(doc.Text()), " ")
+}
@@ -0,0 +1,802 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/neovim/go-client/nvim"
+)
+
+// commonInitialisms are written in upper case in field names, like golint
+// wants them
+var commonInitialisms = map[string]bool{
+	"ACL": true, "API": true, "ASCII": true, "CPU": true, "CSS": true, "DNS": true,
+	"EOF": true, "GUID": true, "HTML": true, "HTTP": true, "HTTPS": true, "ID": true,
+	"IP": true, "JSON": true, "LHS": true, "QPS": true, "RAM": true, "RHS": true,
+	"RPC": true, "SLA": true, "SMTP": true, "SQL": true, "SSH": true, "TCP": true,
+	"TLS": true, "TTL": true, "UDP": true, "UI": true, "UID": true, "UUID": true,
+	"URI": true, "URL": true, "UTF8": true, "VM": true, "XML": true, "XMPP": true,
+	"XSRF": true, "XSS": true,
+}
+
+// generateStruct turns a JSON sample or CREATE TABLE statements into Go
+// structs. It takes the file path, the first and last line replaced by the
+// structs (the last line is first-1 to insert before the first line), the
+// source text and options: a struct name, nullable=pointer|sql and
+// tags=json,db,validate.
+func generateStruct(v *nvim.Nvim, args []string) (string, error) {
+	if len(args) < 4 {
+		return "", fmt.Errorf("expected file, first line, last line and source, got %d arguments", len(args))
+	}
+	filePath, source := args[0], args[3]
+	line1, err := strconv.Atoi(args[1])
+	if err != nil {
+		return "", fmt.Errorf("invalid first line %q", args[1])
+	}
+	line2, err := strconv.Atoi(args[2])
+	if err != nil {
+		return "", fmt.Errorf("invalid last line %q", args[2])
+	}
+
+	rules, err := loadRules(filePath)
+	if err != nil {
+		return "", err
+	}
+	g := &structGenerator{rules: rules, nullable: "pointer", names: make(map[string]bool)}
+	var tags []string
+	for _, option := range args[4:] {
+		key, value, found := strings.Cut(option, "=")
+		switch {
+		case !found:
+			g.name = option
+		case key == "nullable" && (value == "pointer" || value == "sql"):
+			g.nullable = value
+		case key == "tags":
+			tags = strings.Split(value, ",")
+		default:
+			return "", fmt.Errorf("unknown option %q", option)
+		}
+	}
+
+	trimmed := strings.TrimSpace(source)
+	switch {
+	case strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "["):
+		g.tags = []string{"json", "validate"}
+		err = g.fromJSON(trimmed)
+	case createTable.MatchString(trimmed):
+		g.tags = []string{"db", "json", "validate"}
+		err = g.fromSQL(trimmed)
+	default:
+		return "", fmt.Errorf("expected a JSON document or CREATE TABLE statements")
+	}
+	if err != nil {
+		return "", err
+	}
+	if tags != nil {
+		g.tags = tags
+	}
+
+	buffer, err := v.CurrentBuffer()
+	if err != nil {
+		return "", fmt.Errorf("failed to get current buffer: %v", err)
+	}
+	lines, err := v.BufferLines(buffer, 0, -1, true)
+	if err != nil {
+		return "", fmt.Errorf("failed to get buffer lines: %v", err)
+	}
+	if line1 < 1 || line1 > len(lines)+1 || line2 < line1-1 || line2 > len(lines) {
+		return "", fmt.Errorf("lines %d-%d are outside the buffer", line1, line2)
+	}
+
+	code, imports, err := g.render()
+	if err != nil {
+		return "", err
+	}
+	content := string(bytes.Join(lines, []byte{'\n'}))
+	lineStart := func(line int) int {
+		offset := 0
+		for _, text := range lines[:line-1] {
+			offset += len(text) + 1
+		}
+		return min(offset, len(content))
+	}
+
+	// Replace the source lines, or insert below the cursor line
+	edit := tagEdit{lineStart(line1), lineStart(line1), "\n" + code}
+	switch {
+	case line2 >= line1:
+		edit.end = lineStart(line2) + len(lines[line2-1])
+		edit.text = strings.TrimSuffix(code, "\n")
+	case line1 > len(lines):
+		edit.text = "\n\n" + strings.TrimSuffix(code, "\n")
+	}
+	edits := []tagEdit{edit}
+	if imports, found := importEdit(content, imports); found {
+		if imports.end > edit.start && imports.start < edit.end || imports.start == edit.start {
+			return "", fmt.Errorf("lines %d-%d overlap the imports", line1, line2)
+		}
+		edits = append(edits, imports)
+	}
+	if err := setBufferEdits(v, buffer, content, edits); err != nil {
+		return "", err
+	}
+
+	names := make([]string, len(g.structs))
+	for i, s := range g.structs {
+		names[i] = s.name
+	}
+	return fmt.Sprintf("Generated %s", strings.Join(names, ", ")), nil
+}
+
+// structGenerator collects the generated structs, nested ones after the
+// struct using them
+type structGenerator struct {
+	rules *RuleSet
+	// name overrides the name of the first struct
+	name string
+	// nullable is pointer or sql, how nullable columns are written
+	nullable string
+	tags     []string
+	structs  []*genStruct
+	names    map[string]bool
+}
+
+type genStruct struct {
+	name    string
+	comment string
+	fields  []*genField
+}
+
+type genField struct {
+	name string
+	// key is the name in the source, the json and db tag value
+	key  string
+	desc *typeDesc
+	// optional fields are missing in some JSON samples
+	optional bool
+	// maxLength is the length of varchar(n) columns
+	maxLength int
+}
+
+// newStruct reserves a unique struct name, so nested structs come after
+// the struct they are found in
+func (g *structGenerator) newStruct(name, comment string) *genStruct {
+	if len(g.structs) == 0 && g.name != "" {
+		name = g.name
+	}
+	unique := name
+	for i := 2; g.names[unique]; i++ {
+		unique = name + strconv.Itoa(i)
+	}
+	g.names[unique] = true
+	s := &genStruct{name: unique, comment: comment}
+	g.structs = append(g.structs, s)
+	return s
+}
+
+// addField adds a field with a unique Go name
+func (s *genStruct) addField(field *genField) {
+	name := field.name
+	for i := 2; s.hasField(name); i++ {
+		name = field.name + strconv.Itoa(i)
+	}
+	field.name = name
+	s.fields = append(s.fields, field)
+}
+
+func (s *genStruct) hasField(name string) bool {
+	for _, field := range s.fields {
+		if field.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// goName turns a JSON key or column name into an exported Go name
+func goName(key string) string {
+	cleaned := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return '_'
+	}, key)
+	var name strings.Builder
+	for _, word := range splitWords(cleaned) {
+		if upper := strings.ToUpper(word); commonInitialisms[upper] {
+			name.WriteString(upper)
+		} else {
+			name.WriteString(title([]string{word})[0])
+		}
+	}
+	if name.Len() == 0 {
+		return "Field"
+	}
+	if result := name.String(); !unicode.IsLetter([]rune(result)[0]) {
+		return "X" + result
+	}
+	return name.String()
+}
+
+// singular guesses the singular of a table or key name
+func singular(name string) string {
+	lowered := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lowered, "ies") && len(name) > 3:
+		return name[:len(name)-3] + "y"
+	case strings.HasSuffix(lowered, "sses"), strings.HasSuffix(lowered, "xes"), strings.HasSuffix(lowered, "ches"):
+		return name[:len(name)-2]
+	case strings.HasSuffix(lowered, "s") && !strings.HasSuffix(lowered, "ss") &&
+		!strings.HasSuffix(lowered, "us") && !strings.HasSuffix(lowered, "is") && len(name) > 1:
+		return name[:len(name)-1]
+	}
+	return name
+}
+
+var (
+	stringDesc    = &typeDesc{Type: "string", Kind: "string"}
+	boolDesc      = &typeDesc{Type: "bool", Kind: "bool"}
+	int64Desc     = &typeDesc{Type: "int64", Kind: "int64"}
+	float64Desc   = &typeDesc{Type: "float64", Kind: "float64"}
+	timeDesc      = &typeDesc{Type: "time.Time", Kind: "time.Time"}
+	interfaceDesc = &typeDesc{Type: "interface{}", Kind: "interface"}
+	// Byte slices have no element rules, diving into bytes makes no sense
+	bytesDesc = &typeDesc{Type: "[]byte", Kind: "slice"}
+)
+
+func pointerTo(elem *typeDesc) *typeDesc {
+	return &typeDesc{Type: "*" + elem.Type, Kind: "pointer", Elem: elem}
+}
+
+func sliceOf(elem *typeDesc) *typeDesc {
+	return &typeDesc{Type: "[]" + elem.Type, Kind: "slice", Elem: elem}
+}
+
+// jsonValue is a decoded JSON value that keeps the order of object keys
+type jsonValue struct {
+	// kind is object, array, string, number, bool or null
+	kind   string
+	keys   []string
+	fields map[string]*jsonValue
+	items  []*jsonValue
+	text   string
+}
+
+func decodeJSON(decoder *json.Decoder) (*jsonValue, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch t := token.(type) {
+	case json.Delim:
+		if t == '{' {
+			value := &jsonValue{kind: "object", fields: make(map[string]*jsonValue)}
+			for decoder.More() {
+				keyToken, err := decoder.Token()
+				if err != nil {
+					return nil, err
+				}
+				key := keyToken.(string)
+				field, err := decodeJSON(decoder)
+				if err != nil {
+					return nil, err
+				}
+				if _, seen := value.fields[key]; !seen {
+					value.keys = append(value.keys, key)
+				}
+				value.fields[key] = field
+			}
+			_, err := decoder.Token()
+			return value, err
+		}
+		value := &jsonValue{kind: "array"}
+		for decoder.More() {
+			item, err := decodeJSON(decoder)
+			if err != nil {
+				return nil, err
+			}
+			value.items = append(value.items, item)
+		}
+		_, err := decoder.Token()
+		return value, err
+	case string:
+		return &jsonValue{kind: "string", text: t}, nil
+	case json.Number:
+		return &jsonValue{kind: "number", text: t.String()}, nil
+	case bool:
+		return &jsonValue{kind: "bool"}, nil
+	}
+	return &jsonValue{kind: "null"}, nil
+}
+
+// fromJSON generates structs from a JSON object or an array of objects,
+// the objects of an array are merged
+func (g *structGenerator) fromJSON(source string) error {
+	decoder := json.NewDecoder(strings.NewReader(source))
+	decoder.UseNumber()
+	root, err := decodeJSON(decoder)
+	if err != nil {
+		return fmt.Errorf("invalid JSON: %v", err)
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return fmt.Errorf("invalid JSON: data after the document")
+	}
+
+	samples := []*jsonValue{root}
+	if root.kind == "array" {
+		samples = root.items
+	}
+	var objects []*jsonValue
+	for _, sample := range samples {
+		if sample.kind == "object" {
+			objects = append(objects, sample)
+		}
+	}
+	if len(objects) == 0 || len(objects) != len(samples) {
+		return fmt.Errorf("expected a JSON object or an array of objects")
+	}
+	g.jsonStruct("Object", objects)
+	return nil
+}
+
+// jsonStruct adds a struct merged from objects and returns its name
+func (g *structGenerator) jsonStruct(name string, objects []*jsonValue) string {
+	s := g.newStruct(name, "")
+	var keys []string
+	seen := make(map[string]bool)
+	for _, object := range objects {
+		for _, key := range object.keys {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	for _, key := range keys {
+		var values []*jsonValue
+		nullable, present := false, 0
+		for _, object := range objects {
+			if value, found := object.fields[key]; found {
+				present++
+				if value.kind == "null" {
+					nullable = true
+				} else {
+					values = append(values, value)
+				}
+			}
+		}
+		fieldName := goName(key)
+		desc := g.jsonType(s.name+fieldName, fieldName, values)
+		if nullable && desc.Kind != "interface" && desc.Kind != "slice" {
+			desc = pointerTo(desc)
+		}
+		s.addField(&genField{name: fieldName, key: key, desc: desc, optional: present < len(objects)})
+	}
+	return s.name
+}
+
+// jsonType infers the type of the non-null values found for one key or in
+// one array
+func (g *structGenerator) jsonType(structName, fieldName string, values []*jsonValue) *typeDesc {
+	if len(values) == 0 {
+		return interfaceDesc
+	}
+	kind := values[0].kind
+	for _, value := range values {
+		if value.kind != kind {
+			if kind == "null" {
+				kind = value.kind
+			} else if value.kind != "null" {
+				return interfaceDesc
+			}
+		}
+	}
+
+	switch kind {
+	case "object":
+		return &typeDesc{Type: g.jsonStruct(structName, values), Kind: "struct"}
+	case "array":
+		var items []*jsonValue
+		for _, value := range values {
+			for _, item := range value.items {
+				if item.kind != "null" {
+					items = append(items, item)
+				}
+			}
+		}
+		return sliceOf(g.jsonType(singular(structName), singular(fieldName), items))
+	case "string":
+		for _, value := range values {
+			if _, err := time.Parse(time.RFC3339Nano, value.text); err != nil {
+				return stringDesc
+			}
+		}
+		return timeDesc
+	case "number":
+		for _, value := range values {
+			if strings.ContainsAny(value.text, ".eE") {
+				return float64Desc
+			}
+		}
+		return int64Desc
+	case "bool":
+		return boolDesc
+	}
+	return interfaceDesc
+}
+
+// createTable finds the start of a CREATE TABLE statement up to the
+// parenthesis opening its definitions
+var createTable = regexp.MustCompile("(?is)create\\s+(?:(?:global\\s+|local\\s+)?(?:temporary|temp)\\s+|unlogged\\s+)?table\\s+(?:if\\s+not\\s+exists\\s+)?((?:[`\"\\[]?[\\w$]+[`\"\\]]?\\.)*[`\"\\[]?[\\w$]+[`\"\\]]?)\\s*\\(")
+
+// columnConstraint finds where the type of a column definition ends
+var columnConstraint = regexp.MustCompile(`(?i)\b(not\s+null|null|primary\s+key|default|references|unique|check|constraint|generated|auto_increment|autoincrement|collate|comment|on\s+update|identity)\b`)
+
+// notNullConstraint matches the constraints making a column not nullable
+var notNullConstraint = regexp.MustCompile(`\bnot\s+null\b|\bprimary\s+key\b`)
+
+// sqlComment matches -- and /* */ comments
+var sqlComment = regexp.MustCompile(`(?s)--[^\n]*|/\*.*?\*/`)
+
+// fromSQL generates a struct for every CREATE TABLE statement
+func (g *structGenerator) fromSQL(source string) error {
+	source = sqlComment.ReplaceAllString(source, "")
+	for _, match := range createTable.FindAllStringSubmatchIndex(source, -1) {
+		body, ok := parenthesized(source[match[1]-1:])
+		if !ok {
+			return fmt.Errorf("unterminated CREATE TABLE statement")
+		}
+		table := source[match[2]:match[3]]
+		if dot := strings.LastIndex(table, "."); dot >= 0 {
+			table = table[dot+1:]
+		}
+		table = unquoteIdentifier(table)
+		g.sqlStruct(table, splitTopLevel(body))
+	}
+	if len(g.structs) == 0 {
+		return fmt.Errorf("no CREATE TABLE statements found")
+	}
+	return nil
+}
+
+// sqlStruct adds the struct of one table
+func (g *structGenerator) sqlStruct(table string, definitions []string) {
+	s := g.newStruct(goName(singular(table)), fmt.Sprintf("is a row of the %s table.", table))
+
+	primary := make(map[string]bool)
+	var columns []string
+	for _, definition := range definitions {
+		words := strings.Fields(strings.ToLower(definition))
+		if len(words) == 0 {
+			continue
+		}
+		switch words[0] {
+		case "primary":
+			open := strings.Index(definition, "(")
+			if open < 0 {
+				continue
+			}
+			if body, ok := parenthesized(definition[open:]); ok {
+				for _, column := range splitTopLevel(body) {
+					primary[unquoteIdentifier(strings.Fields(column)[0])] = true
+				}
+			}
+			continue
+		case "constraint", "unique", "foreign", "check", "key", "index", "exclude", "fulltext", "spatial", "like", "period":
+			continue
+		}
+		columns = append(columns, definition)
+	}
+
+	for _, definition := range columns {
+		name, rest := splitColumnName(definition)
+		typeEnd := len(rest)
+		constraints := ""
+		if loc := columnConstraint.FindStringIndex(rest); loc != nil {
+			typeEnd, constraints = loc[0], strings.ToLower(rest[loc[0]:])
+		}
+		notNull := primary[name] || notNullConstraint.MatchString(constraints)
+		desc, maxLength := sqlType(strings.TrimSpace(rest[:typeEnd]))
+		if !notNull {
+			desc = g.nullableType(desc)
+		}
+		s.addField(&genField{name: goName(name), key: name, desc: desc, maxLength: maxLength})
+	}
+}
+
+// sqlTypes maps the base name of column types to Go types
+var sqlTypes = map[string]*typeDesc{
+	"smallint": {Type: "int16", Kind: "int16"}, "int2": {Type: "int16", Kind: "int16"},
+	"smallserial": {Type: "int16", Kind: "int16"}, "serial2": {Type: "int16", Kind: "int16"},
+	"int": {Type: "int32", Kind: "int32"}, "integer": {Type: "int32", Kind: "int32"},
+	"int4": {Type: "int32", Kind: "int32"}, "mediumint": {Type: "int32", Kind: "int32"},
+	"serial": {Type: "int32", Kind: "int32"}, "serial4": {Type: "int32", Kind: "int32"},
+	"bigint": int64Desc, "int8": int64Desc, "bigserial": int64Desc, "serial8": int64Desc,
+	"tinyint": {Type: "int8", Kind: "int8"},
+	"bool":    boolDesc, "boolean": boolDesc, "bit": boolDesc,
+	"real": {Type: "float32", Kind: "float32"}, "float4": {Type: "float32", Kind: "float32"},
+	"float": float64Desc, "float8": float64Desc, "double": float64Desc, "double precision": float64Desc,
+	"numeric": float64Desc, "decimal": float64Desc, "money": float64Desc,
+	"date": timeDesc, "time": timeDesc, "timetz": timeDesc, "datetime": timeDesc,
+	"timestamp": timeDesc, "timestamptz": timeDesc, "timestamp with time zone": timeDesc,
+	"timestamp without time zone": timeDesc, "time with time zone": timeDesc, "time without time zone": timeDesc,
+	"bytea": bytesDesc, "blob": bytesDesc, "tinyblob": bytesDesc, "mediumblob": bytesDesc,
+	"longblob": bytesDesc, "binary": bytesDesc, "varbinary": bytesDesc,
+	"json": {Type: "json.RawMessage", Kind: "slice"}, "jsonb": {Type: "json.RawMessage", Kind: "slice"},
+}
+
+// sqlType returns the Go type of a column type and the maximum length of
+// varchar(n) and char(n) columns. Unknown types are strings.
+func sqlType(columnType string) (*typeDesc, int) {
+	lowered := strings.Join(strings.Fields(strings.ToLower(columnType)), " ")
+	array := false
+	if strings.HasSuffix(lowered, "[]") || strings.HasSuffix(lowered, " array") {
+		array = true
+		lowered = strings.TrimSpace(strings.TrimSuffix(strings.TrimSuffix(lowered, "[]"), " array"))
+	}
+	unsigned := strings.Contains(lowered, " unsigned")
+	lowered = strings.NewReplacer(" unsigned", "", " zerofill", "").Replace(lowered)
+
+	base, params := lowered, ""
+	if open := strings.Index(lowered, "("); open >= 0 {
+		base = strings.TrimSpace(lowered[:open])
+		if end := strings.Index(lowered, ")"); end > open {
+			params = lowered[open+1 : end]
+			base = strings.TrimSpace(base + " " + strings.TrimSpace(lowered[end+1:]))
+		}
+	}
+
+	desc, known := sqlTypes[base]
+	maxLength := 0
+	switch {
+	case base == "tinyint" && params == "1":
+		desc = boolDesc
+	case !known:
+		desc = stringDesc
+		if n, err := strconv.Atoi(params); err == nil && strings.Contains(base, "char") {
+			maxLength = n
+		}
+	case unsigned && strings.HasPrefix(desc.Kind, "int"):
+		desc = &typeDesc{Type: "u" + desc.Type, Kind: "u" + desc.Kind}
+	}
+	if array {
+		return sliceOf(desc), 0
+	}
+	return desc, maxLength
+}
+
+// sqlNullTypes are the database/sql types of nullable columns
+var sqlNullTypes = map[string]string{
+	"string":    "sql.NullString",
+	"int64":     "sql.NullInt64",
+	"int32":     "sql.NullInt32",
+	"int16":     "sql.NullInt16",
+	"uint8":     "sql.NullByte",
+	"bool":      "sql.NullBool",
+	"float64":   "sql.NullFloat64",
+	"time.Time": "sql.NullTime",
+}
+
+// nullableType returns the type of a nullable column. Slices can hold
+// NULL already.
+func (g *structGenerator) nullableType(desc *typeDesc) *typeDesc {
+	if desc.Kind == "slice" {
+		return desc
+	}
+	if g.nullable == "sql" {
+		if null, found := sqlNullTypes[desc.Type]; found {
+			return &typeDesc{Type: null, Kind: "struct"}
+		}
+	}
+	return pointerTo(desc)
+}
+
+// parenthesized returns the content of the parentheses s starts with
+func parenthesized(s string) (string, bool) {
+	depth := 0
+	var quote rune
+	for i, r := range s {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"' || r == '`':
+			quote = r
+		case r == '(':
+			depth++
+		case r == ')':
+			depth--
+			if depth == 0 {
+				return s[1:i], true
+			}
+		}
+	}
+	return "", false
+}
+
+// splitTopLevel splits at commas outside parentheses and quotes
+func splitTopLevel(s string) []string {
+	var parts []string
+	depth, start := 0, 0
+	var quote rune
+	for i, r := range s {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"' || r == '`':
+			quote = r
+		case r == '(':
+			depth++
+		case r == ')':
+			depth--
+		case r == ',' && depth == 0:
+			parts = append(parts, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	if part := strings.TrimSpace(s[start:]); part != "" {
+		parts = append(parts, part)
+	}
+	return parts
+}
+
+// splitColumnName splits a column definition into the unquoted name and
+// the rest
+func splitColumnName(definition string) (string, string) {
+	if closing := map[byte]byte{'"': '"', '`': '`', '[': ']'}[definition[0]]; closing != 0 {
+		if end := strings.IndexByte(definition[1:], closing); end >= 0 {
+			return definition[1 : end+1], definition[end+2:]
+		}
+	}
+	end := strings.IndexFunc(definition, unicode.IsSpace)
+	if end < 0 {
+		return definition, ""
+	}
+	return definition[:end], definition[end:]
+}
+
+func unquoteIdentifier(name string) string {
+	return strings.Trim(name, "`\"[]")
+}
+
+// render prints the structs and returns the imports they need
+func (g *structGenerator) render() (string, []string, error) {
+	var out bytes.Buffer
+	importSet := make(map[string]bool)
+	for i, s := range g.structs {
+		if i > 0 {
+			out.WriteString("\n")
+		}
+		if s.comment != "" {
+			fmt.Fprintf(&out, "// %s %s\n", s.name, s.comment)
+		}
+		fmt.Fprintf(&out, "type %s struct {\n", s.name)
+		for _, field := range s.fields {
+			for prefix, path := range map[string]string{"time.": "time", "sql.": "database/sql", "json.": "encoding/json"} {
+				if strings.Contains(field.desc.Type, prefix) {
+					importSet[path] = true
+				}
+			}
+			tag := &StructTag{raw: true}
+			for _, key := range g.tags {
+				if value := g.tagValue(s, field, key); value != "" {
+					tag.Set(key, value)
+				}
+			}
+			if tag.Len() > 0 {
+				fmt.Fprintf(&out, "%s %s %s\n", field.name, field.desc.Type, tag.Literal())
+			} else {
+				fmt.Fprintf(&out, "%s %s\n", field.name, field.desc.Type)
+			}
+		}
+		out.WriteString("}\n")
+	}
+
+	// Format the structs alone, the file around them may not parse
+	formatted, err := format.Source(append([]byte("package p\n\n"), out.Bytes()...))
+	if err != nil {
+		return "", nil, fmt.Errorf("generated code does not compile: %v", err)
+	}
+	code := strings.TrimPrefix(string(formatted), "package p\n\n")
+
+	imports := make([]string, 0, len(importSet))
+	for path := range importSet {
+		imports = append(imports, path)
+	}
+	sort.Strings(imports)
+	return code, imports, nil
+}
+
+// tagValue returns the value of one tag key of a field
+func (g *structGenerator) tagValue(s *genStruct, field *genField, key string) string {
+	switch key {
+	case "json":
+		if field.optional {
+			return field.key + ",omitempty"
+		}
+		return field.key
+	case "validate", "binding":
+		// The validator can't look into sql.Null* types
+		if strings.HasPrefix(field.desc.Type, "sql.") {
+			return ""
+		}
+		validate, _ := g.rules.Match(FieldInfo{
+			Name: field.name, Type: field.desc.Type, Kind: field.desc.Kind,
+			JSON: field.key, Struct: s.name, desc: field.desc,
+		})
+		// Optional and nullable values are only checked when set
+		if field.optional || field.desc.Kind == "pointer" {
+			validate = "omitempty," + validate
+		}
+		if field.maxLength > 0 {
+			validate += ",max=" + strconv.Itoa(field.maxLength)
+		}
+		// omitempty alone checks nothing
+		if validate = cleanValidate(validate); validate == "omitempty" {
+			return ""
+		}
+		return validate
+	}
+	return field.key
+}
+
+// importEdit returns the edit adding the missing imports to the import
+// declarations of a file, content that doesn't parse gets no edit
+func importEdit(content string, paths []string) (tagEdit, bool) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "", content, parser.ImportsOnly)
+	if err != nil {
+		return tagEdit{}, false
+	}
+	imported := make(map[string]bool)
+	for _, spec := range f.Imports {
+		path, _ := strconv.Unquote(spec.Path.Value)
+		imported[path] = true
+	}
+	var missing []string
+	for _, path := range paths {
+		if !imported[path] {
+			missing = append(missing, strconv.Quote(path))
+		}
+	}
+	if len(missing) == 0 {
+		return tagEdit{}, false
+	}
+
+	// Add to the last import declaration, or after the package clause
+	var last *ast.GenDecl
+	for _, decl := range f.Decls {
+		if gen, ok := decl.(*ast.GenDecl); ok && gen.Tok == token.IMPORT {
+			last = gen
+		}
+	}
+	switch {
+	case last == nil:
+		offset := fset.Position(f.Name.End()).Offset
+		return tagEdit{offset, offset, "\n\nimport (\n\t" + strings.Join(missing, "\n\t") + "\n)"}, true
+	case last.Rparen.IsValid():
+		offset := fset.Position(last.Rparen).Offset
+		return tagEdit{offset, offset, "\t" + strings.Join(missing, "\n\t") + "\n"}, true
+	}
+
+	// Turn a single import into a group
+	start, end := fset.Position(last.Pos()).Offset, fset.Position(last.End()).Offset
+	spec := content[fset.Position(last.Specs[0].Pos()).Offset:end]
+	return tagEdit{start, end, "import (\n\t" + spec + "\n\t" + strings.Join(missing, "\n\t") + "\n)"}, true
+}
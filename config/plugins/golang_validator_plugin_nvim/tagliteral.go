@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"strconv"
+	"strings"
+)
+
+// StructTag is a struct tag parsed by the rules of reflect.StructTag. Pairs
+// keep their order and the exact quoting they were written with, so a tag
+// prints back unchanged unless a pair was set or deleted.
+type StructTag struct {
+	pairs []tagPair
+	// raw is true for `...` literals and false for "..." ones
+	raw bool
+}
+
+// tagPair is one key:"value" of a tag, quoted holds the value as written
+type tagPair struct {
+	key    string
+	value  string
+	quoted string
+	// offset is where the key starts in the tag, -1 for pairs set later
+	offset int
+}
+
+// parseTagLiteral parses the tag of a field, a missing or empty literal is
+// an empty raw tag
+func parseTagLiteral(lit *ast.BasicLit) (*StructTag, error) {
+	if lit == nil || lit.Value == "" {
+		return &StructTag{raw: true}, nil
+	}
+	content, err := strconv.Unquote(lit.Value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tag literal %s", lit.Value)
+	}
+	tag, err := parseStructTag(content)
+	if err != nil {
+		return nil, err
+	}
+	tag.raw = strings.HasPrefix(lit.Value, "`")
+	return tag, nil
+}
+
+// parseStructTag parses the content of a tag literal. It follows
+// reflect.StructTag.Lookup but reports malformed tags instead of ignoring
+// the rest of the tag.
+func parseStructTag(tag string) (*StructTag, error) {
+	result := &StructTag{raw: true}
+	length := len(tag)
+	for {
+		tag = strings.TrimLeft(tag, " ")
+		offset := length - len(tag)
+		if tag == "" {
+			return result, nil
+		}
+
+		// A key is a non-empty run of non-space, non-control characters
+		// other than quote and colon
+		i := 0
+		for i < len(tag) && tag[i] > ' ' && tag[i] != ':' && tag[i] != '"' && tag[i] != 0x7f {
+			i++
+		}
+		if i == 0 || i+1 >= len(tag) || tag[i] != ':' || tag[i+1] != '"' {
+			return nil, fmt.Errorf("malformed struct tag at %q", tag)
+		}
+		key := tag[:i]
+		tag = tag[i+1:]
+
+		// The value is a Go string literal that ends at the first unescaped quote
+		i = 1
+		for i < len(tag) && tag[i] != '"' {
+			if tag[i] == '\\' {
+				i++
+			}
+			i++
+		}
+		if i >= len(tag) {
+			return nil, fmt.Errorf("unterminated value of key %q", key)
+		}
+		quoted := tag[:i+1]
+		tag = tag[i+1:]
+
+		value, err := strconv.Unquote(quoted)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of key %q: %v", key, err)
+		}
+		result.pairs = append(result.pairs, tagPair{key: key, value: value, quoted: quoted, offset: offset})
+	}
+}
+
+// Get returns the value of key
+func (t *StructTag) Get(key string) (string, bool) {
+	for _, pair := range t.pairs {
+		if pair.key == key {
+			return pair.value, true
+		}
+	}
+	return "", false
+}
+
+// Set replaces the value of key in place, or appends the key when it is new
+func (t *StructTag) Set(key, value string) {
+	pair := tagPair{key: key, value: value, quoted: strconv.Quote(value), offset: -1}
+	for i := range t.pairs {
+		if t.pairs[i].key == key {
+			if t.pairs[i].value != value {
+				t.pairs[i] = pair
+			}
+			return
+		}
+	}
+	t.pairs = append(t.pairs, pair)
+}
+
+// Delete removes key from the tag
+func (t *StructTag) Delete(key string) {
+	pairs := t.pairs[:0]
+	for _, pair := range t.pairs {
+		if pair.key != key {
+			pairs = append(pairs, pair)
+		}
+	}
+	t.pairs = pairs
+}
+
+// Len returns the number of pairs
+func (t *StructTag) Len() int {
+	return len(t.pairs)
+}
+
+// String returns the content of the tag, pairs separated by a space
+func (t *StructTag) String() string {
+	parts := make([]string, len(t.pairs))
+	for i, pair := range t.pairs {
+		parts[i] = pair.key + ":" + pair.quoted
+	}
+	return strings.Join(parts, " ")
+}
+
+// Literal returns the tag as a Go literal of the kind it was parsed from.
+// A raw literal can't hold a backquote, such tags fall back to "...".
+func (t *StructTag) Literal() string {
+	content := t.String()
+	if t.raw && !strings.Contains(content, "`") {
+		return "`" + content + "`"
+	}
+	return strconv.Quote(content)
+}
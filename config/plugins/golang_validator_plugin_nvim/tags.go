@@ -0,0 +1,351 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/neovim/go-client/nvim"
+)
+
+// tagKeys are the keys transform works on when none are given
+var tagKeys = []string{"json", "yaml", "db", "mapstructure", "xml", "bson"}
+
+// TagScope selects the fields a tag operation applies to
+type TagScope string
+
+const (
+	// ScopeStruct is every field of the innermost struct around a line
+	ScopeStruct TagScope = "struct"
+	// ScopeRange is every field starting inside a range of lines
+	ScopeRange TagScope = "range"
+	// ScopeFile is every field of the file
+	ScopeFile TagScope = "file"
+)
+
+// TagOperation is what manageTags does with the selected fields:
+//
+//	add json,omitempty yaml case=camel
+//	remove json yaml,omitempty
+//	clear
+//	transform json case=kebab
+type TagOperation struct {
+	Op string
+	// Keys are the tag keys, each with the options to add or remove
+	Keys []TagKey
+	// Case is the naming of new and transformed names
+	Case string
+}
+
+// TagKey is a key with options, written as "json,omitempty"
+type TagKey struct {
+	Key     string
+	Options []string
+}
+
+// manageTags adds, removes, clears or transforms struct tags of the current
+// buffer. Arguments: file path, scope, first line, last line, operation and
+// its keys and options.
+func manageTags(v *nvim.Nvim, args []string) (string, error) {
+	if len(args) < 5 {
+		return "", fmt.Errorf("expected file, scope, lines and operation, got %d arguments", len(args))
+	}
+	filePath, scope := args[0], TagScope(args[1])
+	startLine, err := strconv.Atoi(args[2])
+	if err != nil {
+		return "", fmt.Errorf("invalid start line %q", args[2])
+	}
+	endLine, err := strconv.Atoi(args[3])
+	if err != nil {
+		return "", fmt.Errorf("invalid end line %q", args[3])
+	}
+	operation, err := parseTagOperation(args[4], args[5:])
+	if err != nil {
+		return "", err
+	}
+
+	buffer, err := v.CurrentBuffer()
+	if err != nil {
+		return "", fmt.Errorf("failed to get current buffer: %v", err)
+	}
+	lines, err := v.BufferLines(buffer, 0, -1, true)
+	if err != nil {
+		return "", fmt.Errorf("failed to get buffer lines: %v", err)
+	}
+	fileContent := string(bytes.Join(lines, []byte{'\n'}))
+
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, filePath, fileContent, parser.ParseComments|parser.AllErrors)
+	var syntaxErrors scanner.ErrorList
+	if err != nil && (f == nil || !errors.As(err, &syntaxErrors)) {
+		return "", fmt.Errorf("failed to parse file: %v", err)
+	}
+
+	fields := selectFields(fset, f, scope, startLine, endLine, syntaxErrors)
+	if len(fields) == 0 {
+		return "", fmt.Errorf("no struct fields in %s scope", scope)
+	}
+
+	var edits []tagEdit
+	for _, field := range fields {
+		edit, changed, err := operation.apply(fset, field)
+		if err != nil {
+			line := fset.Position(field.Pos()).Line
+			return "", fmt.Errorf("line %d: %v", line, err)
+		}
+		if changed {
+			edits = append(edits, edit)
+		}
+	}
+	if len(edits) == 0 {
+		return "", nil
+	}
+
+	if err := setBufferEdits(v, buffer, fileContent, edits); err != nil {
+		return "", err
+	}
+	if len(syntaxErrors) > 0 {
+		return fmt.Sprintf("Tolerated %d syntax errors", len(syntaxErrors)), nil
+	}
+	return "", nil
+}
+
+// parseTagOperation parses the operation name and its arguments, a list of
+// keys with options and "case=..." settings
+func parseTagOperation(op string, args []string) (*TagOperation, error) {
+	operation := &TagOperation{Op: op, Case: "snake"}
+	for _, arg := range args {
+		if name, value, ok := strings.Cut(arg, "="); ok {
+			if name != "case" {
+				return nil, fmt.Errorf("unknown setting %q", name)
+			}
+			if _, known := caseConverters[value]; !known {
+				return nil, fmt.Errorf("unknown case %q, expected snake, camel, kebab or pascal", value)
+			}
+			operation.Case = value
+			continue
+		}
+		parts := strings.Split(arg, ",")
+		operation.Keys = append(operation.Keys, TagKey{Key: parts[0], Options: parts[1:]})
+	}
+
+	switch op {
+	case "add", "remove":
+		if len(operation.Keys) == 0 {
+			return nil, fmt.Errorf("%s needs at least one tag key", op)
+		}
+	case "clear":
+	case "transform":
+		if len(operation.Keys) == 0 {
+			for _, key := range tagKeys {
+				operation.Keys = append(operation.Keys, TagKey{Key: key})
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown operation %q, expected add, remove, clear or transform", op)
+	}
+	return operation, nil
+}
+
+// selectFields returns the fields in scope. Structs overlapping a
+// syntax error are skipped.
+func selectFields(fset *token.FileSet, f *ast.File, scope TagScope, startLine, endLine int, syntaxErrors scanner.ErrorList) []*ast.Field {
+	var fields []*ast.Field
+	var innermost *ast.StructType
+	ast.Inspect(f, func(n ast.Node) bool {
+		x, ok := n.(*ast.StructType)
+		if !ok {
+			return true
+		}
+		if !x.End().IsValid() || overlapsErrors(fset, x, syntaxErrors) {
+			return false
+		}
+		switch scope {
+		case ScopeStruct:
+			if fset.Position(x.Pos()).Line <= startLine && fset.Position(x.End()).Line >= startLine {
+				innermost = x
+			}
+		case ScopeRange:
+			for _, field := range x.Fields.List {
+				line := fset.Position(field.Pos()).Line
+				if line >= startLine && line <= endLine {
+					fields = append(fields, field)
+				}
+			}
+		case ScopeFile:
+			fields = append(fields, x.Fields.List...)
+		}
+		return true
+	})
+	if innermost != nil {
+		fields = innermost.Fields.List
+	}
+	return fields
+}
+
+// apply runs the operation on the tag of field and returns the edit
+// replacing the tag literal, or inserting one after the field type
+func (op *TagOperation) apply(fset *token.FileSet, field *ast.Field) (tagEdit, bool, error) {
+	tag, err := parseTagLiteral(field.Tag)
+	if err != nil {
+		return tagEdit{}, false, err
+	}
+
+	// Embedded fields and fields with several names get no generated name,
+	// they would clash in the encoded output
+	name := ""
+	if len(field.Names) == 1 {
+		name = caseConverters[op.Case](splitWords(field.Names[0].Name))
+	}
+	switch op.Op {
+	case "add":
+		for _, key := range op.Keys {
+			value, found := tag.Get(key.Key)
+			if !found {
+				if name == "" {
+					continue
+				}
+				value = name
+			}
+			tagName, options := splitTagValue(value)
+			for _, option := range key.Options {
+				if !containsString(options, option) {
+					options = append(options, option)
+				}
+			}
+			tag.Set(key.Key, joinTagValue(tagName, options))
+		}
+	case "remove":
+		for _, key := range op.Keys {
+			value, found := tag.Get(key.Key)
+			if !found {
+				continue
+			}
+			if len(key.Options) == 0 {
+				tag.Delete(key.Key)
+				continue
+			}
+			tagName, options := splitTagValue(value)
+			kept := options[:0]
+			for _, option := range options {
+				if !containsString(key.Options, option) {
+					kept = append(kept, option)
+				}
+			}
+			tag.Set(key.Key, joinTagValue(tagName, kept))
+		}
+	case "clear":
+		tag.pairs = nil
+	case "transform":
+		for _, key := range op.Keys {
+			// "-" and empty names have a meaning of their own
+			value, found := tag.Get(key.Key)
+			tagName, options := splitTagValue(value)
+			if found && name != "" && tagName != "" && tagName != "-" {
+				tag.Set(key.Key, joinTagValue(name, options))
+			}
+		}
+	}
+
+	if field.Tag == nil {
+		if tag.Len() == 0 {
+			return tagEdit{}, false, nil
+		}
+		offset := fset.Position(field.Type.End()).Offset
+		return tagEdit{offset, offset, " " + tag.Literal()}, true, nil
+	}
+	updated := tag.Literal()
+	if updated == field.Tag.Value {
+		return tagEdit{}, false, nil
+	}
+	start, end := fset.Position(field.Tag.Pos()).Offset, fset.Position(field.Tag.End()).Offset
+	if tag.Len() == 0 {
+		// Drop the literal with the space separating it from the type
+		start = fset.Position(field.Type.End()).Offset
+		return tagEdit{start, end, ""}, true, nil
+	}
+	return tagEdit{start, end, updated}, true, nil
+}
+
+// splitTagValue splits a value like "name,omitempty" into the name and the
+// options
+func splitTagValue(value string) (string, []string) {
+	parts := strings.Split(value, ",")
+	return parts[0], parts[1:]
+}
+
+func joinTagValue(name string, options []string) string {
+	return strings.Join(append([]string{name}, options...), ",")
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// caseConverters join the words of a field name
+var caseConverters = map[string]func([]string) string{
+	"snake": func(words []string) string { return strings.Join(lower(words), "_") },
+	"kebab": func(words []string) string { return strings.Join(lower(words), "-") },
+	"camel": func(words []string) string {
+		words = title(words)
+		if len(words) > 0 {
+			words[0] = strings.ToLower(words[0])
+		}
+		return strings.Join(words, "")
+	},
+	"pascal": func(words []string) string { return strings.Join(title(words), "") },
+}
+
+// splitWords splits a Go identifier into words, keeping acronyms together:
+// "HTTPServerID" becomes "HTTP", "Server", "ID"
+func splitWords(name string) []string {
+	var words []string
+	runes := []rune(name)
+	start := 0
+	for i := 1; i <= len(runes); i++ {
+		if i < len(runes) && runes[i] != '_' && runes[i] != '-' {
+			prev, cur := runes[i-1], runes[i]
+			boundary := unicode.IsLower(prev) && unicode.IsUpper(cur) ||
+				unicode.IsUpper(prev) && unicode.IsUpper(cur) && i+1 < len(runes) && unicode.IsLower(runes[i+1]) ||
+				prev == '_' || prev == '-'
+			if !boundary {
+				continue
+			}
+		}
+		if word := strings.Trim(string(runes[start:i]), "_-"); word != "" {
+			words = append(words, word)
+		}
+		start = i
+	}
+	return words
+}
+
+func lower(words []string) []string {
+	result := make([]string, len(words))
+	for i, word := range words {
+		result[i] = strings.ToLower(word)
+	}
+	return result
+}
+
+func title(words []string) []string {
+	result := make([]string, len(words))
+	for i, word := range words {
+		runes := []rune(strings.ToLower(word))
+		runes[0] = unicode.ToUpper(runes[0])
+		result[i] = string(runes)
+	}
+	return result
+}